@@ -26,6 +26,18 @@ func (agentState *GeneralAgentInfo) VoteForAction(initialGoal, actionDescription
 	}
 	votesCacheLock.RUnlock()
 
+	if agentState.useLogprobsForVoting() {
+		rating, err := agentState.ScoreWithLogprobs("action-voter", votingQuestion(initialGoal, actionDescription))
+		if err == nil {
+			votesCacheLock.Lock()
+			votesCache[actionDescription] = rating
+			votesCacheLock.Unlock()
+
+			return rating, nil
+		}
+		// engine doesn't report logprobs, let's sample votes then
+	}
+
 	systemMessage := `Given goal:
 
 %s
@@ -152,3 +164,12 @@ retryVoting:
 
 	return finalRating, nil
 }
+
+func votingQuestion(initialGoal, actionDescription string) string {
+	return fmt.Sprintf(`Given goal:
+%s
+And a command:
+%s
+How likely is executing the command will lead to achieving the goal?`,
+		"\n```\n"+initialGoal+"\n```\n", "\n```\n"+actionDescription+"\n```\n")
+}
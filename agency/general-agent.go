@@ -7,7 +7,6 @@ import (
 	os_client "github.com/d0rc/agent-os/os-client"
 	"github.com/d0rc/agent-os/tools"
 	pongo2 "github.com/flosch/pongo2/v6"
-	"strings"
 	"sync"
 	"time"
 )
@@ -33,6 +32,7 @@ type GeneralAgentInfo struct {
 	terminalsLock      sync.RWMutex
 	terminalsVisitsMap map[string]int
 	terminalsVotesMap  map[string]float32
+	terminalsScoring   map[string]struct{} // terminals, which are being scored now
 
 	ForkCallback       func(name, goal string) chan string
 	FinalReportChannel chan string
@@ -68,6 +68,7 @@ func NewGeneralAgentState(client *os_client.AgentOSClient, systemName string, co
 
 		terminalsVisitsMap: make(map[string]int),
 		terminalsVotesMap:  make(map[string]float32),
+		terminalsScoring:   make(map[string]struct{}),
 		terminalsLock:      sync.RWMutex{},
 	}
 
@@ -144,20 +145,14 @@ func getChatSignature(chat []*engines.Message) string {
 	return signature
 }
 
-func chatToRawPrompt(sample []*engines.Message) string {
-	// following well known ### Instruction ### Assistant ### User format
-	rawPrompt := strings.Builder{}
-	for _, message := range sample {
-		switch message.Role {
-		case engines.ChatRoleSystem:
-			rawPrompt.WriteString(fmt.Sprintf("### Instruction:\n%s\n", message.Content))
-		case engines.ChatRoleAssistant:
-			rawPrompt.WriteString(fmt.Sprintf("### Assistant:\n%s\n", message.Content))
-		case engines.ChatRoleUser:
-			rawPrompt.WriteString(fmt.Sprintf("### User:\n%s\n", message.Content))
-		}
+// chatToMessages copies roles and contents of the chat, chat template
+// is applied by the server, according to the model job is routed to
+func chatToMessages(sample []*engines.Message) []engines.Message {
+	messages := make([]engines.Message, len(sample))
+	for idx, message := range sample {
+		messages[idx].Role = message.Role
+		messages[idx].Content = message.Content
 	}
-	rawPrompt.WriteString("### Assistant:\n")
 
-	return rawPrompt.String()
+	return messages
 }
@@ -0,0 +1,98 @@
+package agency
+
+import (
+	"fmt"
+	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+	"github.com/d0rc/agent-os/cmds"
+	"github.com/d0rc/agent-os/engines"
+	os_client "github.com/d0rc/agent-os/os-client"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rating is a single digit, so it's a single token for any tokenizer
+const logprobRatingMax = 9
+
+// ratingFromLogprobs computes expected rating from the distribution of the first digit
+// generated, result is scaled to 0..10, false is returned if there are no digits
+func ratingFromLogprobs(logprobs []engines.TokenLogprob) (float32, bool) {
+	for _, tokenLogprob := range logprobs {
+		if _, isDigit := parseRatingToken(tokenLogprob.Token); !isDigit {
+			continue
+		}
+
+		candidates := tokenLogprob.TopLogprobs
+		if len(candidates) == 0 {
+			candidates = map[string]float64{tokenLogprob.Token: tokenLogprob.Logprob}
+		}
+
+		totalProbability := 0.0
+		expectedRating := 0.0
+		for token, logprob := range candidates {
+			rating, isDigit := parseRatingToken(token)
+			if !isDigit {
+				continue
+			}
+			probability := math.Exp(logprob)
+			totalProbability += probability
+			expectedRating += probability * float64(rating)
+		}
+		if totalProbability == 0 {
+			return 0, false
+		}
+
+		return float32(expectedRating / totalProbability * 10 / logprobRatingMax), true
+	}
+
+	return 0, false
+}
+
+func parseRatingToken(token string) (int, bool) {
+	rating, err := strconv.Atoi(strings.TrimSpace(token))
+	if err != nil || rating < 0 || rating > logprobRatingMax {
+		return 0, false
+	}
+
+	return rating, true
+}
+
+// ScoreWithLogprobs asks a question, which is answered by a single digit, and returns
+// the rating expected from the digit's distribution, it takes a single short completion,
+// instead of sampling many of them, error is returned if engine doesn't report logprobs
+func (agentState *GeneralAgentInfo) ScoreWithLogprobs(processName, question string) (float32, error) {
+	prompt := fmt.Sprintf("%s\nRespond with a single digit from 0 to %d, where 0 is the worst and %d is the best.\nRating: ",
+		question, logprobRatingMax, logprobRatingMax)
+
+	serverResponse, err := agentState.Server.RunRequest(&cmds.ClientRequest{
+		ProcessName: processName,
+		Priority:    borrow_engine.PRIO_User,
+		GetCompletionRequests: []cmds.GetCompletionRequest{
+			{
+				RawPrompt:   prompt,
+				MinResults:  1,
+				Temperature: 0,
+				SamplingParams: engines.SamplingParams{
+					MaxTokens: 2,
+					Logprobs:  logprobRatingMax + 1,
+				},
+			},
+		},
+	}, 120*time.Second, os_client.REP_IO)
+	if err != nil {
+		return 0, fmt.Errorf("error running scorer inference request: %w", err)
+	}
+
+	if len(serverResponse.GetCompletionResponse) == 0 || serverResponse.GetCompletionResponse[0] == nil {
+		return 0, fmt.Errorf("no completions returned")
+	}
+
+	for _, logprobs := range serverResponse.GetCompletionResponse[0].ChoicesLogprobs {
+		if rating, ok := ratingFromLogprobs(logprobs); ok {
+			return rating, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no rating logprobs returned, engine might not support logprobs")
+}
@@ -0,0 +1,29 @@
+package agency
+
+import (
+	"github.com/d0rc/agent-os/engines"
+	"math"
+	"testing"
+)
+
+func TestRatingFromLogprobs(t *testing.T) {
+	rating, ok := ratingFromLogprobs([]engines.TokenLogprob{
+		{Token: " ", Logprob: 0},
+		{Token: "9", Logprob: math.Log(0.5), TopLogprobs: map[string]float64{
+			"9":    math.Log(0.5),
+			" 0":   math.Log(0.25),
+			"0":    math.Log(0.25),
+			"none": math.Log(0.1),
+		}},
+	})
+	if !ok {
+		t.Fatalf("rating is not found")
+	}
+	if math.Abs(float64(rating)-5) > 1e-4 {
+		t.Fatalf("expected rating of 5, got %f", rating)
+	}
+
+	if _, ok := ratingFromLogprobs([]engines.TokenLogprob{{Token: "yes"}}); ok {
+		t.Fatalf("no rating expected without digits")
+	}
+}
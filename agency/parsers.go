@@ -23,7 +23,10 @@ type GeneralAgentSettings struct {
 	PromptBased     *PromptBasedAgentSettings `yaml:"prompt-based"`
 	LifeCycleType   LifeCycleType             `yaml:"life-cycle-type"`
 	LifeCycleLength int                       `yaml:"life-cycle-length"`
+	// single completion with logprobs instead of sampling votes, if engine supports it
+	LogprobsVoting  bool `yaml:"logprobs-voting"`
 	renderedJson    string
+	renderedGrammar string
 }
 
 type ResponseFormatType map[string]interface{}
@@ -65,9 +68,11 @@ func ParseAgency(data []byte) ([]*AgentSettings, error) {
 		return nil, err
 	}
 
-	_, responseJson, err := ParseYAML(data)
-	//fmt.Printf("res: %v\n", res)
-	//fmt.Printf("responseJson: %v\n", responseJson)
+	ctx, err := parseResponseFormats(data)
+	if err != nil {
+		return nil, err
+	}
+	responseJson := ctx.finalJson
 
 	for _, setting := range settings {
 		fixMap(setting.Agent.PromptBased.ResponseFormat)
@@ -77,6 +82,9 @@ func ParseAgency(data []byte) ([]*AgentSettings, error) {
 	// save json renderings in settings
 	for idx, setting := range settings {
 		setting.Agent.renderedJson = responseJson[idx]
+		if idx < len(ctx.finalStructure) {
+			setting.Agent.renderedGrammar = renderGBNFGrammar(ctx.finalStructure[idx])
+		}
 	}
 	return settings, nil
 }
@@ -90,10 +98,19 @@ type Node struct {
 func ParseYAML(data []byte) ([]Node, []string, error) {
 	var nodes []Node
 
+	ctx, err := parseResponseFormats(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nodes, ctx.finalJson, nil
+}
+
+func parseResponseFormats(data []byte) (*responseFormatJsonContext, error) {
 	decoder := yaml.NewDecoder(io.Reader(bytes.NewReader(data)))
 	var node yaml.Node
 	if err := decoder.Decode(&node); err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	// ok, so let's dive into the yaml AST
 	// until we find the response-format node
@@ -105,13 +122,18 @@ func ParseYAML(data []byte) ([]Node, []string, error) {
 	}
 	buildResponseFormatJson(&node, ctx)
 
-	return nodes, ctx.finalJson, nil
+	return ctx, nil
 }
 
 func (settings *AgentSettings) GetResponseJSONFormat() string {
 	return settings.Agent.renderedJson
 }
 
+// GetResponseGrammar returns GBNF grammar matching agent's response-format
+func (settings *AgentSettings) GetResponseGrammar() string {
+	return settings.Agent.renderedGrammar
+}
+
 func (settings *AgentSettings) GetAgentInitialGoal() string {
 	promptLines := strings.Split(settings.Agent.PromptBased.Prompt, "\n")
 	return promptLines[0]
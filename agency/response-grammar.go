@@ -0,0 +1,57 @@
+package agency
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// generic JSON rules, same as llama.cpp's grammars/json.gbnf
+const gbnfJsonRules = `value ::= object | array | string | number | ("true" | "false" | "null") ws
+object ::= "{" ws ( string ":" ws value ("," ws string ":" ws value)* )? "}" ws
+array ::= "[" ws ( value ("," ws value)* )? "]" ws
+string ::= "\"" ( [^"\\] | "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F]) )* "\"" ws
+number ::= ("-"? ([0-9] | [1-9] [0-9]*)) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws
+ws ::= ([ \t\n] ws)?
+`
+
+// renderGBNFGrammar builds GBNF grammar, which accepts only JSON objects having
+// keys of response-format in the same order, values of the leaves can be any JSON
+func renderGBNFGrammar(structure []MapKV) string {
+	if len(structure) == 0 {
+		return ""
+	}
+
+	rules := []string{""}
+	rules[0] = "root ::= " + renderGBNFObject(structure, "obj", &rules)
+
+	return strings.Join(rules, "\n") + "\n" + gbnfJsonRules
+}
+
+func renderGBNFObject(structure []MapKV, name string, rules *[]string) string {
+	if len(structure) == 0 {
+		// no keys defined, any object will do
+		return "object"
+	}
+
+	buffer := strings.Builder{}
+	buffer.WriteString(`"{" ws`)
+	for idx, kv := range structure {
+		if idx > 0 {
+			buffer.WriteString(` "," ws`)
+		}
+		keyJson, _ := json.Marshal(kv.Key)
+		buffer.WriteString(fmt.Sprintf(" %s ws \":\" ws ", strconv.Quote(string(keyJson))))
+		if kv.Value != nil {
+			buffer.WriteString("value")
+		} else {
+			ruleName := fmt.Sprintf("%s-%d", name, idx)
+			*rules = append(*rules, fmt.Sprintf("%s ::= %s", ruleName, renderGBNFObject(kv.InnerMap, ruleName, rules)))
+			buffer.WriteString(ruleName)
+		}
+	}
+	buffer.WriteString(` "}" ws`)
+
+	return buffer.String()
+}
@@ -0,0 +1,71 @@
+package agency
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRenderGBNFGrammar(t *testing.T) {
+	grammar := renderGBNFGrammar([]MapKV{
+		{Key: "thoughts", Value: "place your thoughts here"},
+		{Key: "command", InnerMap: []MapKV{
+			{Key: "name", Value: "command name"},
+			{Key: "args", InnerMap: nil},
+		}},
+	})
+
+	// nested rules are added before the rule using them
+	expected := `root ::= "{" ws "\"thoughts\"" ws ":" ws value "," ws "\"command\"" ws ":" ws obj-1 "}" ws
+obj-1-1 ::= object
+obj-1 ::= "{" ws "\"name\"" ws ":" ws value "," ws "\"args\"" ws ":" ws obj-1-1 "}" ws
+` + gbnfJsonRules
+	if grammar != expected {
+		t.Fatalf("unexpected grammar:\n%s", grammar)
+	}
+
+	if renderGBNFGrammar(nil) != "" {
+		t.Fatalf("no grammar expected without response format")
+	}
+}
+
+// key is JSON encoded, and then quoted as GBNF literal, GBNF literal escapes
+// are the same as Go's, so unquoting the literal gives key's JSON, model has to emit
+func TestRenderGBNFGrammarEscapesKeys(t *testing.T) {
+	keys := []string{`say "hi"`, `C:\path\to`, `\"`, "tab\there", "a<b>&c", "ключ"}
+	structure := make([]MapKV, len(keys))
+	for idx, key := range keys {
+		structure[idx] = MapKV{Key: key, Value: ""}
+	}
+	grammar := renderGBNFGrammar(structure)
+	root := strings.SplitN(grammar, "\n", 2)[0]
+
+	literals := regexp.MustCompile(`"(?:[^"\\]|\\.)*"`).FindAllString(root, -1)
+	decoded := make([]string, 0, len(keys))
+	for _, literal := range literals {
+		text, err := strconv.Unquote(literal)
+		if err != nil {
+			t.Fatalf("bad GBNF literal %s: %v", literal, err)
+		}
+		if !strings.HasPrefix(text, `"`) {
+			// punctuation, i.e. "{" or ":"
+			continue
+		}
+		key := ""
+		if err := json.Unmarshal([]byte(text), &key); err != nil {
+			t.Fatalf("literal %s doesn't match a JSON string: %v", literal, err)
+		}
+		decoded = append(decoded, key)
+	}
+
+	if len(decoded) != len(keys) {
+		t.Fatalf("expected %d keys in the root rule, got %v", len(keys), decoded)
+	}
+	for idx, key := range keys {
+		if decoded[idx] != key {
+			t.Fatalf("key %q is rendered as %q", key, decoded[idx])
+		}
+	}
+}
@@ -10,6 +10,7 @@ type responseFormatJsonContext struct {
 	parsingStarted   bool
 	processingFailed bool
 	finalJson        []string
+	finalStructure   [][]MapKV
 }
 
 func buildResponseFormatJson(node *yaml.Node, ctx *responseFormatJsonContext) {
@@ -34,6 +35,7 @@ func buildResponseFormatJson(node *yaml.Node, ctx *responseFormatJsonContext) {
 							jsonString := renderJsonString(responseStructure, &strings.Builder{}, 0)
 							//fmt.Printf("responseStructure: %v\n", jsonString)
 							ctx.finalJson = append(ctx.finalJson, jsonString)
+							ctx.finalStructure = append(ctx.finalStructure, responseStructure)
 							return
 						}
 						return
@@ -80,11 +80,12 @@ func (agentState *GeneralAgentInfo) totPipelineStep() (int, error) {
 			Priority:    borrow_engine.PRIO_User,
 			GetCompletionRequests: []cmds.GetCompletionRequest{
 				{
-					RawPrompt: chatToRawPrompt([]*engines.Message{
+					Messages: chatToMessages([]*engines.Message{
 						systemMessage,
 					}),
 					MinResults:  9,
 					Temperature: 0.9,
+					Grammar:     agentState.Settings.GetResponseGrammar(),
 				},
 			},
 			CorrelationId: *systemMessage.ID,
@@ -26,35 +26,69 @@ func (agentState *GeneralAgentInfo) visitTerminalMessage(messages []*engines.Mes
 	}
 
 	// in any other case - start voting...!
-	if messages[len(messages)-1].Role == engines.ChatRoleAssistant {
-		/*
-			votes, err := agentState.VoteForAction(messages[0].Content, messages[len(messages)-1].Content)
-			if err != nil {
-				return
-			}
-
-			agentState.terminalsVotesMap[chainSignature] = votes
-			agentState.terminalsVisitsMap[chainSignature] = timesVisited + 1
-		*/
+	if messages[len(messages)-1].Role == engines.ChatRoleAssistant && !exists {
+		// sampling votes is too expensive here, only a single scoring completion is affordable
+		if !agentState.useLogprobsForVoting() {
+			return false
+		}
+		if _, scoring := agentState.terminalsScoring[chainSignature]; scoring {
+			return false
+		}
+
+		// scoring takes a completion, so it runs without holding the lock,
+		// job is submitted once the terminal is rated high enough
+		agentState.terminalsScoring[chainSignature] = struct{}{}
+		go agentState.scoreTerminalMessage(chainSignature, messages)
+
 		return false
-	} else {
+	}
+
+	if messages[len(messages)-1].Role != engines.ChatRoleAssistant {
 		agentState.terminalsVotesMap[chainSignature] = 10 // it's real-world input, don't ignore just yet...!
-		agentState.terminalsVisitsMap[chainSignature] = timesVisited + 1
 	}
+	agentState.terminalsVisitsMap[chainSignature] = timesVisited + 1
 
 	// if we've got here, we can go on...!
+	agentState.submitTerminalMessage(messages)
+
+	return true
+}
+
+func (agentState *GeneralAgentInfo) scoreTerminalMessage(chainSignature string, messages []*engines.Message) {
+	rating, err := agentState.ScoreWithLogprobs("terminal-ranker",
+		votingQuestion(messages[0].Content, messages[len(messages)-1].Content))
+
+	agentState.terminalsLock.Lock()
+	delete(agentState.terminalsScoring, chainSignature)
+	// failed scoring counts as a visit, so the terminal isn't scored over and over
+	agentState.terminalsVisitsMap[chainSignature]++
+	if err == nil {
+		agentState.terminalsVotesMap[chainSignature] = rating
+	}
+	agentState.terminalsLock.Unlock()
+
+	if err == nil && rating >= MinimalVotingRatingForCommand {
+		agentState.submitTerminalMessage(messages)
+	}
+}
+
+func (agentState *GeneralAgentInfo) submitTerminalMessage(messages []*engines.Message) {
 	agentState.jobsChannel <- &cmds.ClientRequest{
 		ProcessName: agentState.SystemName,
 		Priority:    borrow_engine.PRIO_User,
 		GetCompletionRequests: []cmds.GetCompletionRequest{
 			{
-				RawPrompt:   chatToRawPrompt(messages),
+				Messages:    chatToMessages(messages),
 				MinResults:  9,
 				Temperature: 0.9,
+				Grammar:     agentState.Settings.GetResponseGrammar(),
 			},
 		},
 		CorrelationId: *messages[len(messages)-1].ID,
 	}
+}
 
-	return true
+func (agentState *GeneralAgentInfo) useLogprobsForVoting() bool {
+	return agentState.Settings != nil && agentState.Settings.Agent != nil &&
+		agentState.Settings.Agent.LogprobsVoting
 }
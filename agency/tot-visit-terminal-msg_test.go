@@ -0,0 +1,121 @@
+package agency
+
+import (
+	"encoding/json"
+	"github.com/d0rc/agent-os/cmds"
+	"github.com/d0rc/agent-os/engines"
+	os_client "github.com/d0rc/agent-os/os-client"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTerminalsTestAgent(url string) *GeneralAgentInfo {
+	return &GeneralAgentInfo{
+		SystemName:         "terminals-test",
+		Settings:           &AgentSettings{Agent: &GeneralAgentSettings{LogprobsVoting: true}},
+		Server:             os_client.NewAgentOSClient(url),
+		jobsChannel:        make(chan *cmds.ClientRequest, 1),
+		terminalsVisitsMap: make(map[string]int),
+		terminalsVotesMap:  make(map[string]float32),
+		terminalsScoring:   make(map[string]struct{}),
+	}
+}
+
+func terminalsTestChat() []*engines.Message {
+	goal, answer := "goal", "answer"
+	return []*engines.Message{
+		{ID: &goal, Role: engines.ChatRoleSystem, Content: goal},
+		{ID: &answer, Role: engines.ChatRoleAssistant, Content: answer},
+	}
+}
+
+func waitForScoring(t *testing.T, agentState *GeneralAgentInfo) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		agentState.terminalsLock.RLock()
+		scoring := len(agentState.terminalsScoring)
+		agentState.terminalsLock.RUnlock()
+		if scoring == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("terminal scoring didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTerminalIsScoredWithoutHoldingTheLock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		// no logprobs, so scoring fails
+		_ = json.NewEncoder(w).Encode(&cmds.ServerResponse{
+			GetCompletionResponse: []*cmds.GetCompletionResponse{{Choices: []string{"7"}}},
+		})
+	}))
+	defer server.Close()
+
+	agentState := newTerminalsTestAgent(server.URL)
+	chat := terminalsTestChat()
+	signature := getChatSignature(chat)
+
+	if agentState.visitTerminalMessage(chat) {
+		t.Fatalf("job shouldn't be submitted before terminal is scored")
+	}
+	// scoring is still running, the next visit neither blocks nor scores the terminal again
+	if agentState.visitTerminalMessage(chat) {
+		t.Fatalf("job shouldn't be submitted while terminal is scored")
+	}
+	close(release)
+	waitForScoring(t, agentState)
+
+	agentState.terminalsLock.RLock()
+	defer agentState.terminalsLock.RUnlock()
+	if agentState.terminalsVisitsMap[signature] != 1 {
+		t.Fatalf("failed scoring should count as a visit, got %d visits", agentState.terminalsVisitsMap[signature])
+	}
+	if _, rated := agentState.terminalsVotesMap[signature]; rated {
+		t.Fatalf("failed scoring shouldn't rate the terminal")
+	}
+}
+
+func TestTerminalIsSubmittedOnceRated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&cmds.ServerResponse{
+			GetCompletionResponse: []*cmds.GetCompletionResponse{{
+				Choices: []string{"9"},
+				ChoicesLogprobs: [][]engines.TokenLogprob{{
+					{Token: "9", Logprob: 0, TopLogprobs: map[string]float64{"9": 0, "1": math.Log(0.01)}},
+				}},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	agentState := newTerminalsTestAgent(server.URL)
+	chat := terminalsTestChat()
+
+	agentState.visitTerminalMessage(chat)
+	select {
+	case req := <-agentState.jobsChannel:
+		if req.CorrelationId != *chat[len(chat)-1].ID {
+			t.Fatalf("job should continue the terminal message, got %s", req.CorrelationId)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("job wasn't submitted for the highly rated terminal")
+	}
+	waitForScoring(t, agentState)
+
+	// terminal is rated already, so it's visited without scoring it again
+	if !agentState.visitTerminalMessage(chat) {
+		t.Fatalf("rated terminal should be visited")
+	}
+	<-agentState.jobsChannel
+	if visits := agentState.terminalsVisitsMap[getChatSignature(chat)]; visits != 2 {
+		t.Fatalf("expected 2 visits, got %d", visits)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/d0rc/agent-os/utils"
 	"io"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -22,14 +24,47 @@ var port = flag.Int("port", 9000, "port to listen on")
 var host = flag.String("host", "0.0.0.0", "host to listen at")
 var topInterval = flag.Int("top-interval", 1000, "interval to update `top` (ms)")
 var termUi = flag.Bool("term-ui", true, "enable term ui")
+var jobsTrace = flag.String("jobs-trace", "", "file to record received compute jobs to, for scheduler simulations")
+var bootstrap = flag.String("bootstrap", "", "ssh targets (user@host[:port], comma separated) to install vLLM on and add as compute nodes")
+var bootstrapModel = flag.String("bootstrap-model", "", "model bootstrapped nodes serve")
+var bootstrapKey = flag.String("bootstrap-key", "~/.ssh/id_rsa", "private key to ssh to bootstrapped nodes")
+var bootstrapKnownHosts = flag.String("bootstrap-known-hosts", "~/.ssh/known_hosts", "known_hosts file to check host keys of bootstrapped nodes with, empty - don't check")
+var bootstrapPort = flag.Int("bootstrap-port", 8000, "port of the inference server on bootstrapped nodes")
+var bootstrapBatchSize = flag.Int("bootstrap-batch-size", 16, "max batch size of bootstrapped nodes")
 
 func main() {
 	lg, logChan := utils.ConsoleInit("ai-srv", termUi)
 
+	var traceWriter io.Writer
+	if *jobsTrace != "" {
+		traceFile, err := os.OpenFile(*jobsTrace, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			lg.Fatal().Err(err).Msg("failed to open jobs trace file")
+		}
+		defer traceFile.Close()
+		traceWriter = traceFile
+	}
+
+	var bootstrapNodes []*server.BootstrapSettings
+	if *bootstrap != "" {
+		for _, target := range strings.Split(*bootstrap, ",") {
+			bootstrapNodes = append(bootstrapNodes, &server.BootstrapSettings{
+				Target:       strings.TrimSpace(target),
+				KeyFile:      *bootstrapKey,
+				KnownHosts:   *bootstrapKnownHosts,
+				Model:        *bootstrapModel,
+				Port:         *bootstrapPort,
+				MaxBatchSize: *bootstrapBatchSize,
+			})
+		}
+	}
+
 	ctx, err := server.NewContext("config.yaml", lg, &server.Settings{
 		TopInterval: time.Duration(*topInterval) * time.Millisecond,
 		TermUI:      *termUi,
 		LogChan:     logChan,
+		TraceWriter: traceWriter,
+		Bootstrap:   bootstrapNodes,
 	})
 
 	go ctx.Start(func(ctx *server.Context) {
@@ -79,6 +114,7 @@ func main() {
 func processRequest(request *cmds.ClientRequest, ctx *server.Context) (*cmds.ServerResponse, error) {
 	var result *cmds.ServerResponse = &cmds.ServerResponse{}
 	var err error
+	request.ApplyLabels()
 	if request.GetPageRequests != nil && len(request.GetPageRequests) > 0 {
 		// got some page requests...!
 		result, err = cmds.ProcessPageRequests(request.GetPageRequests, ctx)
@@ -104,6 +140,14 @@ func processRequest(request *cmds.ClientRequest, ctx *server.Context) (*cmds.Ser
 		result, err = cmds.ProcessSetCacheRecords(request.SetCacheRecords, ctx, request.ProcessName)
 	}
 
+	if request.TokenizeRequests != nil && len(request.TokenizeRequests) > 0 {
+		result, err = cmds.ProcessTokenize(request.TokenizeRequests, ctx)
+	}
+
+	if request.GetComputeCapacity != nil {
+		result, err = cmds.ProcessGetComputeCapacity(request.GetComputeCapacity, ctx)
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,95 @@
+package borrow_engine
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrOverloaded = errors.New("compute is overloaded")
+
+// OverloadedError is sent to jobs, which were not admitted, because they, or jobs of a higher priority, would miss the target wait
+type OverloadedError struct {
+	Priority      JobPriority // priority, which misses its target
+	ProjectedWait time.Duration
+	TargetWait    time.Duration
+	RetryAfter    time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("%v: projected wait of %s jobs is %v, target is %v, retry after %v",
+		ErrOverloaded, priorityNames[e.Priority], e.ProjectedWait, e.TargetWait, e.RetryAfter)
+}
+
+func (e *OverloadedError) Unwrap() error {
+	return ErrOverloaded
+}
+
+// admitJob checks job's budget and target waits, rejected job gets the error, false is returned then
+func (ie *InferenceEngine) admitJob(job *ComputeJob) bool {
+	ie.jobsBufferLock.RLock()
+	defer ie.jobsBufferLock.RUnlock()
+
+	if !ie.applyBudget(job) {
+		return false
+	}
+
+	if err := ie.checkTargetWaits(job.Priority); err != nil {
+		rejectJob(job, err)
+		return false
+	}
+
+	return true
+}
+
+// checkTargetWaits returns error, if a job of the priority would miss its target wait, or
+// if a higher priority already misses its target, then lower priorities are shed
+func (ie *InferenceEngine) checkTargetWaits(jobPriority JobPriority) error {
+	throughput := ie.throughput()
+	if throughput == 0 {
+		// nothing is measured yet
+		return nil
+	}
+
+	queued := 0
+	for priority := PRIO_System; priority <= jobPriority; priority++ {
+		queued += len(ie.jobsBuffer[priority])
+		target, exists := ie.settings.Scheduling.TargetWaits[priorityNames[priority]]
+		if !exists {
+			continue
+		}
+
+		projected := queued
+		if priority == jobPriority {
+			// the job itself
+			projected++
+		}
+		projectedWait := time.Duration(float64(projected) / throughput * float64(time.Second))
+		targetWait := time.Duration(target) * time.Millisecond
+		if projectedWait > targetWait {
+			return &OverloadedError{
+				Priority:      priority,
+				ProjectedWait: projectedWait,
+				TargetWait:    targetWait,
+				RetryAfter:    projectedWait - targetWait,
+			}
+		}
+	}
+
+	return nil
+}
+
+// throughput is the number of jobs per second nodes run, as measured, 0 if there are no measurements
+func (ie *InferenceEngine) throughput() float64 {
+	now := ie.clock.Now()
+	jobsPerSecond := 0.0
+	for _, node := range ie.Nodes {
+		performance := node.Performance[""]
+		if performance == nil || performance.Latency == 0 || node.Retired || node.circuitOpen(now) {
+			continue
+		}
+		jobsPerSecond += float64(max(node.MaxRequests, 1)) * performance.JobsPerBatch / performance.Latency.Seconds()
+	}
+
+	return jobsPerSecond
+}
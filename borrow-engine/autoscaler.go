@@ -0,0 +1,276 @@
+package borrow_engine
+
+import (
+	"github.com/d0rc/agent-os/settings"
+	zlog "github.com/rs/zerolog/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const autoscalingInterval = 5 * time.Second
+
+const defaultQueueDepth = 64
+const defaultMaxWait = 10 * time.Second
+const defaultIdleTimeout = 5 * time.Minute
+
+type rentedNode struct {
+	rental   *Rental
+	node     *InferenceNode // set once autodetection passes
+	rentedAt time.Time
+}
+
+// Autoscaler rents nodes with the provisioner, while jobs queue up or wait too long,
+// and releases nodes, which are idle for a while
+type Autoscaler struct {
+	ie          *InferenceEngine
+	provisioner Provisioner
+	config      *settings.AutoscalingConfigurationSection
+	lock        sync.Mutex
+	rentals     []*rentedNode
+	spent       float64        // rent of the released nodes
+	starting    sync.WaitGroup // nodes, which are booting or being autodetected
+}
+
+func NewAutoscaler(ie *InferenceEngine, provisioner Provisioner, config *settings.AutoscalingConfigurationSection) *Autoscaler {
+	return &Autoscaler{
+		ie:          ie,
+		provisioner: provisioner,
+		config:      config,
+	}
+}
+
+func (a *Autoscaler) Run() {
+	for {
+		<-a.ie.clock.After(autoscalingInterval)
+		a.step()
+	}
+}
+
+// Spent is the rent of all the nodes autoscaler has rented so far
+func (a *Autoscaler) Spent() float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.spentAt(a.ie.clock.Now())
+}
+
+// step releases idle nodes and rents a new one, if jobs are piling up, nodes are rented
+// one at a time, the next one is rented once the previous has passed autodetection
+func (a *Autoscaler) step() {
+	now := a.ie.clock.Now()
+	queued, longestWait := a.ie.pressure(now)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.release(now, queued)
+	for _, rented := range a.rentals {
+		if rented.node == nil {
+			return
+		}
+	}
+
+	nodes := len(a.rentals)
+	switch {
+	case nodes < a.config.MinNodes:
+		a.rent(now)
+	case nodes >= max(a.config.MaxNodes, a.config.MinNodes):
+	case queued > a.queueDepth() || longestWait > a.maxWait():
+		if a.config.Budget > 0 && a.spentAt(now) >= a.config.Budget {
+			zlog.Warn().Float64("spent", a.spentAt(now)).Msg("autoscaling budget is spent, not renting more nodes")
+			return
+		}
+		a.rent(now)
+	}
+}
+
+// nodeState is a copy of the node's state, which is updated by the scheduler
+type nodeState struct {
+	added     bool
+	retired   bool
+	running   int
+	idleSince time.Time
+}
+
+func (a *Autoscaler) nodeStates() map[*rentedNode]nodeState {
+	a.ie.jobsBufferLock.RLock()
+	defer a.ie.jobsBufferLock.RUnlock()
+
+	states := make(map[*rentedNode]nodeState, len(a.rentals))
+	for _, rented := range a.rentals {
+		if rented.node == nil {
+			continue
+		}
+		states[rented] = nodeState{
+			// node is added by the scheduler after autodetection, it sets the idle time then
+			added:     !rented.node.LastIdleAt.IsZero(),
+			retired:   rented.node.Retired,
+			running:   rented.node.RequestsRunning,
+			idleSince: rented.node.LastIdleAt,
+		}
+	}
+
+	return states
+}
+
+// release destroys retired nodes, which finished their batches, and retires nodes idle for too long
+func (a *Autoscaler) release(now time.Time, queued int) {
+	states := a.nodeStates()
+	active := 0
+	for _, rented := range a.rentals {
+		if !states[rented].retired {
+			active++
+		}
+	}
+
+	for _, rented := range append([]*rentedNode{}, a.rentals...) {
+		state, exists := states[rented]
+		if !exists || !state.added {
+			continue
+		}
+		if state.retired {
+			if state.running == 0 {
+				a.destroy(rented, now)
+			}
+			continue
+		}
+		if queued == 0 && active > a.config.MinNodes && state.running == 0 &&
+			now.Sub(state.idleSince) > a.idleTimeout() {
+			zlog.Info().Str("rental", rented.rental.Id).Msg("releasing idle node")
+			a.ie.RetireNodeChan <- rented.node
+			active--
+		}
+	}
+}
+
+func (a *Autoscaler) rent(now time.Time) {
+	offers, err := a.provisioner.ListOffers()
+	if err != nil {
+		zlog.Error().Err(err).Msg("error listing offers")
+		return
+	}
+	affordable := make([]*Offer, 0, len(offers))
+	for _, offer := range offers {
+		if a.config.MaxPricePerHour == 0 || offer.PerHour <= a.config.MaxPricePerHour {
+			affordable = append(affordable, offer)
+		}
+	}
+	if len(affordable) == 0 {
+		zlog.Warn().Int("offers", len(offers)).Msg("no affordable offers to rent")
+		return
+	}
+	sort.SliceStable(affordable, func(i, j int) bool {
+		return affordable[i].PerHour < affordable[j].PerHour
+	})
+
+	rental, err := a.provisioner.Rent(affordable[0])
+	if err != nil {
+		zlog.Error().Err(err).Str("offer", affordable[0].Id).Msg("error renting node")
+		return
+	}
+	zlog.Info().Str("rental", rental.Id).Float64("per-hour", rental.Offer.PerHour).Msg("rented node")
+	rented := &rentedNode{rental: rental, rentedAt: now}
+	a.rentals = append(a.rentals, rented)
+
+	a.starting.Add(1)
+	go a.start(rented)
+}
+
+// start boots the engine and adds the node, node which fails autodetection is released
+func (a *Autoscaler) start(rented *rentedNode) {
+	defer a.starting.Done()
+
+	node, err := a.provisioner.Start(rented.rental)
+	if err == nil {
+		if node.Cost == nil && rented.rental.Offer.PerHour > 0 {
+			node.Cost = &settings.CostConfigurationSection{PerHour: rented.rental.Offer.PerHour}
+		}
+		node = <-a.ie.AddNode(node)
+		if node.RemoteEngine.CompletionFailed && node.RemoteEngine.EmbeddingsFailed {
+			err = ErrAutodetectionFailed
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err != nil {
+		zlog.Error().Err(err).Str("rental", rented.rental.Id).Msg("error starting rented node")
+		a.destroy(rented, a.ie.clock.Now())
+		return
+	}
+	rented.node = node
+}
+
+func (a *Autoscaler) destroy(rented *rentedNode, now time.Time) {
+	if err := a.provisioner.Destroy(rented.rental); err != nil {
+		zlog.Error().Err(err).Str("rental", rented.rental.Id).Msg("error destroying node")
+		return
+	}
+	a.spent += rented.rent(now)
+	for idx, existing := range a.rentals {
+		if existing == rented {
+			a.rentals = append(a.rentals[:idx], a.rentals[idx+1:]...)
+			break
+		}
+	}
+}
+
+func (a *Autoscaler) spentAt(now time.Time) float64 {
+	spent := a.spent
+	for _, rented := range a.rentals {
+		spent += rented.rent(now)
+	}
+
+	return spent
+}
+
+func (rented *rentedNode) rent(now time.Time) float64 {
+	return rented.rental.Offer.PerHour * now.Sub(rented.rentedAt).Hours()
+}
+
+func (a *Autoscaler) queueDepth() int {
+	if a.config.QueueDepth > 0 {
+		return a.config.QueueDepth
+	}
+
+	return defaultQueueDepth
+}
+
+func (a *Autoscaler) maxWait() time.Duration {
+	if a.config.MaxWait > 0 {
+		return time.Duration(a.config.MaxWait) * time.Millisecond
+	}
+
+	return defaultMaxWait
+}
+
+func (a *Autoscaler) idleTimeout() time.Duration {
+	if a.config.IdleTimeout > 0 {
+		return time.Duration(a.config.IdleTimeout) * time.Second
+	}
+
+	return defaultIdleTimeout
+}
+
+// pressure is the number of buffered jobs and the longest of their waits,
+// jobs of processes, which spent their budgets, aren't counted
+func (ie *InferenceEngine) pressure(now time.Time) (int, time.Duration) {
+	ie.jobsBufferLock.RLock()
+	defer ie.jobsBufferLock.RUnlock()
+
+	queued := 0
+	longestWait := time.Duration(0)
+	for _, jobs := range ie.jobsBuffer {
+		for _, job := range jobs {
+			if ie.exceededBudget(job) != nil {
+				continue
+			}
+			queued++
+			longestWait = max(longestWait, now.Sub(job.receivedAt))
+		}
+	}
+
+	return queued, longestWait
+}
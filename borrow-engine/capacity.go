@@ -0,0 +1,64 @@
+package borrow_engine
+
+import (
+	"github.com/d0rc/agent-os/engines"
+	"sync/atomic"
+)
+
+type SpareCapacity struct {
+	FreeSlots      int
+	TotalSlots     int
+	QueuedJobs     int
+	Models         []string
+	EmbeddingsDims uint64
+}
+
+// GetSpareCapacity reports capacity of local compute nodes only,
+// federation peers are skipped, so peers never advertise each other's
+// capacity and jobs can't travel in circles
+func (ie *InferenceEngine) GetSpareCapacity() *SpareCapacity {
+	capacity := &SpareCapacity{
+		Models:     make([]string, 0),
+		QueuedJobs: int(atomic.LoadInt64(&ie.jobsBuffered)) + len(ie.IncomingJobs),
+	}
+
+	// it's called from the request handlers, while scheduler updates the nodes
+	ie.jobsBufferLock.RLock()
+	defer ie.jobsBufferLock.RUnlock()
+
+	seenModels := make(map[string]struct{})
+	for _, node := range ie.Nodes {
+		if node.IsFederationPeer() || node.Retired {
+			continue
+		}
+
+		capacity.TotalSlots += node.MaxRequests
+		if node.RequestsRunning < node.MaxRequests {
+			capacity.FreeSlots += node.MaxRequests - node.RequestsRunning
+		}
+
+		if node.RemoteEngine == nil {
+			continue
+		}
+		for _, model := range node.RemoteEngine.GetModels() {
+			if _, exists := seenModels[model]; !exists {
+				seenModels[model] = struct{}{}
+				capacity.Models = append(capacity.Models, model)
+			}
+		}
+		if capacity.EmbeddingsDims == 0 {
+			capacity.EmbeddingsDims = node.RemoteEngine.GetEmbeddingsDims()
+		}
+	}
+
+	// jobs waiting in the queue are going to take free slots first
+	if capacity.QueuedJobs > 0 {
+		capacity.FreeSlots = 0
+	}
+
+	return capacity
+}
+
+func (n *InferenceNode) IsFederationPeer() bool {
+	return n.Protocol == engines.ProtocolAgencyOS
+}
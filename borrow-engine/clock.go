@@ -0,0 +1,95 @@
+package borrow_engine
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the scheduler, so it can run on a virtual one in simulations
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// VirtualClock only moves when it's advanced, timers created
+// with After fire once the clock reaches their deadline
+type VirtualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []virtualTimer
+}
+
+type virtualTimer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *VirtualClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, virtualTimer{deadline: c.now.Add(d), c: ch})
+
+	return ch
+}
+
+// Set moves the clock to t, if it's in the future, firing due timers
+func (c *VirtualClock) Set(t time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if t.Before(c.now) {
+		return
+	}
+	c.now = t
+
+	waiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(t) {
+			waiters = append(waiters, waiter)
+			continue
+		}
+		waiter.c <- t
+	}
+	c.waiters = waiters
+}
+
+// Advance moves the clock forward by d
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
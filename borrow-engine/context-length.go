@@ -0,0 +1,47 @@
+package borrow_engine
+
+import zlog "github.com/rs/zerolog/log"
+
+// GetContextLength returns the largest context window of local nodes serving the model,
+// 0 is returned if any of those nodes doesn't know its context length, federation peers
+// are skipped, they check requests against their own nodes
+func (ie *InferenceEngine) GetContextLength(model string) int {
+	contextLength := 0
+	for _, node := range ie.Nodes {
+		if node.IsFederationPeer() || node.Retired || node.RemoteEngine == nil || node.RemoteEngine.CompletionFailed ||
+			!node.RemoteEngine.ServesModel(model) {
+			continue
+		}
+		nodeContextLength := node.RemoteEngine.GetContextLength()
+		if nodeContextLength == 0 {
+			return 0
+		}
+		contextLength = max(contextLength, nodeContextLength)
+	}
+
+	return contextLength
+}
+
+// rejectOversizedJobs handles batch, which node refused to run because of the prompt
+// length, lone jobs get the error, jobs of a bigger batch are retried one by one,
+// to find out which of them is too long
+func (ie *InferenceEngine) rejectOversizedJobs(jobs []*ComputeJob, err error) {
+	if len(jobs) == 1 {
+		rejectJob(jobs[0], err)
+		return
+	}
+
+	for _, job := range jobs {
+		job.runAlone = true
+	}
+	ie.requeue(jobs)
+}
+
+// rejectJob sends the error to job's owner, job is dropped if it has no error channel
+func rejectJob(job *ComputeJob, err error) {
+	if job.ComputeResult != nil && job.ComputeResult.ErrorChannel != nil {
+		job.ComputeResult.ErrorChannel <- err
+	} else {
+		zlog.Error().Err(err).Str("job", job.JobId).Msg("dropping rejected job")
+	}
+}
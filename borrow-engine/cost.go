@@ -0,0 +1,102 @@
+package borrow_engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/settings"
+	"strings"
+	"time"
+)
+
+var ErrBudgetExceeded = errors.New("compute budget exceeded")
+
+const (
+	BudgetActionStop       = "stop"
+	BudgetActionBackground = "background"
+)
+
+// ProcessTenant is the part of the process name before the first slash, empty if there is none
+func ProcessTenant(process string) string {
+	if idx := strings.Index(process, "/"); idx != -1 {
+		return process[:idx]
+	}
+
+	return ""
+}
+
+// timeCost is the rent of the node for the duration
+func (n *InferenceNode) timeCost(duration time.Duration) float64 {
+	if n.Cost == nil {
+		return 0
+	}
+
+	return n.Cost.PerHour * duration.Hours()
+}
+
+// tokensCost is the price of the tokens job used on the node
+func (n *InferenceNode) tokensCost(job *ComputeJob) float64 {
+	if n.Cost == nil || job.Usage == nil {
+		return 0
+	}
+
+	return n.Cost.PerThousandPromptTokens*float64(job.Usage.PromptTokens)/1000 +
+		n.Cost.PerThousandCompletionTokens*float64(job.Usage.TokensGenerated)/1000
+}
+
+// accountCost charges the job with its share of the batch time and its tokens,
+// it's called with jobs buffer lock held, as budgets are checked under it
+func (ie *InferenceEngine) accountCost(node *InferenceNode, job *ComputeJob, duration time.Duration, batchSize int) {
+	cost := node.timeCost(duration)/float64(batchSize) + node.tokensCost(job)
+	if cost == 0 {
+		return
+	}
+
+	node.TotalCost += cost
+	ie.TotalCost += cost
+	ie.ProcessesTotalCost[job.Process] += cost
+	ie.TenantsTotalCost[ProcessTenant(job.Process)] += cost
+}
+
+// exceededBudget returns the first budget of job's process, or its tenant, which is spent
+func (ie *InferenceEngine) exceededBudget(job *ComputeJob) *settings.BudgetConfigurationSection {
+	tenant := ProcessTenant(job.Process)
+	for idx := range ie.settings.Budgets {
+		budget := &ie.settings.Budgets[idx]
+		switch {
+		case budget.Process != "" && budget.Process == job.Process:
+			if ie.ProcessesTotalCost[job.Process] >= budget.Limit {
+				return budget
+			}
+		case budget.Process == "" && budget.Tenant != "" && budget.Tenant == tenant:
+			if ie.TenantsTotalCost[tenant] >= budget.Limit {
+				return budget
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyBudget rejects the job, or lowers its priority, if its budget is spent, false is returned if job is rejected,
+// it's called with jobs buffer lock held
+func (ie *InferenceEngine) applyBudget(job *ComputeJob) bool {
+	budget := ie.exceededBudget(job)
+	if budget == nil {
+		return true
+	}
+
+	if budget.Action == BudgetActionBackground {
+		job.Priority = PRIO_Background
+		return true
+	}
+
+	spent := ie.ProcessesTotalCost[job.Process]
+	owner := "process " + job.Process
+	if budget.Process == "" {
+		spent = ie.TenantsTotalCost[budget.Tenant]
+		owner = "tenant " + budget.Tenant
+	}
+	rejectJob(job, fmt.Errorf("%w: %s spent %.2f of %.2f", ErrBudgetExceeded, owner, spent, budget.Limit))
+
+	return false
+}
@@ -1,28 +1,33 @@
 package borrow_engine
 
 import (
+	"errors"
+	"github.com/d0rc/agent-os/engines"
 	"github.com/rs/zerolog/log"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func (ie *InferenceEngine) Run() {
-	jobsBuffer := map[JobPriority][]*ComputeJob{
-		PRIO_System:     []*ComputeJob{},
-		PRIO_Kernel:     []*ComputeJob{},
-		PRIO_User:       []*ComputeJob{},
-		PRIO_Background: []*ComputeJob{},
-	}
-	jobsBufferLock := sync.RWMutex{}
+const schedulingInterval = 100 * time.Millisecond
+
+// nodeFailureBackoff is the time failed node gets no jobs
+const nodeFailureBackoff = 5 * time.Second
 
+// batchFillTimeout is how long node has to be idle, before it gets a batch, which is not full
+const batchFillTimeout = 50 * time.Millisecond
+
+// maxBufferedJobs is the number of buffered jobs, after which no new jobs are accepted until some are sent
+const maxBufferedJobs = 1024
+
+func (ie *InferenceEngine) Run() {
 	go func() {
 		if ie.settings.TermUI {
-			ie.ui(jobsBuffer, &jobsBufferLock)
+			ie.ui(ie.jobsBuffer, &ie.jobsBufferLock)
 			os.Exit(0)
-		} else {
+		} else if ie.settings.TopInterval > 0 {
 			for {
-				ie.PrintTop(jobsBuffer, &jobsBufferLock)
+				ie.PrintTop(ie.jobsBuffer, &ie.jobsBufferLock)
 				time.Sleep(ie.settings.TopInterval)
 			}
 		}
@@ -31,38 +36,41 @@ func (ie *InferenceEngine) Run() {
 	for {
 		attemptProcessing := false
 
-		timer := time.NewTimer(100 * time.Millisecond)
-		if countMapValueLens(jobsBuffer, &jobsBufferLock) > 1024 {
+		timer := ie.clock.After(schedulingInterval)
+		if countMapValueLens(ie.jobsBuffer, &ie.jobsBufferLock) > maxBufferedJobs {
 			select {
-			case <-timer.C:
+			case <-timer:
 				attemptProcessing = true
 			case node := <-ie.AddNodeChan:
-				node.LastIdleAt = time.Now()
-				ie.Nodes = append(ie.Nodes, node)
+				ie.addNode(node)
 				// since new node is available, let's trigger the processing
 				attemptProcessing = true
+			case node := <-ie.RetireNodeChan:
+				ie.retireNode(node)
 			case _ = <-ie.InferenceDone:
 				attemptProcessing = true
+			case result := <-ie.batchResults:
+				ie.batchFinished(result)
+				attemptProcessing = true
 			}
 		} else {
 			select {
-			case <-timer.C:
+			case <-timer:
 				attemptProcessing = true
 			case node := <-ie.AddNodeChan:
-				node.LastIdleAt = time.Now()
-				ie.Nodes = append(ie.Nodes, node)
+				ie.addNode(node)
 				// since new node is available, let's trigger the processing
 				attemptProcessing = true
+			case node := <-ie.RetireNodeChan:
+				ie.retireNode(node)
 			case _ = <-ie.InferenceDone:
 				attemptProcessing = true
+			case result := <-ie.batchResults:
+				ie.batchFinished(result)
+				attemptProcessing = true
 			case jobs := <-ie.IncomingJobs:
 				// fmt.Printf("Recieved %d jobs\n", len(jobs))
-				for _, job := range jobs {
-					jobsBufferLock.Lock()
-					ie.ProcessesTotalJobs[job.Process]++
-					jobsBuffer[job.Priority] = append(jobsBuffer[job.Priority], job)
-					jobsBufferLock.Unlock()
-				}
+				ie.bufferJobs(jobs)
 				attemptProcessing = true
 			}
 		}
@@ -72,193 +80,226 @@ func (ie *InferenceEngine) Run() {
 			continue
 		}
 
-		// attempt to process the jobs
-		tsScheduling := time.Now()
-		for nodeIdx, _ := range ie.Nodes {
-			if ie.Nodes[nodeIdx].RequestsRunning >= ie.Nodes[nodeIdx].MaxRequests {
-				continue
-			}
-			if time.Since(ie.Nodes[nodeIdx].LastFailure) < 5*time.Second {
-				continue
-			}
-			// we have an available node...! let's try to
-			// get node.MaxBatchSize jobs from the buffer
-			// but we can only run jobs of the same type
-			batch := map[JobType][]*ComputeJob{}
+		ie.scheduleJobs(ie.runBatch)
+	}
+}
 
-			canSend := false
-			var haveAtLeastOneJobType JobType = JT_NotAJob
-			var canSendJobType JobType = JT_NotAJob
+func (ie *InferenceEngine) addNode(node *InferenceNode) {
+	ie.jobsBufferLock.Lock()
+	defer ie.jobsBufferLock.Unlock()
 
-			for priority := PRIO_System; priority <= PRIO_Background; priority++ {
-				jobsBufferLock.RLock()
-				jobsByType := getJobsByType(jobsBuffer[priority], ie.Nodes[nodeIdx].JobTypes)
-				jobsBufferLock.RUnlock()
-				for jobType, jobs := range jobsByType {
-					if len(jobs) == 0 {
-						continue
-					}
-					// we have some jobs to run
-					// let's try to fill our batch
-					for _, job := range jobs {
-						batch[jobType] = append(batch[jobType], job)
-						haveAtLeastOneJobType = jobType
-						if len(batch[jobType]) == ie.Nodes[nodeIdx].MaxBatchSize {
-							canSend = true
-							canSendJobType = jobType
-							break
-						}
-					}
-				}
+	node.LastIdleAt = ie.clock.Now()
+	ie.Nodes = append(ie.Nodes, node)
+}
 
-				if haveAtLeastOneJobType != JT_NotAJob {
-					break
-				}
-			}
+func (ie *InferenceEngine) retireNode(node *InferenceNode) {
+	ie.jobsBufferLock.Lock()
+	defer ie.jobsBufferLock.Unlock()
 
-			if haveAtLeastOneJobType != JT_NotAJob && time.Since(ie.Nodes[nodeIdx].LastIdleAt) > 50*time.Millisecond {
-				canSend = true
+	if !node.Retired && node.retired != nil {
+		close(node.retired)
+	}
+	node.Retired = true
+}
 
-				if canSendJobType == JT_NotAJob {
-					canSendJobType = haveAtLeastOneJobType
-				}
-			}
+func (ie *InferenceEngine) bufferJobs(jobs []*ComputeJob) {
+	ie.jobsBufferLock.Lock()
+	defer ie.jobsBufferLock.Unlock()
 
-			// fmt.Printf("canSend: %v, canSendJobType: %v, haveAtLeastOneJobType: %v\n",
-			//	canSend, canSendJobType, haveAtLeastOneJobType)
+	for _, job := range jobs {
+		if job.modelSince.IsZero() {
+			// job is buffered for the first time
+			ie.setFallbackChain(job)
+			ie.setLabels(job)
+		}
+		ie.ProcessesTotalJobs[job.Process]++
+		ie.jobsBuffer[job.Priority] = append(ie.jobsBuffer[job.Priority], job)
+	}
+}
 
-			if !canSend {
-				continue
-			}
-			// check if we need to switch job types because other one has lower priorities
-			perBatchMinPrio := make(map[JobType]JobPriority)
-			minPrioJobType := JT_NotAJob
-			for jobType, jobs := range batch {
-				if len(jobs) == 0 {
-					perBatchMinPrio[jobType] = PRIO_Background
-					continue
-				}
-				minPriority := jobs[0].Priority
-				minPrioJobType = jobs[0].JobType
-				for _, job := range jobs {
-					if job.Priority < minPriority {
-						minPriority = job.Priority
-						minPrioJobType = job.JobType
+// scheduleJobs fills batches for the nodes, which have free request slots, and passes them to dispatch
+func (ie *InferenceEngine) scheduleJobs(dispatch func(nodeIdx int, jobs []*ComputeJob)) {
+	jobsBuffer := ie.jobsBuffer
+	jobsBufferLock := &ie.jobsBufferLock
+
+	// attempt to process the jobs
+	tsScheduling := ie.clock.Now()
+	ie.applyFallbacks()
+	for _, nodeIdx := range ie.placementOrder() {
+		if ie.Nodes[nodeIdx].RequestsRunning >= ie.Nodes[nodeIdx].MaxRequests {
+			continue
+		}
+		if ie.clock.Since(ie.Nodes[nodeIdx].LastFailure) < nodeFailureBackoff ||
+			ie.Nodes[nodeIdx].circuitOpen(tsScheduling) {
+			continue
+		}
+		jobs, full := ie.fillBatch(ie.Nodes[nodeIdx])
+		if len(jobs) == 0 {
+			continue
+		}
+		if !full && ie.clock.Since(ie.Nodes[nodeIdx].LastIdleAt) <= batchFillTimeout {
+			// give batch some time to fill up
+			continue
+		}
+
+		// we have a batch to send
+		// let's send it
+		log.Trace().Msgf("Sending batch of %s(%d) jobs to node %s",
+			jobs[0].JobType, len(jobs), ie.Nodes[nodeIdx].EndpointUrl)
+		jobsBufferLock.Lock()
+		if ie.Nodes[nodeIdx].RequestsRunning == 0 {
+			ie.Nodes[nodeIdx].TotalTimeIdle += ie.clock.Since(ie.Nodes[nodeIdx].LastIdleAt)
+			ie.TotalTimeIdle += ie.clock.Since(ie.Nodes[nodeIdx].LastIdleAt)
+		}
+		ie.Nodes[nodeIdx].RequestsRunning++
+
+		// drop jobs from the buffer
+		for _, job := range jobs {
+			for priority := PRIO_System; priority <= PRIO_Background; priority++ {
+				for idx, jobInBuffer := range jobsBuffer[priority] {
+					if jobInBuffer.JobId == job.JobId {
+						jobsBuffer[priority] = append(jobsBuffer[priority][:idx], jobsBuffer[priority][idx+1:]...)
+						ie.ProcessesTotalTimeWaiting[job.Process] += ie.clock.Since(job.receivedAt)
+						break
 					}
 				}
 			}
-			if canSendJobType != minPrioJobType && minPrioJobType != JT_NotAJob {
-				canSendJobType = minPrioJobType
-			}
+		}
+		jobsBufferLock.Unlock()
 
-			// let's check node can run this types of jobs
-			jobTypesSwitchedAlready := false
-		switchJobTypes:
-			if len(batch[canSendJobType]) == 0 {
+		dispatch(nodeIdx, jobs)
+	}
+
+	ie.TotalTimeScheduling += ie.clock.Since(tsScheduling)
+	atomic.StoreInt64(&ie.jobsBuffered, int64(countMapValueLens(jobsBuffer, jobsBufferLock)))
+}
+
+// fillBatch takes jobs of the most urgent priority, which has jobs the node can run,
+// batch is of the type, which fills up first, or of the type of the oldest job,
+// full is set if batch can't take more jobs
+func (ie *InferenceEngine) fillBatch(node *InferenceNode) (jobs []*ComputeJob, full bool) {
+	ie.jobsBufferLock.RLock()
+	defer ie.jobsBufferLock.RUnlock()
+
+	for priority := PRIO_System; priority <= PRIO_Background; priority++ {
+		batches := map[JobType][]*ComputeJob{}
+		firstJobType := JT_NotAJob
+		for _, job := range ie.jobsBuffer[priority] {
+			if !node.runsJobType(job.JobType) || !node.acceptsJob(job) {
 				continue
 			}
-			nodeIsCompatible := false
-			for _, jt := range ie.Nodes[nodeIdx].JobTypes {
-				if jt == canSendJobType {
-					nodeIsCompatible = true
-					break
-				}
+			if firstJobType == JT_NotAJob {
+				firstJobType = job.JobType
 			}
-			if !nodeIsCompatible {
-				if jobTypesSwitchedAlready {
-					continue
-				}
-				// either pick another job type, or just continue
-				// let's see if we have another job type
-				if canSendJobType == JT_Embeddings {
-					canSendJobType = JT_Completion
-					jobTypesSwitchedAlready = true
-					goto switchJobTypes
-				} else if canSendJobType == JT_Completion {
-					canSendJobType = JT_Embeddings
-					jobTypesSwitchedAlready = true
-					goto switchJobTypes
-				}
-
+			batch := batches[job.JobType]
+			limit := node.batchLimit(job.JobType)
+			if (limit > 0 && len(batch) >= limit) || !canBatchWith(batch, job) {
 				continue
 			}
-
-			// we have a batch to send
-			// let's send it
-			// fmt.Printf("Sending batch of %d jobs to node %s\n", len(batch[canSendJobType]), ie.Nodes[nodeIdx].EndpointUrl)
-			log.Trace().Msgf("Sending batch of %s(%d) jobs to node %s",
-				jobTypeName(canSendJobType), len(batch[canSendJobType]), ie.Nodes[nodeIdx].EndpointUrl)
-			if ie.Nodes[nodeIdx].RequestsRunning == 0 {
-				ie.Nodes[nodeIdx].TotalTimeIdle += time.Since(ie.Nodes[nodeIdx].LastIdleAt)
-				ie.TotalTimeIdle += time.Since(ie.Nodes[nodeIdx].LastIdleAt)
+			batches[job.JobType] = append(batch, job)
+			if len(batches[job.JobType]) == limit {
+				return batches[job.JobType], true
 			}
-			ie.Nodes[nodeIdx].RequestsRunning++
-
-			go ie.Nodes[nodeIdx].RunBatch(ie.ComputeFunction, batch[canSendJobType], nodeIdx, func(nodeIdx int, ts time.Time) {
-				ie.Nodes[nodeIdx].TotalTimeConsumed += time.Since(ts)
-				ie.TotalRequestsProcessed++
-				ie.TotalJobsProcessed += uint64(len(batch[canSendJobType]))
-				ie.TotalTimeConsumed += time.Since(ts)
-				jobsBufferLock.Lock()
-				for _, job := range batch[canSendJobType] {
-					ie.ProcessesTotalTimeConsumed[job.Process] += time.Since(ts)
-				}
-				jobsBufferLock.Unlock()
-				ie.Nodes[nodeIdx].RequestsRunning--
-				ie.Nodes[nodeIdx].TotalRequestsProcessed++
-				ie.Nodes[nodeIdx].TotalJobsProcessed += uint64(len(batch[canSendJobType]))
+		}
 
-				if ie.Nodes[nodeIdx].RequestsRunning == 0 {
-					ie.Nodes[nodeIdx].LastIdleAt = time.Now()
-				}
-				ie.InferenceDone <- ie.Nodes[nodeIdx]
-			}, func(nodeIdx int, ts time.Time, err error) {
-				// fmt.Printf("Batch of %d jobs on node %s failed\n", len(batch[canSendJobType]), ie.Nodes[nodeIdx].EndpointUrl)
-				ie.Nodes[nodeIdx].TotalTimeWaisted += time.Since(ts)
-				ie.TotalTimeWaisted += time.Since(ts)
-				ie.TotalRequestsFailed++
-
-				ie.Nodes[nodeIdx].TotalRequestsFailed++
-				ie.Nodes[nodeIdx].TotalJobsFailed += uint64(len(batch[canSendJobType]))
-
-				ie.Nodes[nodeIdx].LastFailure = time.Now()
-				go func() {
-					ie.IncomingJobs <- batch[canSendJobType]
-				}()
-
-				ie.Nodes[nodeIdx].RequestsRunning--
-				if ie.Nodes[nodeIdx].RequestsRunning == 0 {
-					ie.Nodes[nodeIdx].LastIdleAt = time.Now()
-				}
-			})
-
-			// drop jobs from the buffer
-			for _, job := range batch[canSendJobType] {
-				for priority := PRIO_System; priority <= PRIO_Background; priority++ {
-					jobsBufferLock.Lock()
-					for idx, jobInBuffer := range jobsBuffer[priority] {
-						if jobInBuffer.JobId == job.JobId {
-							jobsBuffer[priority] = append(jobsBuffer[priority][:idx], jobsBuffer[priority][idx+1:]...)
-							ie.ProcessesTotalTimeWaiting[job.Process] += time.Since(job.receivedAt)
-							break
-						}
-					}
-					jobsBufferLock.Unlock()
-				}
-			}
+		if firstJobType != JT_NotAJob {
+			return batches[firstJobType], false
 		}
+	}
+
+	return nil, false
+}
 
-		ie.TotalTimeScheduling += time.Since(tsScheduling)
+// batchResult is sent by the batch running in the background, so the scheduler accounts it in its own goroutine
+type batchResult struct {
+	nodeIdx   int
+	jobs      []*ComputeJob
+	startedAt time.Time
+	err       error
+}
+
+// runBatch runs the batch on the node in the background
+func (ie *InferenceEngine) runBatch(nodeIdx int, jobs []*ComputeJob) {
+	go ie.Nodes[nodeIdx].RunBatch(ie.ComputeFunction, jobs, nodeIdx, ie.clock, func(nodeIdx int, ts time.Time) {
+		ie.batchResults <- &batchResult{nodeIdx: nodeIdx, jobs: jobs, startedAt: ts}
+	}, func(nodeIdx int, ts time.Time, err error) {
+		ie.batchResults <- &batchResult{nodeIdx: nodeIdx, jobs: jobs, startedAt: ts, err: err}
+	})
+}
+
+func (ie *InferenceEngine) batchFinished(result *batchResult) {
+	if result.err != nil {
+		ie.batchFailed(result.nodeIdx, result.jobs, result.startedAt, result.err)
+		return
 	}
+
+	ie.batchDone(result.nodeIdx, result.jobs, result.startedAt)
 }
 
-func jobTypeName(jobType JobType) string {
-	switch jobType {
-	case JT_Embeddings:
-		return "embeddings"
-	case JT_Completion:
-		return "completion"
-	default:
-		return "unknown"
+// batchDone and batchFailed are called by the scheduler's goroutine, state read by
+// other goroutines is updated with jobs buffer lock held
+func (ie *InferenceEngine) batchDone(nodeIdx int, jobs []*ComputeJob, ts time.Time) {
+	ie.jobsBufferLock.Lock()
+	defer ie.jobsBufferLock.Unlock()
+
+	ie.Nodes[nodeIdx].TotalTimeConsumed += ie.clock.Since(ts)
+	ie.TotalRequestsProcessed++
+	ie.TotalJobsProcessed += uint64(len(jobs))
+	ie.TotalTimeConsumed += ie.clock.Since(ts)
+	for _, job := range jobs {
+		ie.ProcessesTotalTimeConsumed[job.Process] += ie.clock.Since(ts)
+		ie.accountUsage(ie.Nodes[nodeIdx], job)
+		ie.accountCost(ie.Nodes[nodeIdx], job, ie.clock.Since(ts), len(jobs))
+	}
+	ie.Nodes[nodeIdx].measurePerformance(jobs, ie.clock.Since(ts))
+	ie.Nodes[nodeIdx].RequestsRunning--
+	ie.Nodes[nodeIdx].ConsecutiveFailures = 0
+	ie.Nodes[nodeIdx].TotalRequestsProcessed++
+	ie.Nodes[nodeIdx].TotalJobsProcessed += uint64(len(jobs))
+
+	if ie.Nodes[nodeIdx].RequestsRunning == 0 {
+		ie.Nodes[nodeIdx].LastIdleAt = ie.clock.Now()
+	}
+}
+
+func (ie *InferenceEngine) batchFailed(nodeIdx int, jobs []*ComputeJob, ts time.Time, err error) {
+	nodeFailed := !errors.Is(err, engines.ErrContextLengthExceeded) && !errors.Is(err, ErrNoComputeFunction) &&
+		!errors.Is(err, engines.ErrNoSpareCapacity)
+
+	ie.jobsBufferLock.Lock()
+	ie.Nodes[nodeIdx].TotalTimeWaisted += ie.clock.Since(ts)
+	// rent of the failed batch is paid, but it's no one's spend
+	ie.Nodes[nodeIdx].TotalCost += ie.Nodes[nodeIdx].timeCost(ie.clock.Since(ts))
+	ie.TotalCost += ie.Nodes[nodeIdx].timeCost(ie.clock.Since(ts))
+	ie.TotalTimeWaisted += ie.clock.Since(ts)
+	ie.TotalRequestsFailed++
+
+	ie.Nodes[nodeIdx].TotalRequestsFailed++
+	ie.Nodes[nodeIdx].TotalJobsFailed += uint64(len(jobs))
+	if nodeFailed {
+		ie.Nodes[nodeIdx].LastFailure = ie.clock.Now()
+		ie.Nodes[nodeIdx].ConsecutiveFailures++
+	} else if errors.Is(err, engines.ErrNoSpareCapacity) {
+		// busy peer gets a pause, but its circuit stays closed, it didn't fail to run the batch
+		ie.Nodes[nodeIdx].LastFailure = ie.clock.Now()
+	}
+
+	ie.Nodes[nodeIdx].RequestsRunning--
+	if ie.Nodes[nodeIdx].RequestsRunning == 0 {
+		ie.Nodes[nodeIdx].LastIdleAt = ie.clock.Now()
+	}
+	ie.jobsBufferLock.Unlock()
+
+	// jobs are sent back, or rejected, without the lock, requeue buffers them in simulations
+	if errors.Is(err, engines.ErrContextLengthExceeded) {
+		// node is fine, it's the prompt, which is too long
+		ie.rejectOversizedJobs(jobs, err)
+	} else if errors.Is(err, ErrNoComputeFunction) {
+		// node is fine, no node can run jobs of the type
+		for _, job := range jobs {
+			rejectJob(job, err)
+		}
+	} else {
+		ie.requeue(jobs)
 	}
 }
@@ -2,7 +2,10 @@ package borrow_engine
 
 import (
 	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/settings"
 	zlog "github.com/rs/zerolog/log"
+	"io"
+	"sync"
 	"time"
 )
 
@@ -18,9 +21,17 @@ type InferenceEngine struct {
 	ProcessesTotalRequests     map[string]uint64
 	ProcessesTotalTimeConsumed map[string]time.Duration
 	ProcessesTotalTimeWaiting  map[string]time.Duration
+	TotalPromptTokens          uint64
+	TotalTokensGenerated       uint64
+	ProcessesTotalTokens       map[string]*TokensUsage
+	ModelsTotalTokens          map[string]*TokensUsage
+	TotalCost                  float64
+	ProcessesTotalCost         map[string]float64
+	TenantsTotalCost           map[string]float64
 
 	// control channels
 	AddNodeChan         chan *InferenceNode
+	RetireNodeChan      chan *InferenceNode // node stops getting jobs, batches it runs are finished
 	IncomingJobs        chan []*ComputeJob
 	InferenceDone       chan *InferenceNode
 	TotalTimeScheduling time.Duration
@@ -29,26 +40,70 @@ type InferenceEngine struct {
 	TotalTimeWaisted    time.Duration
 	TotalRequestsFailed uint64
 	settings            *InferenceEngineSettings
+	jobsBuffered        int64
+
+	clock          Clock
+	jobsBuffer     map[JobPriority][]*ComputeJob
+	jobsBufferLock sync.RWMutex             // also guards nodes and statistics, which are read outside of Run
+	requeue        func(jobs []*ComputeJob) // sends jobs, which failed, back to the buffer
+	batchResults   chan *batchResult
+	startedAt      time.Time
+	traceLock      sync.Mutex
 }
 
 type InferenceEngineSettings struct {
 	TopInterval time.Duration
 	TermUI      bool
 	LogChan     chan []byte
+	Clock       Clock     // real clock is used if not set
+	TraceWriter io.Writer // if set, every job received is written to it as a JSON line, see TraceRecord
+	Fallbacks   []settings.FallbackConfigurationSection
+	Budgets     []settings.BudgetConfigurationSection
+	Scheduling  settings.SchedulingConfigurationSection
+	Routing     []settings.RoutingConfigurationSection
 }
 
 func NewInferenceEngine(f ComputeFunction, settings *InferenceEngineSettings) *InferenceEngine {
-	return &InferenceEngine{
+	if settings == nil {
+		settings = &InferenceEngineSettings{}
+	}
+	clock := settings.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	ie := &InferenceEngine{
 		Nodes:                      []*InferenceNode{},
 		AddNodeChan:                make(chan *InferenceNode, 16384),
+		RetireNodeChan:             make(chan *InferenceNode, 16384),
 		IncomingJobs:               make(chan []*ComputeJob, 16384),
 		InferenceDone:              make(chan *InferenceNode, 16384),
+		batchResults:               make(chan *batchResult, 16384),
 		ProcessesTotalJobs:         make(map[string]uint64),
 		ProcessesTotalTimeWaiting:  make(map[string]time.Duration),
 		ProcessesTotalTimeConsumed: make(map[string]time.Duration),
+		ProcessesTotalTokens:       make(map[string]*TokensUsage),
+		ModelsTotalTokens:          make(map[string]*TokensUsage),
+		ProcessesTotalCost:         make(map[string]float64),
+		TenantsTotalCost:           make(map[string]float64),
 		ComputeFunction:            f,
 		settings:                   settings,
+		clock:                      clock,
+		jobsBuffer: map[JobPriority][]*ComputeJob{
+			PRIO_System:     {},
+			PRIO_Kernel:     {},
+			PRIO_User:       {},
+			PRIO_Background: {},
+		},
+		startedAt: clock.Now(),
+	}
+	ie.requeue = func(jobs []*ComputeJob) {
+		go func() {
+			ie.IncomingJobs <- jobs
+		}()
 	}
+
+	return ie
 }
 
 func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
@@ -59,7 +114,7 @@ func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
 		MaxBatchSize:          node.MaxBatchSize,
 		Performance:           0,
 		MaxRequests:           node.MaxRequests,
-		Models:                nil,
+		Models:                append([]string{}, node.Models...),
 		RequestsServed:        0,
 		TimeConsumed:          0,
 		TokensProcessed:       0,
@@ -70,12 +125,15 @@ func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
 		EmbeddingsDims:        nil,
 		Protocol:              node.Protocol,
 		Token:                 node.Token,
+		ChatTemplate:          node.ChatTemplate,
+		Template:              node.Template,
+		Mock:                  node.Mock,
+		ContextLength:         node.ContextLength,
 	}
 	autodetectFinished := make(chan *InferenceNode, 1)
-	go func(node *InferenceNode) {
-		engines.StartInferenceEngine(newRemoteEngine, doneChannel)
-		node.RemoteEngine = newRemoteEngine
-	}(node)
+	node.RemoteEngine = newRemoteEngine
+	node.retired = make(chan struct{})
+	go engines.StartInferenceEngine(newRemoteEngine, doneChannel)
 
 	go func(node *InferenceNode) {
 		<-doneChannel
@@ -86,9 +144,17 @@ func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
 			zlog.Info().Str("url", node.EndpointUrl).Msg("compute node failed to run completion and embeddings")
 			autodetectFinished <- node
 		} else {
+			if node.MaxRequests == 0 && node.RemoteEngine.Slots > 0 {
+				// not configured, let's use all the slots engine has
+				node.MaxRequests = node.RemoteEngine.Slots
+				if node.MaxBatchSize == 0 {
+					node.MaxBatchSize = 1
+				}
+			}
 			autodetectFinished <- node
 			ie.AddNodeChan <- node
 			ie.InferenceDone <- node
+			go ie.refreshNodeModels(node)
 		}
 	}(node)
 
@@ -96,17 +162,41 @@ func (ie *InferenceEngine) AddNode(node *InferenceNode) chan *InferenceNode {
 }
 
 func (ie *InferenceEngine) AddJob(job *ComputeJob) {
-	job.receivedAt = time.Now()
+	job.receivedAt = ie.clock.Now()
+	if ie.settings.TraceWriter != nil {
+		ie.recordJob(job)
+	}
+	if !ie.admitJob(job) {
+		return
+	}
 	ie.IncomingJobs <- []*ComputeJob{job}
 }
 
 func (ie *InferenceEngine) WaitForNodeWithEmbeddings() (string, int, error) {
 	for {
 		for _, node := range ie.Nodes {
-			if node.RemoteEngine.EmbeddingsDims != nil {
-				return node.RemoteEngine.Models[0], int(*node.RemoteEngine.EmbeddingsDims), nil
+			if node.RemoteEngine == nil {
+				continue
+			}
+			if dims := node.RemoteEngine.GetEmbeddingsDims(); dims > 0 {
+				return node.RemoteEngine.EmbeddingsModel(), int(dims), nil
 			}
 		}
 		time.Sleep(1 * time.Second)
 	}
 }
+
+// refreshNodeModels periodically re-reads the list of node's models, to pick up models swapped on the node,
+// it stops once node is retired
+func (ie *InferenceEngine) refreshNodeModels(node *InferenceNode) {
+	for {
+		select {
+		case <-node.retired:
+			return
+		case <-ie.clock.After(engines.ModelsRefreshInterval):
+		}
+		if err := engines.RefreshModels(node.RemoteEngine); err != nil {
+			zlog.Warn().Err(err).Str("url", node.EndpointUrl).Msg("failed to refresh node's models")
+		}
+	}
+}
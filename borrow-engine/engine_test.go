@@ -1,67 +1,568 @@
 package borrow_engine
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/settings"
 	"math/rand"
+	"reflect"
+	"runtime"
 	"testing"
 	"time"
 )
 
+var testProcesses = []string{
+	"agent-test",
+	"background[embeddings]",
+	"agent-user-chat",
+	"agent-project-manager",
+	"agent-c++-developer",
+	"agent-python-developer",
+	"agent-frontend-developer",
+	"agent-html5-developer",
+	"agent-general-research",
+	"background[default-mode-network]",
+}
+
+func randomTrace(seed int64, jobs int, interval time.Duration) []*TraceRecord {
+	random := rand.New(rand.NewSource(seed))
+	trace := make([]*TraceRecord, jobs)
+	for i := range trace {
+		trace[i] = &TraceRecord{
+			At:       time.Duration(i) * interval,
+			JobId:    fmt.Sprintf("job-%d", i),
+			JobType:  JobType(random.Intn(2)),
+			Priority: JobPriority(random.Intn(4)),
+			Process:  testProcesses[(random.Intn(len(testProcesses))+random.Intn(len(testProcesses))+random.Intn(len(testProcesses)))/3],
+		}
+	}
+
+	return trace
+}
+
+func testNodes(count int) []*InferenceNode {
+	nodes := make([]*InferenceNode, count)
+	for i := range nodes {
+		nodes[i] = &InferenceNode{
+			EndpointUrl:  fmt.Sprintf("http://127.0.0.1:800%d/v1/completions", i),
+			MaxRequests:  1 + i%2,
+			MaxBatchSize: 16,
+			JobTypes:     []JobType{JT_Completion, JT_Embeddings},
+		}
+	}
+
+	return nodes
+}
+
 func TestComputeRoutingWorksTest(t *testing.T) {
-	// create engine
-	engine := NewInferenceEngine(ComputeFunction{
-		JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
-			//fmt.Printf("Running batch of %d jobs on node %s\n", len(jobs), node.EndpointUrl)
-			time.Sleep(time.Duration(1+rand.Intn(1000)) * time.Millisecond)
-			return jobs, nil
+	trace := randomTrace(1, 5_000, 2*time.Millisecond)
+	simulate := func() *SimulationReport {
+		return Simulate(trace, &SimulationSettings{
+			Nodes: testNodes(5),
+			Compute: SimulatedComputeFunction{
+				JT_Completion: LinearLatency(200*time.Millisecond, 20*time.Millisecond),
+				JT_Embeddings: LinearLatency(20*time.Millisecond, time.Millisecond),
+			},
+		})
+	}
+
+	report := simulate()
+	t.Logf("simulation report:\n%s", report)
+	if report.JobsCompleted != len(trace) || report.JobsUnscheduled != 0 {
+		t.Fatalf("expected all %d jobs to complete: %d completed, %d unscheduled",
+			len(trace), report.JobsCompleted, report.JobsUnscheduled)
+	}
+	if report.Utilization <= 0 || report.Utilization > 1 {
+		t.Fatalf("utilization is out of range: %f", report.Utilization)
+	}
+	if report.PriorityLatency[PRIO_System].P50 >= report.PriorityLatency[PRIO_Background].P50 {
+		t.Fatalf("system jobs should wait less than background ones: %v vs %v",
+			report.PriorityLatency[PRIO_System], report.PriorityLatency[PRIO_Background])
+	}
+
+	// same trace on the same nodes has to give the same results
+	if again := simulate(); !reflect.DeepEqual(report, again) {
+		t.Fatalf("simulation is not deterministic:\n%s\nvs\n%s", report, again)
+	}
+}
+
+func TestSimulationRetriesFailedBatches(t *testing.T) {
+	failures := 0
+	report := Simulate(randomTrace(2, 200, 10*time.Millisecond), &SimulationSettings{
+		Nodes: testNodes(2),
+		Compute: SimulatedComputeFunction{
+			JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+				if failures < 3 {
+					failures++
+					return time.Second, fmt.Errorf("node is down")
+				}
+				return 100 * time.Millisecond, nil
+			},
+			JT_Embeddings: LinearLatency(10*time.Millisecond, 0),
 		},
-		JT_Embeddings: func(node *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
-			//fmt.Printf("Running batch of %d jobs on node %s\n", len(jobs), node.EndpointUrl)
-			time.Sleep(time.Duration(1+rand.Intn(1000)) * time.Millisecond)
-			return jobs, nil
+		StarvationThreshold: 2 * time.Second,
+	})
+
+	if report.JobsCompleted != 200 || report.BatchesFailed != 3 {
+		t.Fatalf("failed batches should be retried: %s", report)
+	}
+	if report.StarvedJobs == 0 || report.MaxWait < 5*time.Second {
+		t.Fatalf("jobs waiting for failed nodes to recover should be starving: %s", report)
+	}
+}
+
+func TestTraceRecording(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	clock := NewVirtualClock(time.Now())
+	engine := NewInferenceEngine(nil, &InferenceEngineSettings{Clock: clock, TraceWriter: buffer})
+	engine.AddJob(&ComputeJob{JobId: "first", Process: "agent-test", Priority: PRIO_User})
+	clock.Advance(time.Second)
+	engine.AddJob(&ComputeJob{JobId: "second", Process: "agent-test", JobType: JT_Embeddings})
+
+	trace, err := ReadTrace(buffer)
+	if err != nil {
+		t.Fatalf("failed to read trace: %v", err)
+	}
+	if len(trace) != 2 || trace[1].At != time.Second || trace[1].JobType != JT_Embeddings || trace[0].Priority != PRIO_User {
+		t.Fatalf("unexpected trace: %+v", trace)
+	}
+
+	report := Simulate(trace, &SimulationSettings{
+		Nodes:   testNodes(1),
+		Compute: SimulatedComputeFunction{JT_Completion: LinearLatency(time.Second, 0)},
+	})
+	if report.JobsCompleted != 2 {
+		t.Fatalf("recorded trace is not replayed: %s", report)
+	}
+}
+
+func modelNode(url string, models ...string) *InferenceNode {
+	return &InferenceNode{
+		EndpointUrl:  url,
+		MaxRequests:  1,
+		MaxBatchSize: 8,
+		JobTypes:     []JobType{JT_Completion},
+		RemoteEngine: &engines.RemoteInferenceEngine{EndpointUrl: url, Models: models},
+	}
+}
+
+func TestSimulationFallsBackToOtherModels(t *testing.T) {
+	trace := make([]*TraceRecord, 50)
+	for i := range trace {
+		trace[i] = &TraceRecord{
+			At:      time.Duration(i) * 100 * time.Millisecond,
+			JobId:   fmt.Sprintf("job-%d", i),
+			JobType: JT_Completion,
+			Process: "agent-test",
+			Model:   "mistral-7b-*",
+		}
+	}
+	fallbacks := []settings.FallbackConfigurationSection{
+		{Model: "mistral-7b-*", Chain: []string{"dolphin-*", "any"}, Wait: 600},
+	}
+	alwaysFails := func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+		if node.EndpointUrl == "http://mistral" {
+			return time.Second, fmt.Errorf("node is down")
+		}
+		return 100 * time.Millisecond, nil
+	}
+
+	// without fallbacks jobs wait for the dead node forever
+	report := Simulate(trace, &SimulationSettings{
+		Nodes:   []*InferenceNode{modelNode("http://mistral", "mistral-7b-instruct"), modelNode("http://dolphin", "dolphin-2.6-mistral")},
+		Compute: SimulatedComputeFunction{JT_Completion: alwaysFails},
+	})
+	if report.JobsCompleted != 0 {
+		t.Fatalf("jobs should not run on other models without fallbacks: %s", report)
+	}
+
+	// once mistral's circuit is open, jobs switch to dolphin without waiting
+	report = Simulate(trace, &SimulationSettings{
+		Nodes:     []*InferenceNode{modelNode("http://mistral", "mistral-7b-instruct"), modelNode("http://dolphin", "dolphin-2.6-mistral")},
+		Compute:   SimulatedComputeFunction{JT_Completion: alwaysFails},
+		Fallbacks: fallbacks,
+	})
+	if report.JobsCompleted != len(trace) || report.JobsFellBack != len(trace) || report.BatchesFailed != circuitBreakerThreshold {
+		t.Fatalf("jobs should fall back to dolphin after %d failures: %s", circuitBreakerThreshold, report)
+	}
+	if report.MaxWait > time.Minute {
+		t.Fatalf("jobs should not wait for the fallback timeout: %s", report)
+	}
+
+	// no node serves mistral, so jobs fall back after waiting
+	fallbacks[0].Wait = 10
+	report = Simulate(trace, &SimulationSettings{
+		Nodes:     []*InferenceNode{modelNode("http://dolphin", "dolphin-2.6-mistral")},
+		Compute:   SimulatedComputeFunction{JT_Completion: alwaysFails},
+		Fallbacks: fallbacks,
+	})
+	if report.JobsCompleted != len(trace) || report.Wait.P50 < 5*time.Second || report.MaxWait > 11*time.Second {
+		t.Fatalf("jobs should fall back after the wait: %s", report)
+	}
+}
+
+func TestSimulationKeepsBusyPeersCircuitClosed(t *testing.T) {
+	busy := 0
+	report := Simulate(randomTrace(3, 50, 10*time.Millisecond), &SimulationSettings{
+		Nodes: testNodes(1),
+		Compute: SimulatedComputeFunction{
+			JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+				if busy < 2*circuitBreakerThreshold {
+					busy++
+					return 10 * time.Millisecond, fmt.Errorf("%w: peer is busy", engines.ErrNoSpareCapacity)
+				}
+				return 100 * time.Millisecond, nil
+			},
+			JT_Embeddings: LinearLatency(10*time.Millisecond, 0),
 		},
-	}, nil)
-	go engine.Run()
-
-	// add ten random nodes with
-	// max requests 1-2
-	// max batch size 70-100
-	// job types: JT_Completion, JT_Embeddings
-	for i := 0; i < 5; i++ {
-		node := &InferenceNode{
-			EndpointUrl:  fmt.Sprintf("http://127.0.0.1:800%d/v1/completions", i),
-			MaxRequests:  1 + rand.Intn(2),
-			MaxBatchSize: 128,
-			JobTypes:     []JobType{JT_Completion, JT_Embeddings},
+	})
+
+	if report.JobsCompleted != 50 || report.BatchesFailed != 2*circuitBreakerThreshold {
+		t.Fatalf("batches refused by busy peer should be retried: %s", report)
+	}
+	if report.MaxWait >= circuitBreakerCooldown {
+		t.Fatalf("busy peer's circuit shouldn't open: %s", report)
+	}
+}
+
+func TestSimulationStopsProcessesOverBudget(t *testing.T) {
+	trace := make([]*TraceRecord, 20)
+	for i := range trace {
+		trace[i] = &TraceRecord{
+			At:      time.Duration(i) * 2 * time.Second,
+			JobId:   fmt.Sprintf("job-%d", i),
+			JobType: JT_Completion,
+			Process: []string{"acme/agent-test", "agent-user-chat"}[i%2],
+		}
+	}
+	node := testNodes(1)[0]
+	node.MaxRequests = 1
+	// a second of node's time costs 1
+	node.Cost = &settings.CostConfigurationSection{PerHour: 3600}
+
+	report := Simulate(trace, &SimulationSettings{
+		Nodes:   []*InferenceNode{node},
+		Compute: SimulatedComputeFunction{JT_Completion: LinearLatency(time.Second, 0)},
+		Budgets: []settings.BudgetConfigurationSection{{Tenant: "acme", Limit: 3}},
+	})
+	if report.JobsCompleted != 13 || report.ProcessCost["acme/agent-test"] != 3 || report.ProcessCost["agent-user-chat"] != 10 {
+		t.Fatalf("acme's jobs should be rejected after it spent 3: %s, %v", report, report.ProcessCost)
+	}
+	if report.Cost != 13 || node.TotalCost != 13 {
+		t.Fatalf("node's spend is wrong: %f", node.TotalCost)
+	}
+}
+
+func TestSimulationPlacesJobsByObjective(t *testing.T) {
+	trace := make([]*TraceRecord, 40)
+	for i := range trace {
+		trace[i] = &TraceRecord{
+			At:       time.Duration(i) * 3 * time.Second,
+			JobId:    fmt.Sprintf("job-%d", i),
+			JobType:  JT_Completion,
+			Priority: []JobPriority{PRIO_User, PRIO_Background}[i%2],
+			Process:  "agent-test",
 		}
-		engine.AddNode(node)
-	}
-
-	processes := []string{
-		"agent-test",
-		"background[embeddings]",
-		"agent-user-chat",
-		"agent-project-manager",
-		"agent-c++-developer",
-		"agent-python-developer",
-		"agent-frontend-developer",
-		"agent-html5-developer",
-		"agent-general-research",
-		"background[default-mode-network]",
-	}
-
-	for {
-		// add 1000 random jobs with random priority
-		// and random job type
-		for i := 0; i < 100_000; i++ {
-			job := &ComputeJob{
-				JobId:    fmt.Sprintf("job-%d", i),
-				JobType:  JobType(rand.Intn(2)),
-				Priority: JobPriority(rand.Intn(4)),
-				Process:  processes[(rand.Int()%len(processes)+rand.Int()%len(processes)+rand.Int()%len(processes))/3],
+	}
+	simulate := func(scheduling settings.SchedulingConfigurationSection) *SimulationReport {
+		// slow and rented node is the first one
+		nodes := testNodes(2)
+		nodes[0].Cost = &settings.CostConfigurationSection{PerHour: 3600}
+		return Simulate(trace, &SimulationSettings{
+			Nodes: nodes,
+			Compute: SimulatedComputeFunction{
+				JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+					if node == nodes[0] {
+						return time.Second, nil
+					}
+					return 2 * time.Second, nil
+				},
+			},
+			Scheduling: scheduling,
+		})
+	}
+
+	firstFit := simulate(settings.SchedulingConfigurationSection{
+		Objectives: map[string]string{"user": "first-fit", "background": "first-fit"},
+	})
+	if firstFit.Cost != float64(len(trace)) {
+		t.Fatalf("first-fit should run every job on the first node: %s", firstFit)
+	}
+
+	// user jobs go to the fast node, background ones to the free one, once they're measured
+	report := simulate(settings.SchedulingConfigurationSection{})
+	t.Logf("simulation report:\n%s", report)
+	if report.PriorityLatency[PRIO_User].P90 != time.Second || report.PriorityLatency[PRIO_Background].P90 != 2*time.Second {
+		t.Fatalf("jobs should be placed by the objective of their priority: %s", report)
+	}
+	if report.Cost >= float64(len(trace)/2)+2 {
+		t.Fatalf("background jobs should run on the free node: %s", report)
+	}
+}
+
+func TestSimulationAdmitsJobsByTargetWait(t *testing.T) {
+	// background jobs come 4 times faster than the node runs them, user jobs are rare
+	trace := make([]*TraceRecord, 200)
+	for i := range trace {
+		trace[i] = &TraceRecord{
+			At:       time.Duration(i) * 250 * time.Millisecond,
+			JobId:    fmt.Sprintf("job-%d", i),
+			JobType:  JT_Completion,
+			Priority: PRIO_Background,
+			Process:  "background[default-mode-network]",
+		}
+		if i%10 == 0 {
+			trace[i].Priority = PRIO_User
+			trace[i].Process = "agent-user-chat"
+		}
+	}
+	node := testNodes(1)[0]
+	node.MaxBatchSize = 1
+
+	report := Simulate(trace, &SimulationSettings{
+		Nodes:   []*InferenceNode{node},
+		Compute: SimulatedComputeFunction{JT_Completion: LinearLatency(time.Second, 0)},
+		Scheduling: settings.SchedulingConfigurationSection{
+			TargetWaits: map[string]int{"user": 2_000, "background": 5_000},
+		},
+	})
+	t.Logf("simulation report:\n%s", report)
+	if report.JobsRejected == 0 || report.JobsUnscheduled != 0 {
+		t.Fatalf("background jobs over the target should be rejected: %s", report)
+	}
+	// user jobs, which come later, run before admitted background ones, so background jobs wait longer than
+	// projected, yet their wait doesn't grow with the queue
+	if report.PriorityLatency[PRIO_User].Max > 2*time.Second || report.MaxWait > 10*time.Second {
+		t.Fatalf("admitted jobs should meet their targets: %s", report)
+	}
+
+	engine := NewInferenceEngine(nil, &InferenceEngineSettings{
+		Scheduling: settings.SchedulingConfigurationSection{TargetWaits: map[string]int{"user": 1_000}},
+	})
+	engine.addNode(node)
+	engine.bufferJobs([]*ComputeJob{{JobId: "user", Priority: PRIO_User}, {JobId: "other", Priority: PRIO_User}})
+	job := &ComputeJob{JobId: "background", Priority: PRIO_Background, ComputeResult: &ComputeResult{ErrorChannel: make(chan error, 1)}}
+	engine.AddJob(job)
+	err := <-job.ComputeResult.ErrorChannel
+	overloaded := &OverloadedError{}
+	if !errors.As(err, &overloaded) || overloaded.Priority != PRIO_User || overloaded.RetryAfter <= 0 {
+		t.Fatalf("background jobs should be shed, while user jobs miss the target: %v", err)
+	}
+}
+
+func TestSimulationRoutesJobsByLabels(t *testing.T) {
+	trace := randomTrace(3, 300, 50*time.Millisecond)
+	for i, record := range trace {
+		if i%3 == 0 {
+			record.Process = "acme/agent-test"
+		}
+		if i%3 == 1 {
+			record.PreferLabels = map[string]string{"gpu": "a6000"}
+		}
+	}
+	nodes := testNodes(3)
+	nodes[1].Labels = map[string]string{"gpu": "a6000"}
+	nodes[2].Labels = map[string]string{"private": "true"}
+	placement := make(map[string]map[*InferenceNode]int)
+	record := func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+		for _, job := range jobs {
+			if placement[job.Process] == nil {
+				placement[job.Process] = make(map[*InferenceNode]int)
 			}
-			engine.AddJob(job)
+			placement[job.Process][node]++
 		}
-		time.Sleep(100 * time.Millisecond)
+		return 100 * time.Millisecond, nil
+	}
+
+	report := Simulate(trace, &SimulationSettings{
+		Nodes:   nodes,
+		Compute: SimulatedComputeFunction{JT_Completion: record, JT_Embeddings: record},
+		Routing: []settings.RoutingConfigurationSection{
+			{Tenant: "acme", Require: map[string]string{"private": "true"}},
+		},
+	})
+	if report.JobsCompleted != len(trace) {
+		t.Fatalf("all jobs should complete: %s", report)
+	}
+	acme := placement["acme/agent-test"]
+	if len(acme) != 1 || acme[nodes[2]] == 0 {
+		t.Fatalf("acme's jobs should only run on the private node: %v", acme)
+	}
+
+	require, prefer := NewInferenceEngine(nil, &InferenceEngineSettings{
+		Routing: []settings.RoutingConfigurationSection{
+			{Require: map[string]string{"region": "eu"}},
+			{Process: "agent-test", Prefer: map[string]string{"pool": "cheap"}},
+		},
+	}).RoutingLabels("agent-test", map[string]string{"region": "us", "gpu": "a6000"}, nil)
+	if !reflect.DeepEqual(require, map[string]string{"region": "eu", "gpu": "a6000"}) ||
+		!reflect.DeepEqual(prefer, map[string]string{"pool": "cheap"}) {
+		t.Fatalf("policies should be merged with request's labels: %v, %v", require, prefer)
+	}
+}
+
+func TestSimulationRunsRegisteredJobTypes(t *testing.T) {
+	rerank := RegisterJobType(JobTypeDefinition{
+		Name:         "rerank",
+		MaxBatchSize: 4,
+		NodeCapable: func(node *InferenceNode) bool {
+			return node.Labels["reranker"] == "true"
+		},
+	})
+	if jobType, exists := JobTypeByName("rerank"); !exists || jobType != rerank {
+		t.Fatalf("registered job type should be found by name: %v", jobType)
+	}
+
+	trace := randomTrace(4, 300, 20*time.Millisecond)
+	for i, record := range trace {
+		if i%3 == 0 {
+			record.JobType = rerank
+		}
+	}
+	nodes := testNodes(3)
+	for _, node := range nodes {
+		node.JobTypes = append(node.JobTypes, rerank)
+	}
+	nodes[2].Labels = map[string]string{"reranker": "true"}
+	maxBatch := 0
+	run := func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+		if node != nodes[2] {
+			t.Errorf("rerank jobs should only run on capable node, got %s", node.EndpointUrl)
+		}
+		maxBatch = max(maxBatch, len(jobs))
+		return 50 * time.Millisecond, nil
+	}
+	done := func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+		return 100 * time.Millisecond, nil
+	}
+
+	report := Simulate(trace, &SimulationSettings{
+		Nodes:   nodes,
+		Compute: SimulatedComputeFunction{JT_Completion: done, JT_Embeddings: done, rerank: run},
+	})
+	if report.JobsCompleted != len(trace) {
+		t.Fatalf("all jobs should complete: %s", report)
+	}
+	if maxBatch == 0 || maxBatch > 4 {
+		t.Fatalf("rerank batches should be limited by job type: %d", maxBatch)
+	}
+
+	data, _ := json.Marshal(trace[0])
+	record := &TraceRecord{}
+	if err := json.Unmarshal(data, record); err != nil || record.JobType != rerank ||
+		!bytes.Contains(data, []byte(`"job-type":"rerank"`)) {
+		t.Fatalf("job type should be written by name: %s, %v", data, err)
+	}
+}
+
+func TestAutoscalerRentsAndReleasesNodes(t *testing.T) {
+	clock := NewVirtualClock(simulationStart)
+	release := make(chan struct{})
+	ie := NewInferenceEngine(ComputeFunction{
+		JT_Completion: func(node *InferenceNode, jobs []*ComputeJob) ([]*ComputeJob, error) {
+			<-release
+			return jobs, nil
+		},
+	}, &InferenceEngineSettings{Clock: clock})
+	go ie.Run()
+
+	provisioner := NewLocalProvisioner(3, 2, nil)
+	autoscaler := NewAutoscaler(ie, provisioner, &settings.AutoscalingConfigurationSection{
+		MaxNodes:    2,
+		QueueDepth:  10,
+		IdleTimeout: 1,
+	})
+	for i := 0; i < 200; i++ {
+		ie.AddJob(&ComputeJob{JobId: fmt.Sprintf("job-%d", i), JobType: JT_Completion, Priority: PRIO_User, Process: "agent-test"})
+	}
+
+	// engine runs in its own goroutine, so the test waits for its state, not for the time
+	waitFor := func(condition func() bool) {
+		for {
+			ie.jobsBufferLock.RLock()
+			done := condition()
+			ie.jobsBufferLock.RUnlock()
+			if done {
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+	waitFor(func() bool { return len(ie.jobsBuffer[PRIO_User]) == 200 })
+
+	// batches are held, so the queue is deep until both nodes are rented
+	for provisioner.Running() < 2 {
+		autoscaler.step()
+		autoscaler.starting.Wait()
+	}
+	autoscaler.step()
+	autoscaler.starting.Wait()
+	if provisioner.Running() != 2 {
+		t.Fatalf("no more than max-nodes should be rented: %d", provisioner.Running())
+	}
+	// capacity is read by request handlers, while the scheduler keeps running
+	if capacity := ie.GetSpareCapacity(); capacity.FreeSlots != 0 || capacity.TotalSlots > 2 {
+		t.Fatalf("queued jobs should take all the slots: %+v", capacity)
+	}
+
+	close(release)
+	waitFor(func() bool { return ie.TotalJobsProcessed == 200 })
+	clock.Advance(2 * time.Second)
+	for provisioner.Running() > 0 {
+		autoscaler.step()
+		runtime.Gosched()
+	}
+
+	if autoscaler.Spent() <= 0 {
+		t.Fatalf("rent of the released nodes should be accounted")
+	}
+	ie.jobsBufferLock.RLock()
+	defer ie.jobsBufferLock.RUnlock()
+	for _, node := range ie.Nodes {
+		if !node.Retired {
+			t.Fatalf("released node should be retired: %s", node.EndpointUrl)
+		}
+	}
+}
+
+func TestRetiredNodeStopsRefreshingModels(t *testing.T) {
+	clock := NewVirtualClock(simulationStart)
+	ie := NewInferenceEngine(nil, &InferenceEngineSettings{Clock: clock})
+	node := &InferenceNode{
+		RemoteEngine: &engines.RemoteInferenceEngine{Protocol: engines.ProtocolMock, Mock: &settings.MockConfigurationSection{}},
+		retired:      make(chan struct{}),
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		ie.refreshNodeModels(node)
+		close(stopped)
+	}()
+	clock.Advance(engines.ModelsRefreshInterval)
+	ie.retireNode(node)
+	<-stopped
+}
+
+func TestJobsBatchOnlyWithSameGenerationSettings(t *testing.T) {
+	job := func(temperature float32, stopTokens ...string) *ComputeJob {
+		return &ComputeJob{JobType: JT_Completion, GenerationSettings: &engines.GenerationSettings{
+			RawPrompt:   "prompt",
+			Temperature: temperature,
+			StopTokens:  stopTokens,
+		}}
+	}
+	batch := []*ComputeJob{job(0.7, "###")}
+
+	if !canBatchWith(batch, job(0.7, "###")) {
+		t.Fatalf("jobs with the same settings should share a batch")
+	}
+	if canBatchWith(batch, job(0.1, "###")) {
+		t.Fatalf("job would run with temperature of the first job")
+	}
+	if canBatchWith(batch, job(0.7, "\n")) {
+		t.Fatalf("job would run with stop tokens of the first job")
 	}
 }
@@ -0,0 +1,120 @@
+package borrow_engine
+
+import (
+	"github.com/d0rc/agent-os/engines"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// defaultFallbackWait is the time job waits for its model, before switching to the next one of the chain
+const defaultFallbackWait = 30 * time.Second
+
+// circuitBreakerThreshold is the number of failures in a row, after which node's circuit is open
+const circuitBreakerThreshold = 3
+
+// circuitBreakerCooldown is the time node with open circuit gets no jobs,
+// after it passes, node gets a single batch to check if it's back
+const circuitBreakerCooldown = time.Minute
+
+// anyModel in the fallback chain lets job run on any node
+const anyModel = "any"
+
+// circuitOpen is true, if node failed too many times in a row recently
+func (n *InferenceNode) circuitOpen(now time.Time) bool {
+	return n.ConsecutiveFailures >= circuitBreakerThreshold && now.Sub(n.LastFailure) < circuitBreakerCooldown
+}
+
+// setFallbackChain picks job's fallback chain, the one of the request is used, if it's set,
+// otherwise the first configured chain matching job's model mask
+func (ie *InferenceEngine) setFallbackChain(job *ComputeJob) {
+	job.modelSince = ie.clock.Now()
+	if job.GenerationSettings == nil || job.GenerationSettings.Model == "" || job.GenerationSettings.Model == "*" {
+		return
+	}
+
+	job.fallbackChain = job.GenerationSettings.Fallback
+	job.fallbackWait = job.GenerationSettings.FallbackWait
+	if len(job.fallbackChain) == 0 {
+		for _, fallback := range ie.settings.Fallbacks {
+			if engines.MatchModel(fallback.Model, job.GenerationSettings.Model) {
+				job.fallbackChain = fallback.Chain
+				if job.fallbackWait == 0 {
+					job.fallbackWait = time.Duration(fallback.Wait) * time.Second
+				}
+				break
+			}
+		}
+	}
+
+	if job.fallbackWait == 0 {
+		job.fallbackWait = defaultFallbackWait
+	}
+}
+
+// applyFallbacks switches buffered jobs, which waited too long for their model, or whose
+// model is served only by nodes with open circuit, to the next model of their chain
+func (ie *InferenceEngine) applyFallbacks() {
+	ie.jobsBufferLock.Lock()
+	defer ie.jobsBufferLock.Unlock()
+
+	now := ie.clock.Now()
+	for priority := PRIO_System; priority <= PRIO_Background; priority++ {
+		for _, job := range ie.jobsBuffer[priority] {
+			for len(job.fallbackChain) > 0 && ie.modelUnavailable(job, now) {
+				job.fallBack(now)
+			}
+		}
+	}
+}
+
+func (ie *InferenceEngine) modelUnavailable(job *ComputeJob, now time.Time) bool {
+	if now.Sub(job.modelSince) >= job.fallbackWait {
+		return true
+	}
+
+	served := false
+	for _, node := range ie.Nodes {
+		if !node.runsJobType(job.JobType) || !node.acceptsJob(job) {
+			continue
+		}
+		if !node.circuitOpen(now) {
+			return false
+		}
+		served = true
+	}
+
+	// jobs keep waiting for nodes, which are not added yet
+	return served
+}
+
+func (job *ComputeJob) fallBack(now time.Time) {
+	model := job.fallbackChain[0]
+	job.fallbackChain = job.fallbackChain[1:]
+	if model == anyModel {
+		model = "*"
+	}
+	if job.requestedModel == "" {
+		job.requestedModel = job.GenerationSettings.Model
+	}
+
+	log.Debug().Str("job", job.JobId).
+		Str("from", job.GenerationSettings.Model).
+		Str("to", model).
+		Msg("switching job to fallback model")
+	job.GenerationSettings.Model = model
+	job.modelSince = now
+}
+
+func (n *InferenceNode) runsJobType(jobType JobType) bool {
+	if n.Retired {
+		return false
+	}
+	for _, jt := range n.JobTypes {
+		if jt == jobType {
+			definition := jobType.definition()
+			return definition == nil || definition.NodeCapable == nil || definition.NodeCapable(n)
+		}
+	}
+
+	return false
+}
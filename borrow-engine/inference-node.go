@@ -2,6 +2,7 @@ package borrow_engine
 
 import (
 	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/settings"
 	"time"
 )
 
@@ -16,6 +17,8 @@ type InferenceNode struct {
 	TotalRequestsProcessed uint64
 	TotalTimeConsumed      time.Duration
 	TotalTimeIdle          time.Duration
+	TotalPromptTokens      uint64
+	TotalTokensGenerated   uint64
 
 	RequestsRunning     int
 	LastIdleAt          time.Time
@@ -24,18 +27,60 @@ type InferenceNode struct {
 	TotalRequestsFailed uint64
 	TotalJobsFailed     uint64
 	LastFailure         time.Time
+	ConsecutiveFailures int // circuit is open, once there are enough of them, see circuitOpen
 	Protocol            string
 	Token               string
+	ChatTemplate        string
+	Models              []string // models configured for the node, more can be discovered
+	Template            *settings.HttpTemplateConfigurationSection
+	Mock                *settings.MockConfigurationSection
+	ContextLength       int // declared in config, discovered by the engine otherwise
+	Cost                *settings.CostConfigurationSection
+	TotalCost           float64
+	Performance         map[string]*NodePerformance // by model, "" - all the models of the node
+	Labels              map[string]string
+	Retired             bool          // node is released, it gets no jobs, and it's kept for the statistics only
+	retired             chan struct{} // closed once node is retired, stops node's background routines
 }
 
-func (n InferenceNode) RunBatch(cf ComputeFunction, jobs []*ComputeJob, nodeIdx int,
+// acceptsJob checks if job can be scheduled on the node
+func (n *InferenceNode) acceptsJob(job *ComputeJob) bool {
+	if !MatchLabels(n.Labels, job.requireLabels) {
+		return false
+	}
+
+	if job.GenerationSettings != nil && job.GenerationSettings.LocalOnly && n.IsFederationPeer() {
+		return false
+	}
+
+	if job.GenerationSettings != nil && n.RemoteEngine != nil &&
+		!n.RemoteEngine.ServesModel(job.GenerationSettings.Model) {
+		return false
+	}
+
+	if job.GenerationSettings != nil && n.RemoteEngine != nil {
+		if contextLength := n.RemoteEngine.GetContextLength(); contextLength > 0 &&
+			job.GenerationSettings.ContextTokens > contextLength {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (n InferenceNode) RunBatch(cf ComputeFunction, jobs []*ComputeJob, nodeIdx int, clock Clock,
 	f func(int, time.Time),
 	failFunc func(int, time.Time, error)) {
 	// fmt.Printf("Running batch of %d jobs on node %s\n", len(jobs), n.EndpointUrl)
-	// sleep for random time between 1 and 5 seconds
-	ts := time.Now()
+	ts := clock.Now()
+
+	compute := cf.computeFunction(jobs[0].JobType)
+	if compute == nil {
+		failFunc(nodeIdx, ts, ErrNoComputeFunction)
+		return
+	}
 
-	_, err := cf[jobs[0].JobType](&n, jobs)
+	_, err := compute(&n, jobs)
 	if err != nil {
 		// we need to retry the jobs, or send jobs back to the general queue
 		// also it's a good idea to account engine failure at this point...
@@ -0,0 +1,158 @@
+package borrow_engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrNoComputeFunction = errors.New("no compute function for the job type")
+
+// JobTypeDefinition declares a kind of work, jobs of all the types share the scheduler,
+// the priority queue and the accounting
+type JobTypeDefinition struct {
+	Name string // used in node's job-types config, traces and logs
+	// Compute runs the batch, engine's ComputeFunction is used instead, if it has the job type
+	Compute func(*InferenceNode, []*ComputeJob) ([]*ComputeJob, error)
+	// CanBatch checks if job can join the batch, by default jobs with the same generation settings can
+	CanBatch func(batch []*ComputeJob, job *ComputeJob) bool
+	// MaxBatchSize limits batches of the type, if it's lower than node's max batch size, 0 - no limit
+	MaxBatchSize int
+	// NodeCapable checks if node, which has the type in its job types, can run it, nil - it can
+	NodeCapable func(node *InferenceNode) bool
+}
+
+var jobTypesLock sync.RWMutex
+var jobTypeDefinitions = []*JobTypeDefinition{
+	JT_Completion: {Name: "completion"},
+	JT_Embeddings: {Name: "embeddings"},
+}
+
+// RegisterJobType declares a new job type, or replaces the definition of the type with the same name
+func RegisterJobType(definition JobTypeDefinition) JobType {
+	jobTypesLock.Lock()
+	defer jobTypesLock.Unlock()
+
+	for idx, existing := range jobTypeDefinitions {
+		if existing.Name == definition.Name {
+			jobTypeDefinitions[idx] = &definition
+			return JobType(idx)
+		}
+	}
+	jobTypeDefinitions = append(jobTypeDefinitions, &definition)
+
+	return JobType(len(jobTypeDefinitions) - 1)
+}
+
+// JobTypeByName returns the registered job type
+func JobTypeByName(name string) (JobType, bool) {
+	jobTypesLock.RLock()
+	defer jobTypesLock.RUnlock()
+
+	for idx, definition := range jobTypeDefinitions {
+		if definition.Name == name {
+			return JobType(idx), true
+		}
+	}
+
+	return JT_NotAJob, false
+}
+
+// ParseJobTypes translates job types of node's config, unknown types are skipped and reported
+func ParseJobTypes(names []string) ([]JobType, error) {
+	jobTypes := make([]JobType, 0, len(names))
+	var err error
+	for _, name := range names {
+		jobType, exists := JobTypeByName(name)
+		if !exists {
+			err = errors.Join(err, fmt.Errorf("unknown job type: %s", name))
+			continue
+		}
+		jobTypes = append(jobTypes, jobType)
+	}
+
+	return jobTypes, err
+}
+
+// registeredJobTypes are all the types of jobs, in the order they were registered
+func registeredJobTypes() []JobType {
+	jobTypesLock.RLock()
+	defer jobTypesLock.RUnlock()
+
+	jobTypes := make([]JobType, len(jobTypeDefinitions))
+	for idx := range jobTypes {
+		jobTypes[idx] = JobType(idx)
+	}
+
+	return jobTypes
+}
+
+// definition of the job type, nil if it's not registered
+func (jt JobType) definition() *JobTypeDefinition {
+	jobTypesLock.RLock()
+	defer jobTypesLock.RUnlock()
+
+	if jt < 0 || int(jt) >= len(jobTypeDefinitions) {
+		return nil
+	}
+
+	return jobTypeDefinitions[jt]
+}
+
+func (jt JobType) String() string {
+	if definition := jt.definition(); definition != nil {
+		return definition.Name
+	}
+
+	return "unknown"
+}
+
+// MarshalJSON writes the name, so traces don't depend on the order types were registered in
+func (jt JobType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jt.String())
+}
+
+// UnmarshalJSON reads the name, or the number, traces written before the registry have numbers
+func (jt *JobType) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*jt = JobType(number)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	jobType, exists := JobTypeByName(name)
+	if !exists {
+		return fmt.Errorf("unknown job type: %s", name)
+	}
+	*jt = jobType
+
+	return nil
+}
+
+// computeFunction of the job type, engine's one goes first
+func (cf ComputeFunction) computeFunction(jobType JobType) func(*InferenceNode, []*ComputeJob) ([]*ComputeJob, error) {
+	if compute, exists := cf[jobType]; exists {
+		return compute
+	}
+	if definition := jobType.definition(); definition != nil {
+		return definition.Compute
+	}
+
+	return nil
+}
+
+// batchLimit is the max number of jobs of the type in a batch for the node, 0 - no limit
+func (n *InferenceNode) batchLimit(jobType JobType) int {
+	limit := n.MaxBatchSize
+	if definition := jobType.definition(); definition != nil && definition.MaxBatchSize > 0 &&
+		(limit == 0 || definition.MaxBatchSize < limit) {
+		limit = definition.MaxBatchSize
+	}
+
+	return limit
+}
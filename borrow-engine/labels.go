@@ -0,0 +1,61 @@
+package borrow_engine
+
+// RoutingLabels merges labels of the request with routing policies of the process and its tenant,
+// policies without process and tenant apply to all processes, policies win over the request
+func (ie *InferenceEngine) RoutingLabels(process string, require, prefer map[string]string) (map[string]string, map[string]string) {
+	require = MergeLabels(nil, require)
+	prefer = MergeLabels(nil, prefer)
+
+	tenant := ProcessTenant(process)
+	for _, policy := range ie.settings.Routing {
+		if (policy.Process != "" && policy.Process != process) || (policy.Tenant != "" && policy.Tenant != tenant) {
+			continue
+		}
+		require = MergeLabels(require, policy.Require)
+		prefer = MergeLabels(prefer, policy.Prefer)
+	}
+
+	return require, prefer
+}
+
+func (ie *InferenceEngine) setLabels(job *ComputeJob) {
+	var require, prefer map[string]string
+	if job.GenerationSettings != nil {
+		require, prefer = job.GenerationSettings.RequireLabels, job.GenerationSettings.PreferLabels
+	}
+
+	job.requireLabels, job.preferLabels = ie.RoutingLabels(job.Process, require, prefer)
+}
+
+// MergeLabels returns a copy of labels with other labels added, nil if there are none
+func MergeLabels(labels, other map[string]string) map[string]string {
+	if len(labels)+len(other) == 0 {
+		return nil
+	}
+
+	merged := make(map[string]string, len(labels)+len(other))
+	for name, value := range labels {
+		merged[name] = value
+	}
+	for name, value := range other {
+		merged[name] = value
+	}
+
+	return merged
+}
+
+// MatchLabels checks if node's labels have all the required ones
+func MatchLabels(labels, required map[string]string) bool {
+	return countMatchingLabels(labels, required) == len(required)
+}
+
+func countMatchingLabels(labels, wanted map[string]string) int {
+	matching := 0
+	for name, value := range wanted {
+		if nodeValue, exists := labels[name]; exists && nodeValue == value {
+			matching++
+		}
+	}
+
+	return matching
+}
@@ -0,0 +1,207 @@
+package borrow_engine
+
+import (
+	"sort"
+	"time"
+)
+
+type PlacementObjective string
+
+const (
+	ObjectiveFastest  PlacementObjective = "fastest"   // node with the lowest batch latency
+	ObjectiveCheapest PlacementObjective = "cheapest"  // node with the lowest cost of a job
+	ObjectiveBalanced PlacementObjective = "balanced"  // best sum of latency and cost ranks
+	ObjectiveFirstFit PlacementObjective = "first-fit" // nodes in the order they were added
+)
+
+// performanceSmoothing is the weight of the latest batch in moving averages
+const performanceSmoothing = 0.2
+
+var priorityNames = map[JobPriority]string{
+	PRIO_System:     "system",
+	PRIO_Kernel:     "kernel",
+	PRIO_User:       "user",
+	PRIO_Background: "background",
+}
+
+// NodePerformance is measured by the scheduler, values are exponential moving averages
+type NodePerformance struct {
+	Batches         uint64
+	Latency         time.Duration // of a batch
+	JobsPerBatch    float64
+	TokensPerSecond float64
+	PromptTokens    float64 // per job
+	TokensGenerated float64 // per job
+}
+
+func ewma(average, value float64, first bool) float64 {
+	if first {
+		return value
+	}
+
+	return average + performanceSmoothing*(value-average)
+}
+
+func (p *NodePerformance) update(latency time.Duration, jobs []*ComputeJob) {
+	promptTokens, tokensGenerated := 0, 0
+	for _, job := range jobs {
+		if job.Usage != nil {
+			promptTokens += job.Usage.PromptTokens
+			tokensGenerated += job.Usage.TokensGenerated
+		}
+	}
+
+	first := p.Batches == 0
+	p.Batches++
+	p.Latency = time.Duration(ewma(float64(p.Latency), float64(latency), first))
+	p.JobsPerBatch = ewma(p.JobsPerBatch, float64(len(jobs)), first)
+	if latency > 0 {
+		p.TokensPerSecond = ewma(p.TokensPerSecond, float64(tokensGenerated)/latency.Seconds(), first)
+	}
+	p.PromptTokens = ewma(p.PromptTokens, float64(promptTokens)/float64(len(jobs)), first)
+	p.TokensGenerated = ewma(p.TokensGenerated, float64(tokensGenerated)/float64(len(jobs)), first)
+}
+
+// jobModel is the model job ran with on the node, empty if it's unknown
+func (n *InferenceNode) jobModel(job *ComputeJob) string {
+	if job.Usage != nil && job.Usage.Model != "" {
+		return job.Usage.Model
+	}
+	if job.GenerationSettings != nil && n.RemoteEngine != nil {
+		model, _ := n.RemoteEngine.ResolveModel(job.GenerationSettings.Model)
+		return model
+	}
+
+	return ""
+}
+
+// measurePerformance updates node's averages for the model of the batch and for the node
+// as a whole, it's called with jobs buffer lock held, as scheduler reads them under it
+func (n *InferenceNode) measurePerformance(jobs []*ComputeJob, latency time.Duration) {
+	if n.Performance == nil {
+		n.Performance = make(map[string]*NodePerformance)
+	}
+
+	models := []string{""}
+	if model := n.jobModel(jobs[0]); model != "" {
+		models = append(models, model)
+	}
+	for _, model := range models {
+		if n.Performance[model] == nil {
+			n.Performance[model] = &NodePerformance{}
+		}
+		n.Performance[model].update(latency, jobs)
+	}
+
+	if n.RemoteEngine != nil {
+		n.RemoteEngine.Performance = float32(n.Performance[""].TokensPerSecond)
+	}
+}
+
+// expectedPerformance of the job on the node, nil if node has no measurements yet
+func (n *InferenceNode) expectedPerformance(job *ComputeJob) *NodePerformance {
+	if model := n.jobModel(job); model != "" && n.Performance[model] != nil {
+		return n.Performance[model]
+	}
+
+	return n.Performance[""]
+}
+
+// expectedLatency of the job's batch, nodes without measurements are expected to be
+// the fastest and the cheapest, so they get jobs and are measured
+func (n *InferenceNode) expectedLatency(job *ComputeJob) time.Duration {
+	if performance := n.expectedPerformance(job); performance != nil {
+		return performance.Latency
+	}
+
+	return 0
+}
+
+// expectedCost is job's share of the batch time and its tokens
+func (n *InferenceNode) expectedCost(job *ComputeJob) float64 {
+	performance := n.expectedPerformance(job)
+	if n.Cost == nil || performance == nil {
+		return 0
+	}
+
+	return n.timeCost(performance.Latency)/max(performance.JobsPerBatch, 1) +
+		n.Cost.PerThousandPromptTokens*performance.PromptTokens/1000 +
+		n.Cost.PerThousandCompletionTokens*performance.TokensGenerated/1000
+}
+
+func (ie *InferenceEngine) objective(priority JobPriority) PlacementObjective {
+	if objective, exists := ie.settings.Scheduling.Objectives[priorityNames[priority]]; exists {
+		return PlacementObjective(objective)
+	}
+	if priority == PRIO_Background {
+		return ObjectiveCheapest
+	}
+
+	return ObjectiveFastest
+}
+
+// placementOrder is the order, in which nodes get their batches, it's chosen by the
+// objective of the most urgent job waiting, ties keep the order nodes were added in
+func (ie *InferenceEngine) placementOrder() []int {
+	order := make([]int, len(ie.Nodes))
+	for idx := range order {
+		order[idx] = idx
+	}
+
+	ie.jobsBufferLock.RLock()
+	defer ie.jobsBufferLock.RUnlock()
+
+	var job *ComputeJob
+	for priority := PRIO_System; priority <= PRIO_Background && job == nil; priority++ {
+		if len(ie.jobsBuffer[priority]) > 0 {
+			job = ie.jobsBuffer[priority][0]
+		}
+	}
+	if job == nil {
+		return order
+	}
+
+	latency := func(idx int) float64 { return float64(ie.Nodes[idx].expectedLatency(job)) }
+	cost := func(idx int) float64 { return ie.Nodes[idx].expectedCost(job) }
+	switch ie.objective(job.Priority) {
+	case ObjectiveFastest:
+		sortNodes(order, latency)
+	case ObjectiveCheapest:
+		sortNodes(order, cost)
+	case ObjectiveBalanced:
+		latencyRanks := ranks(order, latency)
+		costRanks := ranks(order, cost)
+		sortNodes(order, func(idx int) float64 {
+			return float64(latencyRanks[idx] + costRanks[idx])
+		})
+	}
+	if len(job.preferLabels) > 0 {
+		sortNodes(order, func(idx int) float64 {
+			return -float64(countMatchingLabels(ie.Nodes[idx].Labels, job.preferLabels))
+		})
+	}
+
+	return order
+}
+
+func sortNodes(order []int, score func(idx int) float64) {
+	sort.SliceStable(order, func(i, j int) bool {
+		return score(order[i]) < score(order[j])
+	})
+}
+
+// ranks of the nodes by the score, nodes with equal scores have the same rank
+func ranks(order []int, score func(idx int) float64) map[int]int {
+	sorted := append([]int{}, order...)
+	sortNodes(sorted, score)
+
+	result := make(map[int]int, len(sorted))
+	for pos, idx := range sorted {
+		result[idx] = pos
+		if pos > 0 && score(idx) == score(sorted[pos-1]) {
+			result[idx] = result[sorted[pos-1]]
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,138 @@
+package borrow_engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	mock_inference "github.com/d0rc/agent-os/mock-inference"
+	"github.com/d0rc/agent-os/settings"
+	"strconv"
+	"sync"
+)
+
+const ProvisionerLocal = "local"
+
+const defaultLocalOffers = 4
+
+var ErrOfferTaken = errors.New("offer is already rented")
+
+// LocalProvisioner rents fake boxes, each running mock engine on a local port,
+// so autoscaling can be tried and tested offline
+type LocalProvisioner struct {
+	offers  []*Offer
+	mock    *settings.MockConfigurationSection
+	lock    sync.Mutex
+	rented  map[string]*Rental
+	servers map[string]*mock_inference.Server
+}
+
+func init() {
+	RegisterProvisioner(ProvisionerLocal, func(config *settings.AutoscalingConfigurationSection) (Provisioner, error) {
+		offers := defaultLocalOffers
+		if value, exists := config.Options["offers"]; exists {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing offers option: %w", err)
+			}
+			offers = parsed
+		}
+		perHour := 0.0
+		if value, exists := config.Options["per-hour"]; exists {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing per-hour option: %w", err)
+			}
+			perHour = parsed
+		}
+
+		return NewLocalProvisioner(offers, perHour, config.Mock), nil
+	})
+}
+
+func NewLocalProvisioner(offers int, perHour float64, mock *settings.MockConfigurationSection) *LocalProvisioner {
+	if mock == nil {
+		mock = &settings.MockConfigurationSection{}
+	}
+	provisioner := &LocalProvisioner{
+		mock:    mock,
+		rented:  make(map[string]*Rental),
+		servers: make(map[string]*mock_inference.Server),
+	}
+	for i := 0; i < offers; i++ {
+		provisioner.offers = append(provisioner.offers, &Offer{
+			Id:      fmt.Sprintf("local-%d", i),
+			PerHour: perHour,
+			Models:  mock_inference.NewEngine(mock).Models(),
+			Labels:  map[string]string{"provisioner": ProvisionerLocal},
+		})
+	}
+
+	return provisioner
+}
+
+// ListOffers returns boxes, which are not rented
+func (p *LocalProvisioner) ListOffers() ([]*Offer, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	offers := make([]*Offer, 0, len(p.offers))
+	for _, offer := range p.offers {
+		if _, rented := p.rented[offer.Id]; !rented {
+			offers = append(offers, offer)
+		}
+	}
+
+	return offers, nil
+}
+
+func (p *LocalProvisioner) Rent(offer *Offer) (*Rental, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, rented := p.rented[offer.Id]; rented {
+		return nil, ErrOfferTaken
+	}
+	rental := &Rental{Id: offer.Id, Offer: offer}
+	p.rented[offer.Id] = rental
+
+	return rental, nil
+}
+
+func (p *LocalProvisioner) Start(rental *Rental) (*InferenceNode, error) {
+	server := mock_inference.StartServer(p.mock)
+
+	p.lock.Lock()
+	p.servers[rental.Id] = server
+	p.lock.Unlock()
+
+	return &InferenceNode{
+		EndpointUrl:           server.CompletionsUrl(),
+		EmbeddingsEndpointUrl: server.EmbeddingsUrl(),
+		MaxRequests:           1,
+		MaxBatchSize:          max(p.mock.MaxBatchSize, 1),
+		JobTypes:              []JobType{JT_Completion, JT_Embeddings},
+		Protocol:              engines.ProtocolOpenAI,
+		Labels:                rental.Offer.Labels,
+	}, nil
+}
+
+func (p *LocalProvisioner) Destroy(rental *Rental) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if server, exists := p.servers[rental.Id]; exists {
+		server.Close()
+		delete(p.servers, rental.Id)
+	}
+	delete(p.rented, rental.Id)
+
+	return nil
+}
+
+// Running is the number of mock engines started and not destroyed yet
+func (p *LocalProvisioner) Running() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.servers)
+}
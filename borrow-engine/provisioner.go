@@ -0,0 +1,83 @@
+package borrow_engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/settings"
+	"sort"
+	"sync"
+)
+
+var ErrAutodetectionFailed = errors.New("node failed to run completion and embeddings")
+
+// Offer is a box provisioner can rent
+type Offer struct {
+	Id      string
+	PerHour float64
+	Models  []string // models the box is going to serve, empty if it's unknown
+	Labels  map[string]string
+}
+
+// Rental is a rented box, it's paid for until it's destroyed
+type Rental struct {
+	Id    string
+	Offer *Offer
+}
+
+// Provisioner rents boxes from a cloud, and starts inference engines on them
+type Provisioner interface {
+	// ListOffers returns boxes available for rent
+	ListOffers() ([]*Offer, error)
+	// Rent takes the box, it's not running an engine yet
+	Rent(offer *Offer) (*Rental, error)
+	// Start boots the engine on the box, node is added to the engine once autodetection passes
+	Start(rental *Rental) (*InferenceNode, error)
+	// Destroy stops the engine and releases the box
+	Destroy(rental *Rental) error
+}
+
+// ProvisionerFactory makes a provisioner from autoscaling section of the config
+type ProvisionerFactory func(config *settings.AutoscalingConfigurationSection) (Provisioner, error)
+
+var provisioners = make(map[string]ProvisionerFactory)
+var provisionersLock = sync.RWMutex{}
+
+// RegisterProvisioner makes provisioner available by the name used in autoscaling config,
+// it panics if called twice with the same name, or if factory is nil
+func RegisterProvisioner(name string, factory ProvisionerFactory) {
+	provisionersLock.Lock()
+	defer provisionersLock.Unlock()
+
+	if factory == nil {
+		panic("borrow_engine: RegisterProvisioner factory is nil")
+	}
+	if _, exists := provisioners[name]; exists {
+		panic("borrow_engine: RegisterProvisioner called twice for provisioner " + name)
+	}
+
+	provisioners[name] = factory
+}
+
+func NewProvisioner(config *settings.AutoscalingConfigurationSection) (Provisioner, error) {
+	provisionersLock.RLock()
+	factory, exists := provisioners[config.Provisioner]
+	provisionersLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown provisioner %s, known are: %v", config.Provisioner, listProvisioners())
+	}
+
+	return factory(config)
+}
+
+func listProvisioners() []string {
+	provisionersLock.RLock()
+	defer provisionersLock.RUnlock()
+
+	names := make([]string, 0, len(provisioners))
+	for name := range provisioners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,393 @@
+package borrow_engine
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/d0rc/agent-os/settings"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SimulatedComputeFunction returns the time batch would take on the node, instead of running it
+type SimulatedComputeFunction map[JobType]func(node *InferenceNode, jobs []*ComputeJob) (time.Duration, error)
+
+// LinearLatency makes simulated compute function, which takes perBatch and perJob time for every job of the batch
+func LinearLatency(perBatch, perJob time.Duration) func(*InferenceNode, []*ComputeJob) (time.Duration, error) {
+	return func(_ *InferenceNode, jobs []*ComputeJob) (time.Duration, error) {
+		return perBatch + time.Duration(len(jobs))*perJob, nil
+	}
+}
+
+type SimulationSettings struct {
+	Nodes               []*InferenceNode // nodes are updated by the simulation, so they can't be reused
+	Compute             SimulatedComputeFunction
+	StarvationThreshold time.Duration // jobs waiting longer are starving, default is 30 seconds
+	Fallbacks           []settings.FallbackConfigurationSection
+	Budgets             []settings.BudgetConfigurationSection
+	Scheduling          settings.SchedulingConfigurationSection
+	Routing             []settings.RoutingConfigurationSection
+}
+
+const defaultStarvationThreshold = 30 * time.Second
+
+// simulationStart is the virtual time simulations start at, so reports don't depend on the wall clock
+var simulationStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxIdleTime is the time without running jobs, after which simulation stops, jobs left in the buffer
+// can't be run by any of the nodes, it's long enough for nodes to recover and jobs to fall back
+func maxIdleTime(fallbacks []settings.FallbackConfigurationSection) time.Duration {
+	idleTime := max(nodeFailureBackoff, circuitBreakerCooldown, defaultFallbackWait)
+	for _, fallback := range fallbacks {
+		idleTime = max(idleTime, time.Duration(fallback.Wait)*time.Second)
+	}
+
+	return idleTime + 2*schedulingInterval
+}
+
+// Percentiles of a time distribution
+type Percentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+type SimulationReport struct {
+	Duration        time.Duration // virtual time from the start till the last batch finished
+	JobsCompleted   int
+	JobsUnscheduled int // jobs left in the buffer, when simulation stopped
+	JobsFellBack    int // jobs run with a fallback model
+	JobsRejected    int // jobs not admitted, because of budgets or target waits
+	BatchesRun      int
+	BatchesFailed   int
+	Latency         Percentiles // from job's arrival till its batch finished
+	Wait            Percentiles // from job's arrival till it was sent to a node
+	PriorityLatency map[JobPriority]Percentiles
+	ProcessWait     map[string]time.Duration // mean wait of process' jobs
+	// Fairness is Jain's index of processes' mean waits, 1 means all processes wait equally,
+	// 1/n means one of n processes takes all the waiting
+	Fairness        float64
+	Utilization     float64            // share of nodes' request slots busy running batches
+	NodeUtilization map[string]float64 // by node's endpoint
+	StarvedJobs     int                // jobs waiting longer than the starvation threshold, or never run
+	MaxWait         time.Duration
+	Cost            float64            // spend of all nodes, which have costs set
+	ProcessCost     map[string]float64 // spend by process
+}
+
+type simulatedJob struct {
+	priority     JobPriority
+	process      string
+	arrivedAt    time.Time
+	dispatchedAt time.Time
+	completedAt  time.Time
+	fellBack     bool
+	rejected     bool
+}
+
+type simulationEvent struct {
+	at  time.Time
+	seq int // keeps events happening at the same time in the order they were scheduled
+	run func()
+}
+
+type simulationEvents []*simulationEvent
+
+func (e simulationEvents) Len() int { return len(e) }
+func (e simulationEvents) Less(i, j int) bool {
+	if e[i].at.Equal(e[j].at) {
+		return e[i].seq < e[j].seq
+	}
+	return e[i].at.Before(e[j].at)
+}
+func (e simulationEvents) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *simulationEvents) Push(x interface{}) { *e = append(*e, x.(*simulationEvent)) }
+func (e *simulationEvents) Pop() interface{} {
+	old := *e
+	event := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return event
+}
+
+type simulation struct {
+	ie             *InferenceEngine
+	clock          *VirtualClock
+	settings       *SimulationSettings
+	events         simulationEvents
+	seq            int
+	jobs           map[string]*simulatedJob
+	order          []string // job ids in the order of arrival
+	nodeBusy       []time.Duration
+	batchesRunning int
+	batchesRun     int
+	// batches, which are going to succeed, and jobs, which haven't arrived yet,
+	// the simulation makes progress while there are some of them
+	batchesSucceeding int
+	arrivalsPending   int
+	batchesFailed     int
+	lastFinishedAt    time.Time
+}
+
+// Simulate replays the trace on the scheduler with virtual clock, batches take the time simulated
+// compute function returns, so results only depend on the trace, nodes and compute function
+func Simulate(trace []*TraceRecord, settings *SimulationSettings) *SimulationReport {
+	clock := NewVirtualClock(simulationStart)
+	sim := &simulation{
+		ie: NewInferenceEngine(nil, &InferenceEngineSettings{
+			Clock:      clock,
+			Fallbacks:  settings.Fallbacks,
+			Budgets:    settings.Budgets,
+			Scheduling: settings.Scheduling,
+			Routing:    settings.Routing,
+		}),
+		clock:          clock,
+		settings:       settings,
+		jobs:           make(map[string]*simulatedJob),
+		nodeBusy:       make([]time.Duration, len(settings.Nodes)),
+		lastFinishedAt: simulationStart,
+	}
+	// failed jobs are back in the buffer right away
+	sim.ie.requeue = sim.ie.bufferJobs
+	for _, node := range settings.Nodes {
+		sim.ie.addNode(node)
+	}
+	sim.arrivalsPending = len(trace)
+	for _, record := range trace {
+		record := record
+		sim.schedule(simulationStart.Add(record.At), func() {
+			sim.arrive(record)
+		})
+	}
+
+	tickPending := false
+	idleTicks := 0
+	maxIdleTicks := int(maxIdleTime(settings.Fallbacks) / schedulingInterval)
+	for len(sim.events) > 0 {
+		event := heap.Pop(&sim.events).(*simulationEvent)
+		clock.Set(event.at)
+		if event.run != nil {
+			event.run()
+		}
+
+		sim.ie.scheduleJobs(sim.dispatch)
+
+		isTick := event.run == nil
+		if isTick {
+			tickPending = false
+			// failing batches are no progress, nodes which never recover would make simulation endless
+			if sim.batchesSucceeding == 0 && sim.arrivalsPending == 0 {
+				idleTicks++
+			} else {
+				idleTicks = 0
+			}
+		}
+		if idleTicks > maxIdleTicks {
+			break
+		}
+
+		// engine re-checks the buffer on timer, so jobs, which wait for a batch
+		// to fill up, or for a node to recover, are eventually sent
+		if !tickPending && countMapValueLens(sim.ie.jobsBuffer, &sim.ie.jobsBufferLock) > 0 {
+			tickPending = true
+			sim.schedule(clock.Now().Add(schedulingInterval), nil)
+		}
+	}
+
+	return sim.report()
+}
+
+func (sim *simulation) schedule(at time.Time, run func()) {
+	sim.seq++
+	heap.Push(&sim.events, &simulationEvent{at: at, seq: sim.seq, run: run})
+}
+
+func (sim *simulation) arrive(record *TraceRecord) {
+	sim.arrivalsPending--
+	job := record.job()
+	job.receivedAt = sim.clock.Now()
+	if _, exists := sim.jobs[job.JobId]; !exists {
+		sim.order = append(sim.order, job.JobId)
+	}
+	sim.jobs[job.JobId] = &simulatedJob{
+		priority:  job.Priority,
+		process:   job.Process,
+		arrivedAt: job.receivedAt,
+	}
+	if !sim.ie.admitJob(job) {
+		sim.jobs[job.JobId].rejected = true
+		return
+	}
+	sim.ie.bufferJobs([]*ComputeJob{job})
+}
+
+func (sim *simulation) dispatch(nodeIdx int, jobs []*ComputeJob) {
+	ts := sim.clock.Now()
+	for _, job := range jobs {
+		sim.jobs[job.JobId].dispatchedAt = ts
+		sim.jobs[job.JobId].fellBack = job.requestedModel != ""
+	}
+
+	var duration time.Duration
+	var err error
+	if compute, exists := sim.settings.Compute[jobs[0].JobType]; exists {
+		duration, err = compute(sim.ie.Nodes[nodeIdx], jobs)
+	}
+
+	sim.batchesRunning++
+	sim.batchesRun++
+	if err == nil {
+		sim.batchesSucceeding++
+	}
+	sim.nodeBusy[nodeIdx] += duration
+	sim.schedule(ts.Add(duration), func() {
+		sim.batchesRunning--
+		sim.lastFinishedAt = sim.clock.Now()
+		if err != nil {
+			sim.batchesFailed++
+			sim.ie.batchFailed(nodeIdx, jobs, ts, err)
+			return
+		}
+
+		sim.batchesSucceeding--
+		sim.ie.batchDone(nodeIdx, jobs, ts)
+		for _, job := range jobs {
+			sim.jobs[job.JobId].completedAt = sim.clock.Now()
+		}
+	})
+}
+
+func (sim *simulation) report() *SimulationReport {
+	threshold := sim.settings.StarvationThreshold
+	if threshold == 0 {
+		threshold = defaultStarvationThreshold
+	}
+
+	report := &SimulationReport{
+		Duration:        sim.lastFinishedAt.Sub(simulationStart),
+		BatchesRun:      sim.batchesRun,
+		BatchesFailed:   sim.batchesFailed,
+		PriorityLatency: make(map[JobPriority]Percentiles),
+		ProcessWait:     make(map[string]time.Duration),
+		NodeUtilization: make(map[string]float64),
+		Cost:            sim.ie.TotalCost,
+		ProcessCost:     sim.ie.ProcessesTotalCost,
+	}
+
+	var latencies, waits []time.Duration
+	priorityLatencies := make(map[JobPriority][]time.Duration)
+	processWaits := make(map[string][]time.Duration)
+	for _, id := range sim.order {
+		job := sim.jobs[id]
+		if job.rejected {
+			report.JobsRejected++
+			continue
+		}
+		if job.completedAt.IsZero() {
+			report.JobsUnscheduled++
+			report.StarvedJobs++
+			continue
+		}
+
+		report.JobsCompleted++
+		if job.fellBack {
+			report.JobsFellBack++
+		}
+		latency := job.completedAt.Sub(job.arrivedAt)
+		wait := job.dispatchedAt.Sub(job.arrivedAt)
+		latencies = append(latencies, latency)
+		waits = append(waits, wait)
+		priorityLatencies[job.priority] = append(priorityLatencies[job.priority], latency)
+		processWaits[job.process] = append(processWaits[job.process], wait)
+		if wait > threshold {
+			report.StarvedJobs++
+		}
+	}
+
+	report.Latency = percentiles(latencies)
+	report.Wait = percentiles(waits)
+	report.MaxWait = report.Wait.Max
+	for priority, values := range priorityLatencies {
+		report.PriorityLatency[priority] = percentiles(values)
+	}
+
+	processes := make([]string, 0, len(processWaits))
+	for process := range processWaits {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	sum, sumOfSquares := 0.0, 0.0
+	for _, process := range processes {
+		total := time.Duration(0)
+		for _, wait := range processWaits[process] {
+			total += wait
+		}
+		mean := total / time.Duration(len(processWaits[process]))
+		report.ProcessWait[process] = mean
+		sum += mean.Seconds()
+		sumOfSquares += mean.Seconds() * mean.Seconds()
+	}
+	report.Fairness = 1
+	if sumOfSquares > 0 {
+		report.Fairness = sum * sum / (float64(len(processes)) * sumOfSquares)
+	}
+
+	busy, capacity := 0.0, 0.0
+	for idx, node := range sim.ie.Nodes {
+		nodeCapacity := report.Duration.Seconds() * float64(max(node.MaxRequests, 1))
+		if nodeCapacity > 0 {
+			report.NodeUtilization[node.EndpointUrl] = sim.nodeBusy[idx].Seconds() / nodeCapacity
+		}
+		busy += sim.nodeBusy[idx].Seconds()
+		capacity += nodeCapacity
+	}
+	if capacity > 0 {
+		report.Utilization = busy / capacity
+	}
+
+	return report
+}
+
+// percentiles uses nearest-rank method
+func percentiles(values []time.Duration) Percentiles {
+	if len(values) == 0 {
+		return Percentiles{}
+	}
+
+	sorted := append([]time.Duration{}, values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	rank := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+
+	return Percentiles{
+		P50: rank(0.5),
+		P90: rank(0.9),
+		P99: rank(0.99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+func (p Percentiles) String() string {
+	return fmt.Sprintf("p50 %v, p90 %v, p99 %v, max %v", p.P50, p.P90, p.P99, p.Max)
+}
+
+func (r *SimulationReport) String() string {
+	sb := &strings.Builder{}
+	_, _ = fmt.Fprintf(sb, "duration: %v, jobs completed: %d, unscheduled: %d, rejected: %d, fell back: %d, batches: %d, failed: %d\n",
+		r.Duration, r.JobsCompleted, r.JobsUnscheduled, r.JobsRejected, r.JobsFellBack, r.BatchesRun, r.BatchesFailed)
+	_, _ = fmt.Fprintf(sb, "latency: %v\n", r.Latency)
+	_, _ = fmt.Fprintf(sb, "wait: %v\n", r.Wait)
+	for priority := PRIO_System; priority <= PRIO_Background; priority++ {
+		if latency, exists := r.PriorityLatency[priority]; exists {
+			_, _ = fmt.Fprintf(sb, "priority %d latency: %v\n", priority, latency)
+		}
+	}
+	_, _ = fmt.Fprintf(sb, "fairness: %.3f, utilization: %.1f%%, starved jobs: %d, max wait: %v, spend: %.2f\n",
+		r.Fairness, r.Utilization*100, r.StarvedJobs, r.MaxWait, r.Cost)
+
+	return sb.String()
+}
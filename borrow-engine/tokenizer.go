@@ -0,0 +1,25 @@
+package borrow_engine
+
+import (
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/tokenizers"
+)
+
+// GetTokenizer returns tokenizer of the first local node serving the model, if there
+// are no such nodes, tokenizer registered for the model mask or GPT-2 one is returned
+func (ie *InferenceEngine) GetTokenizer(model string) tokenizers.Tokenizer {
+	for _, node := range ie.Nodes {
+		if node.IsFederationPeer() || node.RemoteEngine == nil || node.RemoteEngine.CompletionFailed ||
+			!node.RemoteEngine.ServesModel(model) {
+			continue
+		}
+
+		return node.RemoteEngine.GetTokenizer(model)
+	}
+
+	if tokenizer := engines.GetTokenizer(model); tokenizer != nil {
+		return tokenizer
+	}
+
+	return tokenizers.GPT2()
+}
@@ -0,0 +1,106 @@
+package borrow_engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"sort"
+	"time"
+)
+
+// TraceRecord is a job received by the engine, traces are written
+// as JSON lines to InferenceEngineSettings.TraceWriter and replayed by Simulate
+type TraceRecord struct {
+	At            time.Duration           `json:"at"` // since the engine was created, in nanoseconds
+	JobId         string                  `json:"job-id"`
+	JobType       JobType                 `json:"job-type"`
+	Priority      JobPriority             `json:"priority"`
+	Process       string                  `json:"process"`
+	Model         string                  `json:"model,omitempty"`
+	ContextTokens int                     `json:"context-tokens,omitempty"`
+	Sampling      *engines.SamplingParams `json:"sampling,omitempty"`
+	RequireLabels map[string]string       `json:"require-labels,omitempty"` // of the request, routing policies are applied on replay
+	PreferLabels  map[string]string       `json:"prefer-labels,omitempty"`
+}
+
+func (ie *InferenceEngine) recordJob(job *ComputeJob) {
+	record := &TraceRecord{
+		At:       job.receivedAt.Sub(ie.startedAt),
+		JobId:    job.JobId,
+		JobType:  job.JobType,
+		Priority: job.Priority,
+		Process:  job.Process,
+	}
+	if job.GenerationSettings != nil {
+		sampling := job.GenerationSettings.SamplingParams
+		record.Model = job.GenerationSettings.Model
+		record.ContextTokens = job.GenerationSettings.ContextTokens
+		record.Sampling = &sampling
+		record.RequireLabels = job.GenerationSettings.RequireLabels
+		record.PreferLabels = job.GenerationSettings.PreferLabels
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		zlog.Error().Err(err).Str("job", job.JobId).Msg("error marshaling job trace record")
+		return
+	}
+
+	ie.traceLock.Lock()
+	defer ie.traceLock.Unlock()
+	if _, err = ie.settings.TraceWriter.Write(append(data, '\n')); err != nil {
+		zlog.Error().Err(err).Str("job", job.JobId).Msg("error writing job trace record")
+	}
+}
+
+// ReadTrace reads JSON lines trace, records are sorted by the time they were received
+func ReadTrace(r io.Reader) ([]*TraceRecord, error) {
+	trace := make([]*TraceRecord, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &TraceRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("error parsing trace record at line %d: %w", line, err)
+		}
+		trace = append(trace, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(trace, func(i, j int) bool {
+		return trace[i].At < trace[j].At
+	})
+
+	return trace, nil
+}
+
+// job makes a job to replay, it has no result channels
+func (r *TraceRecord) job() *ComputeJob {
+	job := &ComputeJob{
+		JobId:    r.JobId,
+		JobType:  r.JobType,
+		Priority: r.Priority,
+		Process:  r.Process,
+	}
+	if r.Model != "" || r.ContextTokens != 0 || r.Sampling != nil || r.RequireLabels != nil || r.PreferLabels != nil {
+		job.GenerationSettings = &engines.GenerationSettings{
+			Model:         r.Model,
+			ContextTokens: r.ContextTokens,
+			RequireLabels: r.RequireLabels,
+			PreferLabels:  r.PreferLabels,
+		}
+		if r.Sampling != nil {
+			job.GenerationSettings.SamplingParams = *r.Sampling
+		}
+	}
+
+	return job
+}
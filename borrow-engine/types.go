@@ -8,10 +8,11 @@ import (
 
 type JobType int
 
+// JT_Completion and JT_Embeddings are built in, more types are declared with RegisterJobType
 const (
-	JT_Completion JobType = iota
-	JT_Embeddings
-	JT_NotAJob
+	JT_NotAJob    JobType = -1
+	JT_Completion JobType = 0
+	JT_Embeddings JobType = 1
 )
 
 type JobPriority int
@@ -26,6 +27,7 @@ const (
 type ComputeResult struct {
 	CompletionChannel chan *engines.Message
 	EmbeddingChannel  chan *vectors.Vector
+	ErrorChannel      chan error // receives errors, which can't be fixed by retrying the job, if set
 }
 
 type ComputeJob struct {
@@ -36,6 +38,14 @@ type ComputeJob struct {
 	receivedAt         time.Time
 	GenerationSettings *engines.GenerationSettings
 	ComputeResult      *ComputeResult
+	Usage              *engines.StatisticsInfo // set by compute function for completions
+	runAlone           bool                    // job's batch failed, so it has to be retried without others
+	fallbackChain      []string                // models left to switch to, if job's model has no healthy node
+	fallbackWait       time.Duration
+	modelSince         time.Time // when job started to wait for its current model
+	requestedModel     string    // model mask job was sent with, set if it was switched to a fallback
+	requireLabels      map[string]string
+	preferLabels       map[string]string
 }
 
 type ComputeFunction map[JobType]func(*InferenceNode, []*ComputeJob) ([]*ComputeJob, error)
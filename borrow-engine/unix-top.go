@@ -42,6 +42,12 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 		ie.TotalRequestsProcessed,
 		ie.TotalTimeConsumed,
 		ie.TotalTimeIdle)
+	topLines = topLines + fmt.Sprintf("Total tokens (prompt/generated): %s/%s\n",
+		humanize.SIWithDigits(float64(ie.TotalPromptTokens), 2, "t"),
+		humanize.SIWithDigits(float64(ie.TotalTokensGenerated), 2, "t"))
+	if ie.TotalCost > 0 {
+		topLines = topLines + fmt.Sprintf("Total spend: %.2f%s\n", ie.TotalCost, ie.tenantsSpendString(lock))
+	}
 	topLines = topLines + fmt.Sprintf("Total jobs in buffer: %d(+%d), Total time in scheduler: %s, Uptime: %s\n",
 		countMapValueLens(jobsBuffer, lock),
 		len(ie.IncomingJobs),
@@ -51,20 +57,23 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 	result.topLines = topLines
 	tw := tablewriter.NewWriter(stringBuilder)
 
-	computeEnginesHeaders := []string{"Endpoint", "Compute State", "Max (reqs/batch)", "Reqs/Jobs", "TimeConsumed", "TimeIdle", "T.Waisted", "Failed(R/J)"}
+	computeEnginesHeaders := []string{"Endpoint", "Compute State", "Max (reqs/batch)", "Reqs/Jobs", "TimeConsumed", "TimeIdle", "T.Waisted", "Failed(R/J)", "Tokens(P/G)", "Tokens/s", "Spend"}
 	tw.SetHeader(computeEnginesHeaders)
 	result.computeEngines = append(result.computeEngines, computeEnginesHeaders)
 
 	for _, node := range ie.Nodes {
 		computeEnginesLine := []string{
 			shoLastNRunes(node.EndpointUrl, 35),
-			fmt.Sprintf("%v", getNodeState(termUi, node.RequestsRunning)),
+			fmt.Sprintf("%v", getNodeState(termUi, node.RequestsRunning, node.Retired)),
 			fmt.Sprintf("%d/%d", node.MaxRequests, node.MaxBatchSize),
 			fmt.Sprintf("%d/%d", node.TotalRequestsProcessed, node.TotalJobsProcessed),
 			fmt.Sprintf("%s", node.TotalTimeConsumed),
 			fmt.Sprintf("%s", node.TotalTimeIdle),
 			fmt.Sprintf("%s", node.TotalTimeWaisted),
 			fmt.Sprintf("%d/%d", node.TotalRequestsFailed, node.TotalJobsFailed),
+			fmt.Sprintf("%d/%d", node.TotalPromptTokens, node.TotalTokensGenerated),
+			fmt.Sprintf("%4.1f", node.tokensPerSecond()),
+			fmt.Sprintf("%.2f", node.TotalCost),
 		}
 		tw.Append(computeEnginesLine)
 		result.computeEngines = append(result.computeEngines, computeEnginesLine)
@@ -73,7 +82,7 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 
 	tw = tablewriter.NewWriter(stringBuilder)
 	processesHeadersLines := make([][]string, 0)
-	processesHeaders := []string{"Process", "TotalJobsProcessed", "TotalTimeConsumed", "AvgWait"}
+	processesHeaders := []string{"Process", "TotalJobsProcessed", "TotalTimeConsumed", "AvgWait", "Tokens(P/G)", "Spend"}
 	tw.SetHeader(processesHeaders)
 	processesHeadersLines = append(processesHeadersLines, processesHeaders)
 	lock.RLock()
@@ -96,23 +105,73 @@ func (ie *InferenceEngine) buildTopString(jobsBuffer map[JobPriority][]*ComputeJ
 	})
 
 	for _, processData := range processInfo {
+		processTokens := ie.ProcessesTotalTokens[processData.Name]
+		if processTokens == nil {
+			processTokens = &TokensUsage{}
+		}
 		processesHeadersLine := []string{
 			processData.Name,
 			fmt.Sprintf("%d", ie.ProcessesTotalJobs[processData.Name]),
 			fmt.Sprintf("%s", ie.ProcessesTotalTimeConsumed[processData.Name]),
 			fmt.Sprintf("%s", fmt.Sprintf("%4.4f", float64(ie.ProcessesTotalTimeWaiting[processData.Name]/time.Millisecond)/float64(ie.ProcessesTotalJobs[processData.Name]))),
+			fmt.Sprintf("%d/%d", processTokens.PromptTokens, processTokens.TokensGenerated),
+			fmt.Sprintf("%.2f", ie.ProcessesTotalCost[processData.Name]),
 		}
 		tw.Append(processesHeadersLine)
 		processesHeadersLines = append(processesHeadersLines, processesHeadersLine)
 	}
-	lock.RUnlock()
 	tw.Render()
 
+	if !termUi && len(ie.ModelsTotalTokens) > 0 {
+		tw = tablewriter.NewWriter(stringBuilder)
+		tw.SetHeader([]string{"Model", "PromptTokens", "TokensGenerated"})
+		models := make([]string, 0, len(ie.ModelsTotalTokens))
+		for model := range ie.ModelsTotalTokens {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			tw.Append([]string{
+				model,
+				fmt.Sprintf("%d", ie.ModelsTotalTokens[model].PromptTokens),
+				fmt.Sprintf("%d", ie.ModelsTotalTokens[model].TokensGenerated),
+			})
+		}
+		tw.Render()
+	}
+	lock.RUnlock()
+
 	result.topString = stringBuilder.String()
 	result.processesLines = processesHeadersLines
 	return result
 }
 
+// tenantsSpendString lists spend of tenants, if there are any
+func (ie *InferenceEngine) tenantsSpendString(lock *sync.RWMutex) string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	tenants := make([]string, 0, len(ie.TenantsTotalCost))
+	for tenant := range ie.TenantsTotalCost {
+		if tenant != "" {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+
+	sb := &strings.Builder{}
+	for idx, tenant := range tenants {
+		if idx == 0 {
+			sb.WriteString(", tenants: ")
+		} else {
+			sb.WriteString(", ")
+		}
+		_, _ = fmt.Fprintf(sb, "%s %.2f", tenant, ie.TenantsTotalCost[tenant])
+	}
+
+	return sb.String()
+}
+
 func makeBrightCyan(ui bool, digits string) string {
 	if !ui {
 		return aurora.BrightCyan(digits).String()
@@ -131,7 +190,10 @@ func shoLastNRunes(url string, i int) string {
 	return fmt.Sprintf("...%s", url[len(url)-i:])
 }
 
-func getNodeState(ui bool, running int) interface{} {
+func getNodeState(ui bool, running int, retired bool) interface{} {
+	if running == 0 && retired {
+		return makeBrightWhite(ui, "retired")
+	}
 	if running == 0 {
 		return makeBrightWhite(ui, "idle")
 	}
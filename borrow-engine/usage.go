@@ -0,0 +1,47 @@
+package borrow_engine
+
+type TokensUsage struct {
+	PromptTokens    uint64
+	TokensGenerated uint64
+}
+
+func (u *TokensUsage) add(promptTokens, tokensGenerated int) {
+	u.PromptTokens += uint64(promptTokens)
+	u.TokensGenerated += uint64(tokensGenerated)
+}
+
+// accountUsage aggregates job's token usage per node, process and model,
+// should be called with jobs buffer lock held, since it updates the maps
+func (ie *InferenceEngine) accountUsage(node *InferenceNode, job *ComputeJob) {
+	if job.Usage == nil {
+		return
+	}
+
+	ie.TotalPromptTokens += uint64(job.Usage.PromptTokens)
+	ie.TotalTokensGenerated += uint64(job.Usage.TokensGenerated)
+	node.TotalPromptTokens += uint64(job.Usage.PromptTokens)
+	node.TotalTokensGenerated += uint64(job.Usage.TokensGenerated)
+
+	if _, exists := ie.ProcessesTotalTokens[job.Process]; !exists {
+		ie.ProcessesTotalTokens[job.Process] = &TokensUsage{}
+	}
+	ie.ProcessesTotalTokens[job.Process].add(job.Usage.PromptTokens, job.Usage.TokensGenerated)
+
+	model := job.Usage.Model
+	if model == "" {
+		model = "unknown"
+	}
+	if _, exists := ie.ModelsTotalTokens[model]; !exists {
+		ie.ModelsTotalTokens[model] = &TokensUsage{}
+	}
+	ie.ModelsTotalTokens[model].add(job.Usage.PromptTokens, job.Usage.TokensGenerated)
+}
+
+// tokensPerSecond is generation speed of the node, over the time it was busy
+func (n *InferenceNode) tokensPerSecond() float64 {
+	if n.TotalTimeConsumed.Seconds() == 0 {
+		return 0
+	}
+
+	return float64(n.TotalTokensGenerated) / n.TotalTimeConsumed.Seconds()
+}
@@ -13,15 +13,22 @@ func countMapValueLens(buffer map[JobPriority][]*ComputeJob, lock *sync.RWMutex)
 	return cnt
 }
 
-func getJobsByType(buffer []*ComputeJob, types []JobType) map[JobType][]*ComputeJob {
-	jobsByType := make(map[JobType][]*ComputeJob)
-	for _, jobType := range types {
-		jobsByType[jobType] = []*ComputeJob{}
+// canBatchWith checks if jobs can share a batch, drivers sending a batch in a single
+// request use generation settings of the first job for all of them
+func canBatchWith(batch []*ComputeJob, job *ComputeJob) bool {
+	if len(batch) > 0 && (batch[0].runAlone || job.runAlone) {
+		return false
 	}
 
-	for _, job := range buffer {
-		jobsByType[job.JobType] = append(jobsByType[job.JobType], job)
+	if len(batch) > 0 {
+		if definition := job.JobType.definition(); definition != nil && definition.CanBatch != nil {
+			return definition.CanBatch(batch, job)
+		}
 	}
 
-	return jobsByType
+	if len(batch) == 0 || batch[0].GenerationSettings == nil || job.GenerationSettings == nil {
+		return true
+	}
+
+	return batch[0].GenerationSettings.BatchSignature() == job.GenerationSettings.BatchSignature()
 }
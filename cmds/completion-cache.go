@@ -0,0 +1,206 @@
+package cmds
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/server"
+	"github.com/d0rc/agent-os/storage"
+	"math"
+	"strings"
+	"time"
+)
+
+type CachePolicy string
+
+const (
+	CachePolicyUse       CachePolicy = "use"        // default, cached choices are returned, new ones are saved
+	CachePolicyBypass    CachePolicy = "bypass"     // cache is neither read, nor written
+	CachePolicyRefresh   CachePolicy = "refresh"    // cached choices are replaced with the new ones
+	CachePolicyReadOnly  CachePolicy = "read-only"  // cached choices are returned, new ones are not saved
+	CachePolicyWriteOnly CachePolicy = "write-only" // cached choices are ignored, new ones are saved
+)
+
+func (p CachePolicy) Validate() error {
+	switch p {
+	case "", CachePolicyUse, CachePolicyBypass, CachePolicyRefresh, CachePolicyReadOnly, CachePolicyWriteOnly:
+		return nil
+	}
+
+	return fmt.Errorf("unknown cache policy: %s", p)
+}
+
+func (p CachePolicy) canRead() bool {
+	return p == "" || p == CachePolicyUse || p == CachePolicyReadOnly
+}
+
+func (p CachePolicy) canWrite() bool {
+	return p == "" || p == CachePolicyUse || p == CachePolicyRefresh || p == CachePolicyWriteOnly
+}
+
+// temperatures are stored as float, so they are compared with some tolerance
+const cacheTemperatureEpsilon = 1e-3
+
+func encodeStopTokens(stopTokens []string) string {
+	if len(stopTokens) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(stopTokens)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// cacheSignature is stored as generation_settings, it's the part of the cache key, which isn't
+// the prompt, grammar and messages are hashed, requests without them keep the old signatures,
+// prompt is the one before truncation, so choices of truncated prompts are kept apart
+func (cr *GetCompletionRequest) cacheSignature() string {
+	parts := make([]string, 0, 4)
+	if signature := cr.SamplingParams.Signature(); signature != "" {
+		parts = append(parts, signature)
+	}
+	if cr.Truncation != "" && cr.Truncation != engines.TruncationReject {
+		parts = append(parts, "truncation="+string(cr.Truncation))
+	}
+	if cr.Grammar != "" {
+		parts = append(parts, "grammar="+storage.GetHash(cr.Grammar))
+	}
+	if len(cr.Messages) > 0 {
+		parts = append(parts, "messages="+storage.GetHash(encodeCacheMessages(cr.Messages)))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// encodeCacheMessages keeps roles and contents only, ids of the messages don't change the choices
+func encodeCacheMessages(messages []engines.Message) string {
+	encoded := strings.Builder{}
+	for idx := range messages {
+		encoded.WriteString(string(messages[idx].Role))
+		encoded.WriteByte(0)
+		encoded.WriteString(messages[idx].Content)
+		encoded.WriteByte(0)
+	}
+
+	return encoded.String()
+}
+
+// cacheRecordMatches checks the parts of the cache key, which can't be checked by the query,
+// records saved before these columns were added have no temperature, so it's unknown which
+// settings they were generated with, and they match nothing
+func (cr *GetCompletionRequest) cacheRecordMatches(record *CompletionCacheRecord) bool {
+	if cr.MaxAge > 0 && time.Since(record.CreatedAt) > time.Duration(cr.MaxAge)*time.Second {
+		return false
+	}
+
+	if !engines.MatchModel(cr.Model, record.Model) {
+		return false
+	}
+
+	if record.Temperature == nil || math.Abs(float64(*record.Temperature-cr.Temperature)) > cacheTemperatureEpsilon {
+		return false
+	}
+
+	stopTokens := ""
+	if record.StopTokens != nil {
+		stopTokens = *record.StopTokens
+	}
+
+	return stopTokens == encodeStopTokens(cr.StopTokens)
+}
+
+func lookupCompletionCache(cr *GetCompletionRequest, ctx *server.Context) ([]CompletionCacheRecord, error) {
+	cachedResponse := make([]CompletionCacheRecord, 0, 1)
+	// sampling parameters, grammar and messages are a part of the cache key, defaults are stored as an empty string
+	err := ctx.Storage.Db.GetStructsSlice("query-llm-cache", &cachedResponse,
+		len(cr.RawPrompt), cr.RawPrompt, cr.cacheSignature())
+	if err != nil {
+		return nil, err
+	}
+
+	matchingRecords := make([]CompletionCacheRecord, 0, len(cachedResponse))
+	for idx := range cachedResponse {
+		if cr.cacheRecordMatches(&cachedResponse[idx]) {
+			matchingRecords = append(matchingRecords, cachedResponse[idx])
+		}
+	}
+
+	return matchingRecords, nil
+}
+
+// dropCompletionCache deletes cached choices, which are going to be replaced
+func dropCompletionCache(cr *GetCompletionRequest, ctx *server.Context) error {
+	records, err := lookupCompletionCache(cr, ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if _, err := ctx.Storage.Db.Exec("delete-llm-cache-record", record.Id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveCompletionCache stores a choice, model is the one which generated it, if it's known,
+// fallbackModel is the mask request was switched to, if it fell back from the requested one
+func saveCompletionCache(cr *GetCompletionRequest, ctx *server.Context, fallbackModel string, model string, choice string, usage *engines.StatisticsInfo, logprobs []engines.TokenLogprob) error {
+	var requestedModel *string
+	if fallbackModel != "" {
+		requestedModel = &cr.Model
+	}
+	if model == "" {
+		model = cr.Model
+		if fallbackModel != "" {
+			model = fallbackModel
+		}
+	}
+
+	_, err := ctx.Storage.Db.Exec("insert-llm-cache-record",
+		model,
+		cr.RawPrompt,
+		len(cr.RawPrompt),
+		time.Now(),
+		cr.cacheSignature(),
+		0,
+		choice,
+		usage.PromptTokens,
+		usage.TokensGenerated,
+		encodeLogprobs(logprobs),
+		cr.Temperature,
+		encodeStopTokens(cr.StopTokens),
+		requestedModel)
+
+	return err
+}
+
+func encodeLogprobs(logprobs []engines.TokenLogprob) []byte {
+	if len(logprobs) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(logprobs)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func decodeLogprobs(data []byte) []engines.TokenLogprob {
+	if len(data) == 0 {
+		return nil
+	}
+
+	logprobs := make([]engines.TokenLogprob, 0)
+	if err := json.Unmarshal(data, &logprobs); err != nil {
+		return nil
+	}
+
+	return logprobs
+}
@@ -0,0 +1,90 @@
+package cmds
+
+import (
+	"github.com/d0rc/agent-os/engines"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheSignatureKeysGrammarAndMessages(t *testing.T) {
+	request := func(grammar string, messages ...string) *GetCompletionRequest {
+		cr := &GetCompletionRequest{
+			RawPrompt:      "prompt",
+			Grammar:        grammar,
+			SamplingParams: engines.SamplingParams{MaxTokens: 16},
+		}
+		for _, content := range messages {
+			cr.Messages = append(cr.Messages, engines.Message{Role: engines.ChatRoleUser, Content: content})
+		}
+
+		return cr
+	}
+
+	// requests without grammar and messages keep signatures of the records saved before
+	if signature := request("").cacheSignature(); signature != "max-tokens=16" {
+		t.Fatalf("unexpected signature without grammar: %s", signature)
+	}
+
+	jsonGrammar := request(`root ::= "{" ws "}"`).cacheSignature()
+	if jsonGrammar != request(`root ::= "{" ws "}"`).cacheSignature() {
+		t.Fatalf("same grammar should hit the same cache records")
+	}
+	if jsonGrammar == request(`root ::= "yes" | "no"`).cacheSignature() {
+		t.Fatalf("different grammars should miss each other's cache records")
+	}
+	if jsonGrammar == request("").cacheSignature() {
+		t.Fatalf("choices generated without grammar shouldn't be returned for grammar")
+	}
+
+	if request("", "hello").cacheSignature() == request("", "goodbye").cacheSignature() {
+		t.Fatalf("different messages should miss each other's cache records")
+	}
+
+	truncated := request("")
+	truncated.Truncation = engines.TruncationMiddle
+	if truncated.cacheSignature() == request("").cacheSignature() {
+		t.Fatalf("choices of truncated prompts shouldn't be returned to requests rejecting long prompts")
+	}
+	rejecting := request("")
+	rejecting.Truncation = engines.TruncationReject
+	if rejecting.cacheSignature() != request("").cacheSignature() {
+		t.Fatalf("reject is the default truncation strategy")
+	}
+
+	// generation_settings column is varchar(1024)
+	long := request(strings.Repeat("root ::= [a-z]+\n", 1000), strings.Repeat("hello", 1000))
+	if len(long.cacheSignature()) > 1024 {
+		t.Fatalf("signature doesn't fit generation_settings column: %d", len(long.cacheSignature()))
+	}
+}
+
+func TestCacheRecordMatches(t *testing.T) {
+	temperature := float32(0.7)
+	stopTokens := encodeStopTokens([]string{"###"})
+	record := func(modify func(record *CompletionCacheRecord)) *CompletionCacheRecord {
+		record := &CompletionCacheRecord{
+			Model:       "llama2:13b",
+			CreatedAt:   time.Now(),
+			Temperature: &temperature,
+			StopTokens:  &stopTokens,
+		}
+		modify(record)
+		return record
+	}
+	cr := &GetCompletionRequest{Model: "llama2", Temperature: 0.7, StopTokens: []string{"###"}}
+
+	if !cr.cacheRecordMatches(record(func(*CompletionCacheRecord) {})) {
+		t.Fatalf("record with the same settings should match")
+	}
+	for name, modify := range map[string]func(record *CompletionCacheRecord){
+		"legacy":      func(record *CompletionCacheRecord) { record.Temperature = nil },
+		"temperature": func(record *CompletionCacheRecord) { other := float32(0.1); record.Temperature = &other },
+		"stop tokens": func(record *CompletionCacheRecord) { record.StopTokens = nil },
+		"model":       func(record *CompletionCacheRecord) { record.Model = "mistral" },
+	} {
+		if cr.cacheRecordMatches(record(modify)) {
+			t.Fatalf("%s record shouldn't match", name)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package cmds
+
+import (
+	"encoding/json"
+	"fmt"
+	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/server"
+	"github.com/d0rc/agent-os/utils"
+	"strings"
+)
+
+type AcceptanceType string
+
+const (
+	AcceptanceJSON    AcceptanceType = "json"    // choice has JSON in it
+	AcceptanceSchema  AcceptanceType = "schema"  // choice has JSON valid against the schema
+	AcceptanceLogprob AcceptanceType = "logprob" // mean token probability of the choice is high enough
+	AcceptanceVote    AcceptanceType = "vote"    // large enough share of sampled choices agree
+)
+
+const defaultVotes = 5
+const defaultLogprobConfidence = 0.8
+const defaultVoteConfidence = 0.6
+
+// AcceptanceCheck decides if the answer of a cascade tier is good enough
+type AcceptanceCheck struct {
+	Type          AcceptanceType         `json:"type"`
+	Schema        map[string]interface{} `json:"schema,omitempty"`         // JSON schema for `schema` check
+	MinConfidence float64                `json:"min-confidence,omitempty"` // mean token probability for `logprob`, 0.8 by default, agreeing share for `vote`, 0.6 by default
+	Votes         int                    `json:"votes,omitempty"`          // choices sampled for `vote`, 5 by default
+}
+
+func (c *AcceptanceCheck) Validate() error {
+	if c == nil {
+		return fmt.Errorf("cascade needs an acceptance check")
+	}
+
+	switch c.Type {
+	case AcceptanceJSON, AcceptanceLogprob, AcceptanceVote:
+		return nil
+	case AcceptanceSchema:
+		if c.Schema == nil {
+			return fmt.Errorf("schema acceptance check needs a schema")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown acceptance check: %s", c.Type)
+}
+
+func (c *AcceptanceCheck) minConfidence() float64 {
+	if c.MinConfidence > 0 {
+		return c.MinConfidence
+	}
+	if c.Type == AcceptanceVote {
+		return defaultVoteConfidence
+	}
+
+	return defaultLogprobConfidence
+}
+
+// choicesNeeded is the number of choices the check needs to decide
+func (c *AcceptanceCheck) choicesNeeded() int {
+	if c.Type != AcceptanceVote {
+		return 1
+	}
+	if c.Votes > 0 {
+		return c.Votes
+	}
+
+	return defaultVotes
+}
+
+// check returns indexes of the accepted choices and confidence of the best of them
+func (c *AcceptanceCheck) check(response *GetCompletionResponse) ([]int, float64) {
+	accepted := make([]int, 0, len(response.Choices))
+	confidence := 0.0
+
+	switch c.Type {
+	case AcceptanceJSON, AcceptanceSchema:
+		for idx, choice := range response.Choices {
+			if c.checkJSON(choice) == nil {
+				accepted = append(accepted, idx)
+			}
+		}
+		if len(response.Choices) > 0 {
+			confidence = float64(len(accepted)) / float64(len(response.Choices))
+		}
+	case AcceptanceLogprob:
+		for idx := range response.Choices {
+			if idx >= len(response.ChoicesLogprobs) {
+				break
+			}
+			choiceConfidence, ok := engines.SequenceConfidence(response.ChoicesLogprobs[idx])
+			if !ok {
+				continue
+			}
+			confidence = max(confidence, choiceConfidence)
+			if choiceConfidence >= c.minConfidence() {
+				accepted = append(accepted, idx)
+			}
+		}
+	case AcceptanceVote:
+		groups := make(map[string][]int)
+		var winner string
+		for idx, choice := range response.Choices {
+			key := voteKey(choice)
+			groups[key] = append(groups[key], idx)
+			if len(groups[key]) > len(groups[winner]) {
+				winner = key
+			}
+		}
+		if len(response.Choices) > 0 {
+			confidence = float64(len(groups[winner])) / float64(len(response.Choices))
+		}
+		if confidence >= c.minConfidence() {
+			accepted = groups[winner]
+		}
+	}
+
+	return accepted, confidence
+}
+
+func (c *AcceptanceCheck) checkJSON(choice string) error {
+	// ParseJSON doesn't fail on texts without anything looking like JSON, it never calls the parser then
+	parsed := false
+	err := utils.ParseJSON(choice, func(s string) error {
+		var value interface{}
+		if err := json.Unmarshal([]byte(s), &value); err != nil {
+			return err
+		}
+		if c.Type == AcceptanceSchema {
+			if err := utils.ValidateJSONSchema(value, c.Schema); err != nil {
+				return err
+			}
+		}
+		parsed = true
+		return nil
+	})
+	if err == nil && !parsed {
+		return fmt.Errorf("no JSON in the choice")
+	}
+
+	return err
+}
+
+// voteKey makes choices, which differ in formatting only, vote together
+func voteKey(choice string) string {
+	choice = strings.TrimSpace(choice)
+	var value interface{}
+	if json.Unmarshal([]byte(choice), &value) == nil {
+		// keys of the objects are sorted by the encoder
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+
+	return strings.ToLower(strings.Join(strings.Fields(choice), " "))
+}
+
+// onlyChoices keeps only choices with the given indexes
+func (r *GetCompletionResponse) onlyChoices(indexes []int) {
+	choices := make([]string, 0, len(indexes))
+	logprobs := make([][]engines.TokenLogprob, 0, len(indexes))
+	approximate := make([]bool, 0, len(indexes))
+	for _, idx := range indexes {
+		choices = append(choices, r.Choices[idx])
+		if idx < len(r.ChoicesLogprobs) {
+			logprobs = append(logprobs, r.ChoicesLogprobs[idx])
+		}
+		if idx < len(r.Approximate) {
+			approximate = append(approximate, r.Approximate[idx])
+		}
+	}
+
+	r.Choices = choices
+	if r.ChoicesLogprobs != nil {
+		r.ChoicesLogprobs = logprobs
+	}
+	if r.Approximate != nil {
+		r.Approximate = approximate
+	}
+}
+
+// appendChoices adds choices of the other response, starting from the index
+func (r *GetCompletionResponse) appendChoices(other *GetCompletionResponse, from int) {
+	for idx := from; idx < len(other.Choices); idx++ {
+		var logprobs []engines.TokenLogprob
+		if idx < len(other.ChoicesLogprobs) {
+			logprobs = other.ChoicesLogprobs[idx]
+		}
+		r.addChoice(other.Choices[idx], logprobs)
+	}
+}
+
+// completionFunc runs a single completion request of the cascade
+type completionFunc func(cr GetCompletionRequest) (*GetCompletionResponse, error)
+
+// processCompletionCascade asks models of the cascade one by one, starting from the cheapest one, until
+// the answer passes acceptance check, every attempt is a regular completion request, so it's cached,
+// if no model passes the check, answer of the last one is returned
+func processCompletionCascade(cr GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority) (*GetCompletionResponse, error) {
+	return runCompletionCascade(cr, func(cr GetCompletionRequest) (*GetCompletionResponse, error) {
+		return processGetCompletion(cr, ctx, process, priority)
+	})
+}
+
+func runCompletionCascade(cr GetCompletionRequest, complete completionFunc) (*GetCompletionResponse, error) {
+	if err := cr.Acceptance.Validate(); err != nil {
+		return nil, err
+	}
+
+	cascade := &CascadeResult{
+		Attempts: make([]CascadeAttempt, 0, len(cr.Cascade)),
+	}
+	var response *GetCompletionResponse
+	var err error
+	for tier, model := range cr.Cascade {
+		tierRequest := cr
+		tierRequest.Model = model
+		tierRequest.Cascade = nil
+		tierRequest.MinResults = max(cr.MinResults, cr.Acceptance.choicesNeeded())
+		if cr.Acceptance.Type == AcceptanceLogprob {
+			tierRequest.Logprobs = max(cr.Logprobs, 1)
+		}
+
+		tierResponse, tierErr := collectChoices(tierRequest, complete)
+		attempt := CascadeAttempt{Model: model}
+		if tierErr != nil {
+			err = tierErr
+			attempt.Error = tierErr.Error()
+			cascade.Attempts = append(cascade.Attempts, attempt)
+			continue
+		}
+
+		response = tierResponse
+		accepted, confidence := cr.Acceptance.check(response)
+		attempt.Confidence = confidence
+		attempt.Accepted = len(accepted) > 0
+		attempt.Usage = response.Usage
+		cascade.Attempts = append(cascade.Attempts, attempt)
+		cascade.Tier = tier
+		cascade.Model = model
+
+		if attempt.Accepted {
+			cascade.Accepted = true
+			response.onlyChoices(accepted)
+			break
+		}
+	}
+
+	if response == nil {
+		return nil, fmt.Errorf("all models of the cascade failed, last error: %w", err)
+	}
+
+	response.Cascade = cascade
+	return response.finalize(&cr), nil
+}
+
+// collectChoices runs completion request until it has min-results choices, a single request
+// generates one choice at most, the rest comes from the cache
+func collectChoices(cr GetCompletionRequest, complete completionFunc) (*GetCompletionResponse, error) {
+	response, err := complete(cr)
+	for attempts := 1; err == nil && len(response.Choices) < cr.MinResults && attempts < cr.MinResults; attempts++ {
+		var next *GetCompletionResponse
+		next, err = complete(cr)
+		if err != nil || len(next.Choices) == 0 {
+			break
+		}
+
+		switch {
+		case cr.CachePolicy.canRead() && cr.CachePolicy.canWrite():
+			// cache has all the choices generated so far
+			response = next
+		case cr.CachePolicy.canRead():
+			// cached choices are repeated, the new one is the last
+			response.appendChoices(next, len(next.Choices)-1)
+		default:
+			response.appendChoices(next, 0)
+		}
+	}
+
+	return response, err
+}
@@ -0,0 +1,264 @@
+package cmds
+
+import (
+	"fmt"
+	"github.com/d0rc/agent-os/engines"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func responseOf(choices ...string) *GetCompletionResponse {
+	response := &GetCompletionResponse{}
+	for _, choice := range choices {
+		response.addChoice(choice, nil)
+	}
+
+	return response
+}
+
+// logprobsOf makes a choice of a single token with the given probability
+func logprobsOf(probability float64) []engines.TokenLogprob {
+	return []engines.TokenLogprob{{Token: "token", Logprob: math.Log(probability)}}
+}
+
+func TestAcceptanceChecks(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"answer"},
+	}
+	withLogprobs := responseOf("unsure", "sure", "no logprobs")
+	withLogprobs.ChoicesLogprobs = [][]engines.TokenLogprob{logprobsOf(0.5), logprobsOf(0.9), nil}
+
+	tests := []struct {
+		name       string
+		check      AcceptanceCheck
+		response   *GetCompletionResponse
+		accepted   []int
+		confidence float64
+	}{
+		{
+			name:       "json",
+			check:      AcceptanceCheck{Type: AcceptanceJSON},
+			response:   responseOf(`{"answer": 4}`, "no JSON here", "answer: [1, 2]"),
+			accepted:   []int{0, 2},
+			confidence: 2.0 / 3,
+		},
+		{
+			name:       "schema",
+			check:      AcceptanceCheck{Type: AcceptanceSchema, Schema: schema},
+			response:   responseOf(`{"answer": 4}`, `{"result": 4}`),
+			accepted:   []int{0},
+			confidence: 0.5,
+		},
+		{
+			name:       "logprob",
+			check:      AcceptanceCheck{Type: AcceptanceLogprob},
+			response:   withLogprobs,
+			accepted:   []int{1},
+			confidence: 0.9,
+		},
+		{
+			name:       "logprob below min confidence",
+			check:      AcceptanceCheck{Type: AcceptanceLogprob, MinConfidence: 0.95},
+			response:   withLogprobs,
+			accepted:   []int{},
+			confidence: 0.9,
+		},
+		{
+			name:       "vote",
+			check:      AcceptanceCheck{Type: AcceptanceVote},
+			response:   responseOf("Paris", " paris ", "London", "PARIS"),
+			accepted:   []int{0, 1, 3},
+			confidence: 0.75,
+		},
+		{
+			name:       "vote without majority",
+			check:      AcceptanceCheck{Type: AcceptanceVote},
+			response:   responseOf("Paris", "London", "Paris", "London"),
+			accepted:   []int{},
+			confidence: 0.5,
+		},
+		{
+			name:       "vote tie goes to the answer reaching the count first",
+			check:      AcceptanceCheck{Type: AcceptanceVote, MinConfidence: 0.5},
+			response:   responseOf("London", "Paris", "Paris", "London"),
+			accepted:   []int{1, 2},
+			confidence: 0.5,
+		},
+		{
+			name:       "no choices",
+			check:      AcceptanceCheck{Type: AcceptanceJSON},
+			response:   responseOf(),
+			accepted:   []int{},
+			confidence: 0,
+		},
+	}
+
+	for _, test := range tests {
+		accepted, confidence := test.check.check(test.response)
+		if !reflect.DeepEqual(accepted, test.accepted) {
+			t.Errorf("%s: expected %v to be accepted, got %v", test.name, test.accepted, accepted)
+		}
+		if math.Abs(confidence-test.confidence) > 1e-9 {
+			t.Errorf("%s: expected confidence of %f, got %f", test.name, test.confidence, confidence)
+		}
+	}
+}
+
+func TestVoteKey(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"Paris", "  paris\n", true},
+		{"the  answer is\t4", "The answer is 4", true},
+		{`{"b": 2, "a": 1}`, `{"a":1,"b":2}`, true},
+		{`{"a": 1}`, `{"a": 2}`, false},
+		{"Paris", "London", false},
+	}
+
+	for _, test := range tests {
+		if (voteKey(test.a) == voteKey(test.b)) != test.equal {
+			t.Errorf("%q and %q should vote together: %v", test.a, test.b, test.equal)
+		}
+	}
+}
+
+func TestOnlyAndAppendChoices(t *testing.T) {
+	response := responseOf("a", "b", "c")
+	response.ChoicesLogprobs[2] = logprobsOf(0.5)
+	response.Approximate[2] = true
+
+	response.onlyChoices([]int{0, 2})
+	if !reflect.DeepEqual(response.Choices, []string{"a", "c"}) ||
+		len(response.ChoicesLogprobs) != 2 || response.ChoicesLogprobs[1] == nil ||
+		!reflect.DeepEqual(response.Approximate, []bool{false, true}) {
+		t.Fatalf("choices are kept without their logprobs and flags: %+v", response)
+	}
+
+	other := responseOf("x", "y")
+	other.ChoicesLogprobs[1] = logprobsOf(0.9)
+	response.appendChoices(other, 1)
+	if !reflect.DeepEqual(response.Choices, []string{"a", "c", "y"}) || response.ChoicesLogprobs[2] == nil ||
+		response.Approximate[2] {
+		t.Fatalf("choices starting from the index should be appended: %+v", response)
+	}
+}
+
+// completionStandIn answers with choices of the models, one new choice per request,
+// just like a completion request does, min-results requests of each model are recorded
+type completionStandIn struct {
+	choices  map[string][]string
+	failing  map[string]bool
+	cached   map[string]int
+	requests map[string][]int
+}
+
+func (s *completionStandIn) complete(cr GetCompletionRequest) (*GetCompletionResponse, error) {
+	if s.requests == nil {
+		s.requests = make(map[string][]int)
+		s.cached = make(map[string]int)
+	}
+	s.requests[cr.Model] = append(s.requests[cr.Model], cr.MinResults)
+	if s.failing[cr.Model] {
+		return nil, fmt.Errorf("no node for %s", cr.Model)
+	}
+
+	choices := s.choices[cr.Model]
+	generated := min(s.cached[cr.Model]+1, len(choices))
+	if cr.CachePolicy.canWrite() {
+		s.cached[cr.Model] = generated
+	}
+	response := responseOf(choices[:generated]...)
+	if cr.CachePolicy == CachePolicyBypass {
+		response = responseOf(choices[generated-1])
+	}
+	response.Usage = &CompletionUsage{Model: cr.Model}
+
+	return response, nil
+}
+
+func TestCollectChoices(t *testing.T) {
+	for _, policy := range []CachePolicy{CachePolicyUse, CachePolicyBypass} {
+		standIn := &completionStandIn{choices: map[string][]string{"small": {"a", "b", "c", "d"}}}
+		response, err := collectChoices(GetCompletionRequest{Model: "small", MinResults: 3, CachePolicy: policy}, standIn.complete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Choices) != 3 || len(standIn.requests["small"]) != 3 {
+			t.Fatalf("%s: 3 choices should be collected with 3 requests, got %v with %d",
+				policy, response.Choices, len(standIn.requests["small"]))
+		}
+	}
+
+	// model has less different answers than needed, requests are still limited
+	standIn := &completionStandIn{choices: map[string][]string{"small": {"a"}}}
+	response, _ := collectChoices(GetCompletionRequest{Model: "small", MinResults: 5}, standIn.complete)
+	if len(response.Choices) != 1 || len(standIn.requests["small"]) != 5 {
+		t.Fatalf("collecting should stop after min-results requests: %v, %d", response.Choices, len(standIn.requests["small"]))
+	}
+}
+
+func TestCascadeStepsDownUntilAccepted(t *testing.T) {
+	standIn := &completionStandIn{
+		choices: map[string][]string{
+			"tiny":  {"Four, I think"},
+			"small": {`{"answer": 4}`},
+			"large": {`{"answer": 4}`},
+		},
+		failing: map[string]bool{"broken": true},
+	}
+	cr := GetCompletionRequest{
+		Cascade:    []string{"broken", "tiny", "small", "large"},
+		Acceptance: &AcceptanceCheck{Type: AcceptanceJSON},
+	}
+
+	response, err := runCompletionCascade(cr, standIn.complete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Cascade.Model != "small" || response.Cascade.Tier != 2 || !response.Cascade.Accepted {
+		t.Fatalf("cascade should stop at the first accepted tier: %+v", response.Cascade)
+	}
+	if len(response.Cascade.Attempts) != 3 || response.Cascade.Attempts[0].Error == "" ||
+		response.Cascade.Attempts[1].Accepted {
+		t.Fatalf("failed and rejected attempts should be reported: %+v", response.Cascade.Attempts)
+	}
+	if len(standIn.requests["large"]) != 0 {
+		t.Fatalf("models after the accepted one shouldn't be asked")
+	}
+	if !reflect.DeepEqual(response.Choices, []string{`{"answer": 4}`}) {
+		t.Fatalf("accepted choice should be returned: %v", response.Choices)
+	}
+}
+
+func TestCascadeReturnsLastAnswerIfNoneAccepted(t *testing.T) {
+	standIn := &completionStandIn{choices: map[string][]string{
+		"tiny":  {"Paris", "London", "Rome"},
+		"small": {"Paris", "London", "Rome"},
+	}}
+	cr := GetCompletionRequest{
+		Cascade:    []string{"tiny", "small"},
+		Acceptance: &AcceptanceCheck{Type: AcceptanceVote, Votes: 3},
+	}
+
+	response, err := runCompletionCascade(cr, standIn.complete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Cascade.Accepted || response.Cascade.Model != "small" || len(response.Choices) != 3 {
+		t.Fatalf("last tier's answer should be returned: %+v, %v", response.Cascade, response.Choices)
+	}
+	if standIn.requests["tiny"][0] != 3 {
+		t.Fatalf("vote check should ask for votes number of choices: %v", standIn.requests["tiny"])
+	}
+
+	standIn = &completionStandIn{failing: map[string]bool{"tiny": true, "small": true}}
+	if _, err = runCompletionCascade(cr, standIn.complete); err == nil {
+		t.Fatalf("cascade without answers should fail")
+	}
+	if _, err = runCompletionCascade(GetCompletionRequest{Cascade: []string{"tiny"}}, standIn.complete); err == nil {
+		t.Fatalf("cascade without acceptance check should fail")
+	}
+}
@@ -0,0 +1,127 @@
+package cmds
+
+import (
+	"fmt"
+	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+	"github.com/d0rc/agent-os/server"
+	"github.com/d0rc/agent-os/vectors"
+)
+
+// namespaces of LLM cache embeddings, stored by the background embeddings worker
+const (
+	NamespaceLLMCachePrompt     = "llm-cache-prompt"
+	NamespaceLLMCacheGeneration = "llm-cache-generation"
+)
+
+// payload keys of LLM cache embeddings
+const (
+	PayloadNamespace   = "namespace"
+	PayloadNamespaceId = "namespace-id"
+)
+
+const defaultSemanticCacheThreshold = 0.95
+const semanticCacheNeighbours = 5
+
+// lookupSemanticCache finds cached choices of prompts, which are close to the request's prompt,
+// choices are generated with the same settings, but for a different prompt, so they are approximate
+func lookupSemanticCache(cr *GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority) ([]CompletionCacheRecord, error) {
+	if len(ctx.VectorDBs) == 0 {
+		return nil, fmt.Errorf("no vector storage configured")
+	}
+
+	embeddings, err := processGetEmbeddings(GetEmbeddingsRequest{
+		RawPrompt:    cr.RawPrompt,
+		NoFederation: cr.NoFederation,
+	}, ctx, process, priority)
+	if err != nil {
+		return nil, err
+	}
+	if embeddings == nil || len(embeddings.Embeddings) == 0 {
+		return nil, fmt.Errorf("no embeddings for the prompt")
+	}
+
+	recordIds, err := findSemanticNeighbours(ctx.VectorDBs[0], ctx.GetLLMCacheCollection(), cr, embeddings)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]CompletionCacheRecord, 0)
+	seenPrompts := map[string]struct{}{cr.RawPrompt: {}} // exact matches are found by the regular lookup
+	for _, recordId := range recordIds {
+		neighbourRecords := make([]CompletionCacheRecord, 0, 1)
+		err := ctx.Storage.Db.GetStructsSlice("query-llm-cache-by-id", &neighbourRecords, recordId)
+		if err != nil {
+			return nil, err
+		}
+		if len(neighbourRecords) == 0 {
+			continue
+		}
+		// choices are looked up by the prompt, records of the same prompt have them all
+		if _, seen := seenPrompts[neighbourRecords[0].Prompt]; seen {
+			continue
+		}
+		seenPrompts[neighbourRecords[0].Prompt] = struct{}{}
+
+		neighbourRequest := *cr
+		neighbourRequest.RawPrompt = neighbourRecords[0].Prompt
+		neighbourChoices, err := lookupCompletionCache(&neighbourRequest, ctx)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, neighbourChoices...)
+	}
+
+	return records, nil
+}
+
+// findSemanticNeighbours returns ids of LLM cache records, which prompts are close enough to the request's one,
+// the closest go first, score is checked here as well, since not every vector storage applies min score
+func findSemanticNeighbours(vectorDB vectors.VectorDB, collection string, cr *GetCompletionRequest, embeddings *GetEmbeddingsResponse) ([]int64, error) {
+	threshold := cr.SemanticThreshold
+	if threshold == 0 {
+		threshold = defaultSemanticCacheThreshold
+	}
+
+	neighbours, err := vectorDB.FindNeighborhoods(collection,
+		&vectors.Vector{VecF64: embeddings.Embeddings},
+		&vectors.SearchSettings{
+			Limit:    semanticCacheNeighbours,
+			MinScore: float64(threshold),
+			Payload: map[string]interface{}{
+				PayloadNamespace: NamespaceLLMCachePrompt,
+				"model":          embeddings.Model,
+			},
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	recordIds := make([]int64, 0, len(neighbours))
+	for _, neighbour := range neighbours {
+		if neighbour.Score < float64(threshold) {
+			continue
+		}
+		recordId, ok := neighbour.Payload[PayloadNamespaceId].(float64)
+		if !ok {
+			continue
+		}
+		recordIds = append(recordIds, int64(recordId))
+	}
+
+	return recordIds, nil
+}
+
+// fillApproximateChoices adds choices of similar prompts, only as many as are missing to min results,
+// records used are returned, so their cache hits are counted
+func (r *GetCompletionResponse) fillApproximateChoices(records []CompletionCacheRecord, minResults int) []CompletionCacheRecord {
+	used := 0
+	for _, cacheRecord := range records {
+		if len(r.Choices) >= max(minResults, 1) {
+			break
+		}
+		r.addApproximateChoice(cacheRecord.GenerationResult, decodeLogprobs(cacheRecord.Logprobs))
+		used++
+	}
+
+	return records[:used]
+}
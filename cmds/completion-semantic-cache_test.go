@@ -0,0 +1,90 @@
+package cmds
+
+import (
+	"github.com/d0rc/agent-os/vectors"
+	"testing"
+)
+
+// vectorDBStandIn returns neighbours as they are, without applying search settings
+type vectorDBStandIn struct {
+	neighbours []*vectors.Vector
+	settings   *vectors.SearchSettings
+}
+
+func (db *vectorDBStandIn) CreateCollection(string, *vectors.CollectionParameters) error {
+	return nil
+}
+
+func (db *vectorDBStandIn) InsertVectors(string, []*vectors.Vector) error {
+	return nil
+}
+
+func (db *vectorDBStandIn) FindNeighborhoods(_ string, _ *vectors.Vector, settings *vectors.SearchSettings) ([]*vectors.Vector, error) {
+	db.settings = settings
+	return db.neighbours, nil
+}
+
+func TestFindSemanticNeighbours(t *testing.T) {
+	neighbour := func(recordId float64, score float64) *vectors.Vector {
+		return &vectors.Vector{Score: score, Payload: map[string]interface{}{
+			PayloadNamespace:   NamespaceLLMCachePrompt,
+			PayloadNamespaceId: recordId,
+		}}
+	}
+	db := &vectorDBStandIn{neighbours: []*vectors.Vector{
+		neighbour(1, 0.99),
+		neighbour(2, 0.96),
+		neighbour(3, 0.9),
+		{Score: 0.98, Payload: map[string]interface{}{"text": "point without the record"}},
+	}}
+	embeddings := &GetEmbeddingsResponse{Embeddings: []float64{1, 0}, Model: "nomic-embed-text"}
+
+	recordIds, err := findSemanticNeighbours(db, "llm-cache", &GetCompletionRequest{}, embeddings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recordIds) != 2 || recordIds[0] != 1 || recordIds[1] != 2 {
+		t.Fatalf("records below default threshold should be skipped, got %v", recordIds)
+	}
+	if db.settings.MinScore != float64(float32(defaultSemanticCacheThreshold)) {
+		t.Fatalf("default threshold should be passed to the storage, got %f", db.settings.MinScore)
+	}
+	if db.settings.Payload[PayloadNamespace] != NamespaceLLMCachePrompt || db.settings.Payload["model"] != "nomic-embed-text" {
+		t.Fatalf("only prompts embedded with the same model should be searched: %v", db.settings.Payload)
+	}
+
+	recordIds, _ = findSemanticNeighbours(db, "llm-cache", &GetCompletionRequest{SemanticThreshold: 0.85}, embeddings)
+	if len(recordIds) != 3 {
+		t.Fatalf("request's threshold should be used, got %v", recordIds)
+	}
+}
+
+func TestApproximateChoicesFillMissingOnly(t *testing.T) {
+	approximate := []CompletionCacheRecord{
+		{Id: 1, GenerationResult: "a"},
+		{Id: 2, GenerationResult: "b"},
+		{Id: 3, GenerationResult: "c"},
+	}
+
+	response := &GetCompletionResponse{}
+	response.addChoice("exact", nil)
+	used := response.fillApproximateChoices(approximate, 3)
+	if len(used) != 2 || used[1].Id != 2 {
+		t.Fatalf("only missing choices should be filled, used %v", used)
+	}
+	if len(response.Choices) != 3 || response.Approximate[0] || !response.Approximate[1] || !response.Approximate[2] {
+		t.Fatalf("approximate choices should follow exact ones: %v %v", response.Choices, response.Approximate)
+	}
+
+	response = &GetCompletionResponse{}
+	response.addChoice("exact", nil)
+	if used := response.fillApproximateChoices(approximate, 1); len(used) != 0 || len(response.Choices) != 1 {
+		t.Fatalf("exact choices are enough, approximate ones shouldn't be added")
+	}
+
+	// min results of 0 still wants a choice
+	response = &GetCompletionResponse{}
+	if used := response.fillApproximateChoices(approximate, 0); len(used) != 1 || response.Choices[0] != "a" {
+		t.Fatalf("closest approximate choice should be returned, got %v", response.Choices)
+	}
+}
@@ -16,6 +16,7 @@ func SendComputeRequest(ctx *server.Context,
 	computeResult := &borrow_engine.ComputeResult{
 		CompletionChannel: make(chan *engines.Message, 1),
 		EmbeddingChannel:  make(chan *vectors.Vector, 1),
+		ErrorChannel:      make(chan error, 1),
 	}
 
 	// ctx.Log.Info().Msgf("Sending compute request for process %s, job type %s, job priority %s",
@@ -34,3 +35,17 @@ func SendComputeRequest(ctx *server.Context,
 
 	return computeResult
 }
+
+// ApplyLabels adds labels of the client request to its completion and embeddings requests, their own labels win
+func (r *ClientRequest) ApplyLabels() {
+	for idx := range r.GetCompletionRequests {
+		cr := &r.GetCompletionRequests[idx]
+		cr.RequireLabels = borrow_engine.MergeLabels(r.RequireLabels, cr.RequireLabels)
+		cr.PreferLabels = borrow_engine.MergeLabels(r.PreferLabels, cr.PreferLabels)
+	}
+	for idx := range r.GetEmbeddingsRequests {
+		er := &r.GetEmbeddingsRequests[idx]
+		er.RequireLabels = borrow_engine.MergeLabels(r.RequireLabels, er.RequireLabels)
+		er.PreferLabels = borrow_engine.MergeLabels(r.PreferLabels, er.PreferLabels)
+	}
+}
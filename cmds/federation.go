@@ -0,0 +1,155 @@
+package cmds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/server"
+	"github.com/d0rc/agent-os/settings"
+	"io"
+	"net/http"
+	"time"
+)
+
+const defaultPeersCacheLookupTimeout = 2000 * time.Millisecond
+
+// getFederationPeers returns peers, which have all the required labels
+func getFederationPeers(ctx *server.Context, requireLabels map[string]string) []settings.ComputeConfigurationSection {
+	peers := make([]settings.ComputeConfigurationSection, 0)
+	for _, node := range ctx.Config.Compute {
+		if node.Type == engines.ProtocolAgencyOS && borrow_engine.MatchLabels(node.Labels, requireLabels) {
+			peers = append(peers, node)
+		}
+	}
+
+	return peers
+}
+
+func runPeerRequest(peer settings.ComputeConfigurationSection, request *ClientRequest, timeout time.Duration) (*ServerResponse, error) {
+	reqBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest("POST", peer.Endpoint, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if peer.Token != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", peer.Token))
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("peer %s responded with http code %d", peer.Endpoint, resp.StatusCode)
+	}
+
+	serverResponse := &ServerResponse{}
+	err = json.Unmarshal(respBytes, serverResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return serverResponse, nil
+}
+
+// askPeers sends the same request to federation peers, which have the required labels, in parallel
+// and returns all the responses received within the cache lookup timeout, prompts of jobs, which
+// must stay on labeled nodes, are never sent to other peers
+func askPeers(ctx *server.Context, request *ClientRequest, requireLabels map[string]string) []*ServerResponse {
+	peers := getFederationPeers(ctx, requireLabels)
+	if len(peers) == 0 {
+		return nil
+	}
+
+	timeout := defaultPeersCacheLookupTimeout
+	if ctx.Config.Federation.CacheLookupTimeout > 0 {
+		timeout = time.Duration(ctx.Config.Federation.CacheLookupTimeout) * time.Millisecond
+	}
+
+	results := make([]chan *ServerResponse, len(peers))
+	for idx, peer := range peers {
+		results[idx] = make(chan *ServerResponse, 1)
+		go func(peer settings.ComputeConfigurationSection, ch chan *ServerResponse) {
+			resp, err := runPeerRequest(peer, request, timeout)
+			if err != nil {
+				ctx.Log.Warn().Err(err).
+					Msgf("federation: cache lookup failed on peer %s", peer.Endpoint)
+			}
+			ch <- resp
+		}(peer, results[idx])
+	}
+
+	responses := make([]*ServerResponse, 0, len(peers))
+	for _, ch := range results {
+		if resp := <-ch; resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	return responses
+}
+
+func lookupPeersCompletionCache(cr GetCompletionRequest, ctx *server.Context, process string) *GetCompletionResponse {
+	cr.CacheOnly = true
+	cr.NoFederation = true
+	// approximate choices of peers would be saved as exact ones
+	cr.SemanticCache = false
+
+	result := &GetCompletionResponse{
+		Choices: make([]string, 0),
+	}
+	requireLabels, _ := ctx.ComputeRouter.RoutingLabels(process, cr.RequireLabels, nil)
+	for _, resp := range askPeers(ctx, &ClientRequest{
+		ProcessName:           process,
+		GetCompletionRequests: []GetCompletionRequest{cr},
+	}, requireLabels) {
+		for _, completion := range resp.GetCompletionResponse {
+			if completion == nil {
+				continue
+			}
+			for idx, choice := range completion.Choices {
+				var logprobs []engines.TokenLogprob
+				if len(completion.ChoicesLogprobs) == len(completion.Choices) {
+					logprobs = completion.ChoicesLogprobs[idx]
+				}
+				result.addChoice(choice, logprobs)
+			}
+		}
+	}
+
+	return result
+}
+
+func lookupPeersEmbeddingsCache(cr GetEmbeddingsRequest, ctx *server.Context, process string) *GetEmbeddingsResponse {
+	cr.CacheOnly = true
+	cr.NoFederation = true
+
+	requireLabels, _ := ctx.ComputeRouter.RoutingLabels(process, cr.RequireLabels, nil)
+	for _, resp := range askPeers(ctx, &ClientRequest{
+		ProcessName:           process,
+		GetEmbeddingsRequests: []GetEmbeddingsRequest{cr},
+	}, requireLabels) {
+		for _, embeddings := range resp.GetEmbeddingsResponse {
+			if embeddings != nil && len(embeddings.Embeddings) > 0 {
+				return embeddings
+			}
+		}
+	}
+
+	return nil
+}
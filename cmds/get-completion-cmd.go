@@ -1,10 +1,12 @@
 package cmds
 
 import (
+	"errors"
 	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/server"
 	"github.com/logrusorgru/aurora"
+	"math"
 	"time"
 )
 
@@ -16,7 +18,13 @@ func ProcessGetCompletions(request []GetCompletionRequest, ctx *server.Context,
 	for idx, pr := range request {
 		results[idx] = make(chan *GetCompletionResponse, 1)
 		go func(cr GetCompletionRequest, ch chan *GetCompletionResponse) {
-			completionResponse, err := processGetCompletion(cr, ctx, process, priority)
+			var completionResponse *GetCompletionResponse
+			var err error
+			if len(cr.Cascade) > 0 {
+				completionResponse, err = processCompletionCascade(cr, ctx, process, priority)
+			} else {
+				completionResponse, err = processGetCompletion(cr, ctx, process, priority)
+			}
 			if err != nil {
 				ctx.Log.Error().Err(err).
 					Msgf("Error processing completion request: ```%s```", aurora.Cyan(cr.RawPrompt))
@@ -45,17 +53,91 @@ type CompletionCacheRecord struct {
 	SerializedGenerationSettings []byte    `db:"generation_settings"`
 	CacheHits                    uint64    `db:"cache_hits"`
 	GenerationResult             string    `db:"generation_result"`
+	PromptTokens                 int       `db:"prompt_tokens"`
+	CompletionTokens             int       `db:"completion_tokens"`
+	Logprobs                     []byte    `db:"logprobs"`
+	Temperature                  *float32  `db:"temperature"`
+	StopTokens                   *string   `db:"stop_tokens"`
+	RequestedModel               *string   `db:"requested_model"` // set if request fell back to another model
+}
+
+// finalize drops logprobs from the response, unless client asked for them,
+// and approximate flags, unless there are semantic cache hits
+func (r *GetCompletionResponse) finalize(cr *GetCompletionRequest) *GetCompletionResponse {
+	if cr.Logprobs == 0 {
+		r.ChoicesLogprobs = nil
+	}
+
+	hasApproximate := false
+	for _, approximate := range r.Approximate {
+		hasApproximate = hasApproximate || approximate
+	}
+	if !hasApproximate {
+		r.Approximate = nil
+	}
+
+	return r
+}
+
+// rejected records the reason of the rejection in the response, choices
+// found in caches so far are returned as well
+func (r *GetCompletionResponse) rejected(cr *GetCompletionRequest, err error) (*GetCompletionResponse, error) {
+	contextErr := &engines.ContextLengthError{}
+	if errors.As(err, &contextErr) {
+		r.ContextError = contextErr
+	} else if errors.Is(err, engines.ErrContextLengthExceeded) {
+		r.ContextError = &engines.ContextLengthError{}
+	}
+	r.RetryAfter = retryAfter(err)
+
+	return r.finalize(cr), err
+}
+
+// retryAfter is the number of seconds to wait, before job rejected by admission control is retried
+func retryAfter(err error) int {
+	overloaded := &borrow_engine.OverloadedError{}
+	if !errors.As(err, &overloaded) {
+		return 0
+	}
+
+	return int(math.Ceil(overloaded.RetryAfter.Seconds()))
+}
+
+func (r *GetCompletionResponse) addChoice(choice string, logprobs []engines.TokenLogprob) {
+	r.Choices = append(r.Choices, choice)
+	r.ChoicesLogprobs = append(r.ChoicesLogprobs, logprobs)
+	r.Approximate = append(r.Approximate, false)
+}
+
+func (r *GetCompletionResponse) addApproximateChoice(choice string, logprobs []engines.TokenLogprob) {
+	r.addChoice(choice, logprobs)
+	r.Approximate[len(r.Approximate)-1] = true
 }
 
 func processGetCompletion(cr GetCompletionRequest, ctx *server.Context, process string, priority borrow_engine.JobPriority) (*GetCompletionResponse, error) {
-	cachedResponse := make([]CompletionCacheRecord, 0, 1)
-	err := ctx.Storage.Db.GetStructsSlice("query-llm-cache", &cachedResponse,
-		len(cr.RawPrompt), cr.RawPrompt)
+	if cr.RawPrompt == "" && len(cr.Messages) > 0 {
+		// actual prompt depends on the node's chat template,
+		// default one is used as a cache key
+		cr.RawPrompt = engines.DefaultChatTemplate.Render(cr.Messages)
+	}
 
-	if err != nil {
-		ctx.Log.Error().Err(err).
-			Msgf("Failed to get cached response for prompt %s", cr.RawPrompt)
-		// just continue...
+	if err := cr.CachePolicy.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := cr.Truncation.Validate(); err != nil {
+		return nil, err
+	}
+
+	var cachedResponse []CompletionCacheRecord
+	var err error
+	if cr.CachePolicy.canRead() {
+		cachedResponse, err = lookupCompletionCache(&cr, ctx)
+		if err != nil {
+			ctx.Log.Error().Err(err).
+				Msgf("Failed to get cached response for prompt %s", cr.RawPrompt)
+			// just continue...
+		}
 	}
 
 	response := &GetCompletionResponse{
@@ -65,7 +147,7 @@ func processGetCompletion(cr GetCompletionRequest, ctx *server.Context, process
 	if len(cachedResponse) > 0 {
 		// we have some cache hits, let's check if it's enough...!
 		for _, cacheRecord := range cachedResponse {
-			response.Choices = append(response.Choices, cacheRecord.GenerationResult)
+			response.addChoice(cacheRecord.GenerationResult, decodeLogprobs(cacheRecord.Logprobs))
 			_, err := ctx.Storage.Db.Exec("make-llm-cache-hit", cacheRecord.Id)
 			if err != nil {
 				ctx.Log.Error().Err(err).Msgf("error updating cache-hit counter: %v", err)
@@ -73,43 +155,128 @@ func processGetCompletion(cr GetCompletionRequest, ctx *server.Context, process
 		}
 
 		if len(response.Choices) >= cr.MinResults {
-			return response, nil
+			return response.finalize(&cr), nil
 		}
 	}
 
+	if !cr.NoFederation && cr.CachePolicy.canRead() {
+		// before spending our own GPU time, let's see if peers have it cached
+		peersResponse := lookupPeersCompletionCache(cr, ctx, process)
+		for idx, choice := range peersResponse.Choices {
+			response.addChoice(choice, peersResponse.ChoicesLogprobs[idx])
+			if !cr.CachePolicy.canWrite() {
+				continue
+			}
+			err = saveCompletionCache(&cr, ctx, "", "", choice, &engines.StatisticsInfo{}, peersResponse.ChoicesLogprobs[idx])
+			if err != nil {
+				ctx.Log.Error().Err(err).
+					Msgf("error saving peer's llm cache record: %v", err)
+			}
+		}
+
+		if len(response.Choices) > 0 && len(response.Choices) >= cr.MinResults {
+			return response.finalize(&cr), nil
+		}
+	}
+
+	if cr.SemanticCache && cr.CachePolicy.canRead() {
+		approximateRecords, err := lookupSemanticCache(&cr, ctx, process, priority)
+		if err != nil {
+			ctx.Log.Error().Err(err).
+				Msgf("semantic cache lookup failed: %v", err)
+		}
+		// approximate choices are only used to fill up missing ones
+		for _, cacheRecord := range response.fillApproximateChoices(approximateRecords, cr.MinResults) {
+			_, err := ctx.Storage.Db.Exec("make-llm-cache-hit", cacheRecord.Id)
+			if err != nil {
+				ctx.Log.Error().Err(err).Msgf("error updating cache-hit counter: %v", err)
+			}
+		}
+
+		if len(response.Choices) > 0 && len(response.Choices) >= cr.MinResults {
+			return response.finalize(&cr), nil
+		}
+	}
+
+	if cr.CacheOnly {
+		return response.finalize(&cr), nil
+	}
+
+	// callback is called before the result is delivered to the channel
+	var usage *engines.StatisticsInfo
+	generationSettings := &engines.GenerationSettings{
+		Messages:        cr.Messages,
+		AfterJoinPrefix: "",
+		RawPrompt:       cr.RawPrompt,
+		NoCache:         !cr.CachePolicy.canRead(),
+		CachePolicy:     string(cr.CachePolicy),
+		Temperature:     cr.Temperature,
+		StopTokens:      cr.StopTokens,
+		BestOf:          cr.BestOf,
+		StatisticsCallback: func(info *engines.StatisticsInfo) {
+			usage = info
+		},
+		MaxRetries:     1,
+		LocalOnly:      cr.NoFederation,
+		Grammar:        cr.Grammar,
+		Model:          cr.Model,
+		Truncation:     cr.Truncation,
+		Fallback:       cr.Fallback,
+		FallbackWait:   time.Duration(cr.FallbackWait) * time.Second,
+		RequireLabels:  cr.RequireLabels,
+		PreferLabels:   cr.PreferLabels,
+		SamplingParams: cr.SamplingParams,
+	}
+
+	// reject or truncate prompts, which don't fit into any node, before they're queued
+	err = engines.FitContext(generationSettings,
+		ctx.ComputeRouter.GetContextLength(cr.Model),
+		cr.Truncation,
+		ctx.ComputeRouter.GetTokenizer(cr.Model))
+	if err != nil {
+		return response.rejected(&cr, err)
+	}
+
 	results := SendComputeRequest(ctx,
 		process,
 		borrow_engine.JT_Completion,
 		priority,
-		&engines.GenerationSettings{
-			Messages:        nil,
-			AfterJoinPrefix: "",
-			RawPrompt:       cr.RawPrompt,
-			NoCache:         false,
-			Temperature:     cr.Temperature,
-			StopTokens:      cr.StopTokens,
-			BestOf:          cr.BestOf,
-			StatisticsCallback: func(info *engines.StatisticsInfo) {
-
-			},
-			MaxRetries: 1,
-		})
-	message := <-results.CompletionChannel
-
-	_, err = ctx.Storage.Db.Exec("insert-llm-cache-record",
-		cr.Model,
-		cr.RawPrompt,
-		len(cr.RawPrompt),
-		time.Now(),
-		"",
-		0,
-		message.Content)
-	if err != nil {
-		ctx.Log.Error().Err(err).
-			Msgf("error creating new llm cache record: %v", err)
+		generationSettings)
+	var message *engines.Message
+	select {
+	case message = <-results.CompletionChannel:
+	case err = <-results.ErrorChannel:
+		return response.rejected(&cr, err)
+	}
+	if usage == nil {
+		usage = &engines.StatisticsInfo{}
+	}
+	if generationSettings.Model != cr.Model {
+		// scheduler switched the job to a fallback model
+		response.FallbackModel = generationSettings.Model
 	}
 
-	response.Choices = append(response.Choices, message.Content)
+	if cr.CachePolicy == CachePolicyRefresh {
+		if err := dropCompletionCache(&cr, ctx); err != nil {
+			ctx.Log.Error().Err(err).
+				Msgf("error dropping llm cache records: %v", err)
+		}
+	}
+
+	if cr.CachePolicy.canWrite() {
+		err = saveCompletionCache(&cr, ctx, response.FallbackModel, usage.Model, message.Content, usage, message.Logprobs)
+		if err != nil {
+			ctx.Log.Error().Err(err).
+				Msgf("error creating new llm cache record: %v", err)
+		}
+	}
+
+	response.addChoice(message.Content, message.Logprobs)
+	response.Usage = &CompletionUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.TokensGenerated,
+		Model:            usage.Model,
+	}
 
-	return response, nil
+	return response.finalize(&cr), nil
 }
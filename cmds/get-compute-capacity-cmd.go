@@ -0,0 +1,29 @@
+package cmds
+
+import (
+	"github.com/d0rc/agent-os/server"
+)
+
+func ProcessGetComputeCapacity(request *GetComputeCapacityRequest, ctx *server.Context) (response *ServerResponse, err error) {
+	if !ctx.Config.Federation.Advertise {
+		return &ServerResponse{
+			ComputeCapacity: &GetComputeCapacityResponse{
+				Advertised: false,
+				Models:     []string{},
+			},
+		}, nil
+	}
+
+	capacity := ctx.ComputeRouter.GetSpareCapacity()
+
+	return &ServerResponse{
+		ComputeCapacity: &GetComputeCapacityResponse{
+			Advertised:     true,
+			FreeSlots:      capacity.FreeSlots,
+			TotalSlots:     capacity.TotalSlots,
+			QueuedJobs:     capacity.QueuedJobs,
+			Models:         capacity.Models,
+			EmbeddingsDims: capacity.EmbeddingsDims,
+		},
+	}, nil
+}
@@ -78,6 +78,9 @@ retryLoop:
 			// just continue...
 		} else {
 			response.Embeddings = decodedVector.VecF64
+			response.TextHash = textHash
+			response.Model = cachedResponse[0].Model
+			response.Text = cr.RawPrompt
 			_, err := ctx.Storage.Db.Exec("make-embeddings-cache-hit", cachedResponse[0].Id)
 			if err != nil {
 				ctx.Log.Error().Err(err).
@@ -89,16 +92,43 @@ retryLoop:
 		}
 	}
 
+	if cr.CacheOnly {
+		return response, nil
+	}
+
 	// once we're here, there were no embeddings in the cache
-	// let's try to generate them
-	computeResult := SendComputeRequest(ctx,
-		process,
-		borrowengine.JT_Embeddings,
-		priority,
-		&engines.GenerationSettings{
-			RawPrompt: cr.RawPrompt,
-		})
-	embeddings := <-computeResult.EmbeddingChannel
+	// let's try to get them from peers, or to generate them
+	var embeddings *vectors.Vector
+	if !cr.NoFederation {
+		if peerResponse := lookupPeersEmbeddingsCache(cr, ctx, process); peerResponse != nil {
+			embeddings = &vectors.Vector{
+				VecF64: peerResponse.Embeddings,
+				Model:  &peerResponse.Model,
+			}
+		}
+	}
+
+	if embeddings == nil {
+		computeResult := SendComputeRequest(ctx,
+			process,
+			borrowengine.JT_Embeddings,
+			priority,
+			&engines.GenerationSettings{
+				RawPrompt:     cr.RawPrompt,
+				LocalOnly:     cr.NoFederation,
+				Model:         cr.Model,
+				RequireLabels: cr.RequireLabels,
+				PreferLabels:  cr.PreferLabels,
+			})
+		select {
+		case embeddings = <-computeResult.EmbeddingChannel:
+		case err = <-computeResult.ErrorChannel:
+			if seconds := retryAfter(err); seconds > 0 {
+				return &GetEmbeddingsResponse{RetryAfter: seconds}, err
+			}
+			return nil, err
+		}
+	}
 	// ctx.Log.Info().Msgf("Got embeddings for prompt %d", len(cr.RawPrompt))
 
 	// and now, need to save the result into the cache
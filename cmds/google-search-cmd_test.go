@@ -1,3 +1,7 @@
+//go:build integration
+
+// needs storage and network, it predates the current commands API
+
 package cmds
 
 import (
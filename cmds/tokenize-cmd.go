@@ -0,0 +1,102 @@
+package cmds
+
+import (
+	"fmt"
+	"github.com/d0rc/agent-os/server"
+	"github.com/d0rc/agent-os/tokenizers"
+	"sync"
+)
+
+// chunks are decoded in parallel, engine's tokenizer takes a request per chunk
+const maxParallelChunkDecodes = 8
+
+func ProcessTokenize(requests []TokenizeRequest, ctx *server.Context) (response *ServerResponse, err error) {
+	results := make([]*TokenizeResponse, 0, len(requests))
+	for _, request := range requests {
+		result, err := processTokenize(request, ctx)
+		if err != nil {
+			ctx.Log.Error().Err(err).
+				Msgf("error processing %s request for model %s", request.Operation, request.Model)
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	return &ServerResponse{
+		TokenizeResponse: results,
+	}, nil
+}
+
+func processTokenize(request TokenizeRequest, ctx *server.Context) (*TokenizeResponse, error) {
+	tokenizer := ctx.ComputeRouter.GetTokenizer(request.Model)
+	response := &TokenizeResponse{
+		Tokenizer: tokenizer.Name(),
+	}
+
+	switch request.Operation {
+	case "", TokenizeOperationTokenize, TokenizeOperationCount:
+		tokens, err := tokenizer.Encode(request.Text)
+		if err != nil {
+			return response, err
+		}
+		response.Count = len(tokens)
+		if request.Operation != TokenizeOperationCount {
+			response.Tokens = tokens
+		}
+	case TokenizeOperationDetokenize:
+		text, err := tokenizer.Decode(request.Tokens)
+		if err != nil {
+			return response, err
+		}
+		response.Text = text
+		response.Count = len(request.Tokens)
+	case TokenizeOperationSplit:
+		if request.ChunkTokens <= 0 {
+			return response, fmt.Errorf("chunk-tokens should be positive, got %d", request.ChunkTokens)
+		}
+		tokens, err := tokenizer.Encode(request.Text)
+		if err != nil {
+			return response, err
+		}
+		chunks, err := splitTokens(tokenizer, tokens, request.ChunkTokens)
+		if err != nil {
+			return response, err
+		}
+		response.Chunks = chunks
+		response.Count = len(tokens)
+	default:
+		return response, fmt.Errorf("unknown tokenize operation: %s", request.Operation)
+	}
+
+	return response, nil
+}
+
+// splitTokens decodes every chunkTokens tokens into a chunk of text
+func splitTokens(tokenizer tokenizers.Tokenizer, tokens []int, chunkTokens int) ([]string, error) {
+	chunks := make([]string, (len(tokens)+chunkTokens-1)/chunkTokens)
+	errs := make([]error, len(chunks))
+	semaphore := make(chan struct{}, maxParallelChunkDecodes)
+	wg := sync.WaitGroup{}
+	for idx := range chunks {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(idx int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			end := min((idx+1)*chunkTokens, len(tokens))
+			chunks[idx], errs[idx] = tokenizer.Decode(tokens[idx*chunkTokens : end])
+		}(idx)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chunks, nil
+}
@@ -0,0 +1,57 @@
+package cmds
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// wordsTokenizer has a token per word, token is the word's index in the text
+type wordsTokenizer struct {
+	words   []string
+	decodes int64
+}
+
+func (t *wordsTokenizer) Name() string {
+	return "words"
+}
+
+func (t *wordsTokenizer) Encode(text string) ([]int, error) {
+	t.words = strings.Fields(text)
+	tokens := make([]int, len(t.words))
+	for idx := range tokens {
+		tokens[idx] = idx
+	}
+
+	return tokens, nil
+}
+
+func (t *wordsTokenizer) Decode(tokens []int) (string, error) {
+	atomic.AddInt64(&t.decodes, 1)
+	words := make([]string, len(tokens))
+	for idx, token := range tokens {
+		words[idx] = t.words[token]
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+func TestSplitTokens(t *testing.T) {
+	tokenizer := &wordsTokenizer{}
+	tokens, _ := tokenizer.Encode("one two three four five six seven")
+
+	chunks, err := splitTokens(tokenizer, tokens, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(chunks, "|") != "one two three|four five six|seven" {
+		t.Fatalf("unexpected chunks: %q", chunks)
+	}
+	if tokenizer.decodes != 3 {
+		t.Fatalf("expected a decode per chunk, got %d", tokenizer.decodes)
+	}
+
+	if chunks, _ := splitTokens(tokenizer, nil, 3); len(chunks) != 0 {
+		t.Fatalf("no chunks expected for empty text: %q", chunks)
+	}
+}
@@ -1,6 +1,9 @@
 package cmds
 
-import borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+import (
+	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+	"github.com/d0rc/agent-os/engines"
+)
 
 type GetPageRequest struct {
 	Url           string `json:"url"`
@@ -45,20 +48,40 @@ type GoogleSearchResponse struct {
 }
 
 type GetCompletionRequest struct {
-	Model       string   `json:"model-mask"` // * - any model
-	RawPrompt   string   `json:"raw-prompt"` //
-	Temperature float32  `json:"temperature"`
-	StopTokens  []string `json:"stop-tokens"`
-	MinResults  int      `json:"min-results"`
-	MaxResults  int      `json:"max-results"` // default = 100
-	BestOf      int      `json:"best-of"`
+	Model             string                     `json:"model-mask"` // * - any model
+	RawPrompt         string                     `json:"raw-prompt"` //
+	Temperature       float32                    `json:"temperature"`
+	StopTokens        []string                   `json:"stop-tokens"`
+	MinResults        int                        `json:"min-results"`
+	MaxResults        int                        `json:"max-results"` // default = 100
+	BestOf            int                        `json:"best-of"`
+	CacheOnly         bool                       `json:"cache-only"`         // never run inference, return cached choices only
+	NoFederation      bool                       `json:"no-federation"`      // don't ask peers, set by peers themselves
+	Grammar           string                     `json:"grammar"`            // GBNF grammar to constrain the output, if engine supports it
+	Messages          []engines.Message          `json:"messages"`           // chat to continue, if set, raw-prompt is optional
+	CachePolicy       CachePolicy                `json:"cache-policy"`       // use (default), bypass, refresh, read-only or write-only
+	MaxAge            int                        `json:"max-age"`            // max age of cached choices in seconds, 0 - any
+	SemanticCache     bool                       `json:"semantic-cache"`     // reuse choices of similar prompts, if there are not enough exact ones
+	SemanticThreshold float32                    `json:"semantic-threshold"` // min cosine similarity of prompts, default is 0.95
+	Truncation        engines.TruncationStrategy `json:"truncation"`         // reject (default), truncate-middle or truncate-oldest-messages
+	Cascade           []string                   `json:"cascade"`            // model masks to try, cheapest first, model-mask is ignored if set
+	Acceptance        *AcceptanceCheck           `json:"acceptance"`         // decides if cascade's model answer is good enough
+	Fallback          []string                   `json:"fallback"`           // model masks to switch to, if model has no healthy node, any - any model
+	FallbackWait      int                        `json:"fallback-wait"`      // seconds to wait for each model of the fallback, default is 30
+	RequireLabels     map[string]string          `json:"require-labels"`     // run only on nodes with these labels
+	PreferLabels      map[string]string          `json:"prefer-labels"`      // nodes with these labels go first
+	engines.SamplingParams
 }
 
 type GetEmbeddingsRequest struct {
-	Model           string `json:"model-mask"` // * - any model
-	RawPrompt       string `json:"raw-prompt"` //
-	MetaNamespace   string `json:"meta-namespace"`
-	MetaNamespaceId int64  `json:"meta-namespace-id"`
+	Model           string            `json:"model-mask"` // * - any model
+	RawPrompt       string            `json:"raw-prompt"` //
+	MetaNamespace   string            `json:"meta-namespace"`
+	MetaNamespaceId int64             `json:"meta-namespace-id"`
+	CacheOnly       bool              `json:"cache-only"`
+	NoFederation    bool              `json:"no-federation"`
+	RequireLabels   map[string]string `json:"require-labels"`
+	PreferLabels    map[string]string `json:"prefer-labels"`
 }
 
 type GetEmbeddingsResponse struct {
@@ -66,10 +89,39 @@ type GetEmbeddingsResponse struct {
 	TextHash   string    `json:"text-hash"`
 	Model      string    `json:"model"`
 	Text       string    `json:"text"`
+	RetryAfter int       `json:"retry-after,omitempty"` // seconds to wait before retrying, if compute is overloaded
 }
 
 type GetCompletionResponse struct {
-	Choices []string `json:"choices"`
+	Choices         []string                    `json:"choices"`
+	ChoicesLogprobs [][]engines.TokenLogprob    `json:"choices-logprobs,omitempty"`     // one per choice, if logprobs were requested
+	Approximate     []bool                      `json:"approximate,omitempty"`          // one per choice, true for semantic cache hits
+	Usage           *CompletionUsage            `json:"usage,omitempty"`                // tokens spent on this request, nil if all choices were cached
+	ContextError    *engines.ContextLengthError `json:"context-length-error,omitempty"` // set if prompt was rejected for being too long
+	Cascade         *CascadeResult              `json:"cascade,omitempty"`              // which model of the cascade answered
+	FallbackModel   string                      `json:"fallback-model,omitempty"`       // model mask request was switched to, if its model had no healthy node
+	RetryAfter      int                         `json:"retry-after,omitempty"`          // seconds to wait before retrying, if compute is overloaded
+}
+
+type CascadeResult struct {
+	Tier     int              `json:"tier"`     // index of the model in the cascade, which answered
+	Model    string           `json:"model"`    // mask of that model
+	Accepted bool             `json:"accepted"` // false if no model passed the check, the last one answered
+	Attempts []CascadeAttempt `json:"attempts"`
+}
+
+type CascadeAttempt struct {
+	Model      string           `json:"model"`
+	Accepted   bool             `json:"accepted"`
+	Confidence float64          `json:"confidence"` // share of valid JSON choices, best mean token probability, or winning vote share
+	Usage      *CompletionUsage `json:"usage,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type CompletionUsage struct {
+	PromptTokens     int    `json:"prompt-tokens"`
+	CompletionTokens int    `json:"completion-tokens"`
+	Model            string `json:"model"`
 }
 
 type GetCacheRecord struct {
@@ -93,27 +145,71 @@ type SetCacheRecordResponse struct {
 	Done bool `json:"done"`
 }
 
+type GetComputeCapacityRequest struct {
+}
+
+type GetComputeCapacityResponse struct {
+	Advertised     bool     `json:"advertised"`
+	FreeSlots      int      `json:"free-slots"`
+	TotalSlots     int      `json:"total-slots"`
+	QueuedJobs     int      `json:"queued-jobs"`
+	Models         []string `json:"models"`
+	EmbeddingsDims uint64   `json:"embeddings-dims"`
+}
+
+type TokenizeOperation string
+
+const (
+	TokenizeOperationTokenize   TokenizeOperation = "tokenize"
+	TokenizeOperationDetokenize TokenizeOperation = "detokenize"
+	TokenizeOperationCount      TokenizeOperation = "count"
+	TokenizeOperationSplit      TokenizeOperation = "split"
+)
+
+type TokenizeRequest struct {
+	Model       string            `json:"model-mask"`   // * - any model
+	Operation   TokenizeOperation `json:"operation"`    // tokenize (default), detokenize, count or split
+	Text        string            `json:"text"`         // for tokenize, count and split
+	Tokens      []int             `json:"tokens"`       // for detokenize
+	ChunkTokens int               `json:"chunk-tokens"` // for split, max tokens of a chunk
+}
+
+type TokenizeResponse struct {
+	Tokenizer string   `json:"tokenizer"` // i.e. gpt2, huggingface:Mistral-7B-v0.1 or engine:llama-2-7b
+	Tokens    []int    `json:"tokens,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	Chunks    []string `json:"chunks,omitempty"` // text split into chunks of chunk-tokens
+	Count     int      `json:"count"`
+	Error     string   `json:"error,omitempty"`
+}
+
 type ClientRequest struct {
-	Tags                  []string                  `json:"tags"`
-	ProcessName           string                    `json:"process-name"`
-	Priority              borrow_engine.JobPriority `json:"priority"`
-	GetPageRequests       []GetPageRequest          `json:"get-page-request"`
-	GoogleSearchRequests  []GoogleSearchRequest     `json:"google-search-request"`
-	GetCompletionRequests []GetCompletionRequest    `json:"get-completion-requests"`
-	GetEmbeddingsRequests []GetEmbeddingsRequest    `json:"get-embeddings-requests"`
-	CorrelationId         string                    `json:"correlation-id"`
-	SpecialCaseResponse   string                    `json:"special-case-response"`
-	GetCacheRecords       []GetCacheRecord          `json:"get-cache-records"`
-	SetCacheRecords       []SetCacheRecord          `json:"set-cache-records"`
+	Tags                  []string                   `json:"tags"`
+	ProcessName           string                     `json:"process-name"`
+	Priority              borrow_engine.JobPriority  `json:"priority"`
+	GetPageRequests       []GetPageRequest           `json:"get-page-request"`
+	GoogleSearchRequests  []GoogleSearchRequest      `json:"google-search-request"`
+	GetCompletionRequests []GetCompletionRequest     `json:"get-completion-requests"`
+	GetEmbeddingsRequests []GetEmbeddingsRequest     `json:"get-embeddings-requests"`
+	CorrelationId         string                     `json:"correlation-id"`
+	SpecialCaseResponse   string                     `json:"special-case-response"`
+	GetCacheRecords       []GetCacheRecord           `json:"get-cache-records"`
+	SetCacheRecords       []SetCacheRecord           `json:"set-cache-records"`
+	GetComputeCapacity    *GetComputeCapacityRequest `json:"get-compute-capacity"`
+	TokenizeRequests      []TokenizeRequest          `json:"tokenize-requests"`
+	RequireLabels         map[string]string          `json:"require-labels"` // added to completion and embeddings requests
+	PreferLabels          map[string]string          `json:"prefer-labels"`
 }
 
 type ServerResponse struct {
-	GoogleSearchResponse  []*GoogleSearchResponse   `json:"google-search-response"`
-	GetPageResponse       []*GetPageResponse        `json:"get-page-response"`
-	GetCompletionResponse []*GetCompletionResponse  `json:"get-completion-response"`
-	GetEmbeddingsResponse []*GetEmbeddingsResponse  `json:"get-embeddings-response"`
-	GetCacheRecords       []*GetCacheRecordResponse `json:"get-cache-records"`
-	SetCacheRecords       []*SetCacheRecordResponse `json:"set-cache-records"`
-	CorrelationId         string                    `json:"correlation-id"`
-	SpecialCaseResponse   string                    `json:"special-case-response"`
+	GoogleSearchResponse  []*GoogleSearchResponse     `json:"google-search-response"`
+	GetPageResponse       []*GetPageResponse          `json:"get-page-response"`
+	GetCompletionResponse []*GetCompletionResponse    `json:"get-completion-response"`
+	GetEmbeddingsResponse []*GetEmbeddingsResponse    `json:"get-embeddings-response"`
+	GetCacheRecords       []*GetCacheRecordResponse   `json:"get-cache-records"`
+	SetCacheRecords       []*SetCacheRecordResponse   `json:"set-cache-records"`
+	ComputeCapacity       *GetComputeCapacityResponse `json:"compute-capacity"`
+	TokenizeResponse      []*TokenizeResponse         `json:"tokenize-response"`
+	CorrelationId         string                      `json:"correlation-id"`
+	SpecialCaseResponse   string                      `json:"special-case-response"`
 }
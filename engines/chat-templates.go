@@ -0,0 +1,186 @@
+package engines
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChatTemplate renders chat messages into a raw prompt for completion-only engines
+type ChatTemplate struct {
+	Name       string
+	StopTokens []string // default stop tokens, which end assistant's turn
+	render     func(messages []Message) string
+}
+
+func (t *ChatTemplate) Render(messages []Message) string {
+	return t.render(messages)
+}
+
+var ChatTemplateAlpaca = &ChatTemplate{
+	Name:       "alpaca",
+	StopTokens: []string{"###"},
+	render: func(messages []Message) string {
+		// following well known ### Instruction ### Assistant ### User format
+		rawPrompt := strings.Builder{}
+		for idx := range messages {
+			switch messages[idx].Role {
+			case ChatRoleSystem:
+				rawPrompt.WriteString(fmt.Sprintf("### Instruction:\n%s\n", messages[idx].Content))
+			case ChatRoleAssistant:
+				rawPrompt.WriteString(fmt.Sprintf("### Assistant:\n%s\n", messages[idx].Content))
+			case ChatRoleUser:
+				rawPrompt.WriteString(fmt.Sprintf("### User:\n%s\n", messages[idx].Content))
+			}
+		}
+		rawPrompt.WriteString("### Assistant:\n")
+
+		return rawPrompt.String()
+	},
+}
+
+var ChatTemplateChatML = &ChatTemplate{
+	Name:       "chatml",
+	StopTokens: []string{"<|im_end|>", "<|im_start|>"},
+	render: func(messages []Message) string {
+		rawPrompt := strings.Builder{}
+		for idx := range messages {
+			rawPrompt.WriteString(fmt.Sprintf("<|im_start|>%s\n%s<|im_end|>\n", messages[idx].Role, messages[idx].Content))
+		}
+		rawPrompt.WriteString("<|im_start|>assistant\n")
+
+		return rawPrompt.String()
+	},
+}
+
+var ChatTemplateLlama2 = &ChatTemplate{
+	Name:       "llama-2",
+	StopTokens: []string{"</s>", "[INST]"},
+	render: func(messages []Message) string {
+		return renderInstTemplate(messages, "<<SYS>>\n%s\n<</SYS>>\n\n", true)
+	},
+}
+
+var ChatTemplateMistral = &ChatTemplate{
+	Name:       "mistral",
+	StopTokens: []string{"</s>", "[INST]"},
+	render: func(messages []Message) string {
+		// there is no system role in mistral's template, so it's prepended to user's message
+		return renderInstTemplate(messages, "%s\n\n", false)
+	},
+}
+
+// DefaultChatTemplate is used when nothing is known about the model,
+// it's also used to render cache keys for chat requests
+var DefaultChatTemplate = ChatTemplateAlpaca
+
+var chatTemplates = map[string]*ChatTemplate{
+	ChatTemplateAlpaca.Name:  ChatTemplateAlpaca,
+	ChatTemplateChatML.Name:  ChatTemplateChatML,
+	ChatTemplateLlama2.Name:  ChatTemplateLlama2,
+	ChatTemplateMistral.Name: ChatTemplateMistral,
+}
+
+func GetChatTemplate(name string) (*ChatTemplate, error) {
+	template, exists := chatTemplates[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown chat template %s", name)
+	}
+
+	return template, nil
+}
+
+// DetectChatTemplate guesses chat template by the model name
+func DetectChatTemplate(model string) *ChatTemplate {
+	model = strings.ToLower(model)
+	switch {
+	case strings.Contains(model, "chatml") ||
+		strings.Contains(model, "hermes") ||
+		strings.Contains(model, "dolphin") ||
+		strings.Contains(model, "qwen") ||
+		strings.Contains(model, "orca-2"):
+		return ChatTemplateChatML
+	case (strings.Contains(model, "mistral") || strings.Contains(model, "mixtral")) &&
+		strings.Contains(model, "instruct"):
+		return ChatTemplateMistral
+	case (strings.Contains(model, "llama-2") || strings.Contains(model, "llama2")) &&
+		strings.Contains(model, "chat"):
+		return ChatTemplateLlama2
+	}
+
+	return DefaultChatTemplate
+}
+
+func renderInstTemplate(messages []Message, systemFormat string, bosEachTurn bool) string {
+	type turn struct {
+		user         string
+		assistant    string
+		hasAssistant bool
+	}
+
+	turns := make([]*turn, 0, len(messages))
+	pendingSystem := ""
+	userText := func(content string) string {
+		if pendingSystem != "" {
+			content = fmt.Sprintf(systemFormat, pendingSystem) + content
+			pendingSystem = ""
+		}
+		return content
+	}
+	for idx := range messages {
+		switch messages[idx].Role {
+		case ChatRoleSystem:
+			pendingSystem += messages[idx].Content
+		case ChatRoleUser:
+			turns = append(turns, &turn{user: userText(messages[idx].Content)})
+		case ChatRoleAssistant:
+			if len(turns) == 0 || turns[len(turns)-1].hasAssistant {
+				turns = append(turns, &turn{user: userText("")})
+			}
+			turns[len(turns)-1].assistant = messages[idx].Content
+			turns[len(turns)-1].hasAssistant = true
+		}
+	}
+	if pendingSystem != "" || len(turns) == 0 || turns[len(turns)-1].hasAssistant {
+		turns = append(turns, &turn{user: userText("")})
+	}
+
+	rawPrompt := strings.Builder{}
+	for idx, t := range turns {
+		if idx > 0 && bosEachTurn {
+			rawPrompt.WriteString("<s>")
+		}
+		rawPrompt.WriteString(fmt.Sprintf("[INST] %s [/INST]", strings.TrimSpace(t.user)))
+		if t.hasAssistant {
+			rawPrompt.WriteString(fmt.Sprintf(" %s </s>", t.assistant))
+		}
+	}
+
+	return rawPrompt.String()
+}
+
+// GetChatTemplate returns template configured for the engine, or the one detected by model's name
+func (engine *RemoteInferenceEngine) GetChatTemplate(model string) *ChatTemplate {
+	if engine.ChatTemplate != "" {
+		if template, err := GetChatTemplate(engine.ChatTemplate); err == nil {
+			return template
+		}
+	}
+
+	return DetectChatTemplate(model)
+}
+
+// RenderPrompt returns prompt and stop tokens for completion-only engines, if request
+// has messages, they are rendered with the chat template of the model job is routed to
+func (engine *RemoteInferenceEngine) RenderPrompt(req *GenerationSettings) (string, []string) {
+	if len(req.Messages) == 0 {
+		return req.RawPrompt, req.StopTokens
+	}
+
+	model, _ := engine.ResolveModel(req.Model)
+	template := engine.GetChatTemplate(model)
+	stopTokens := make([]string, 0, len(req.StopTokens)+len(template.StopTokens))
+	stopTokens = append(stopTokens, req.StopTokens...)
+	stopTokens = append(stopTokens, template.StopTokens...)
+
+	return template.Render(req.Messages), stopTokens
+}
@@ -0,0 +1,41 @@
+package engines
+
+import "testing"
+
+func TestChatTemplates(t *testing.T) {
+	chat := []Message{
+		{Role: ChatRoleSystem, Content: "Be brief."},
+		{Role: ChatRoleUser, Content: "Hi!"},
+		{Role: ChatRoleAssistant, Content: "Hello."},
+		{Role: ChatRoleUser, Content: "2 + 2 = ?"},
+	}
+
+	expected := map[*ChatTemplate]string{
+		ChatTemplateAlpaca: "### Instruction:\nBe brief.\n### User:\nHi!\n### Assistant:\nHello.\n### User:\n2 + 2 = ?\n### Assistant:\n",
+		ChatTemplateChatML: "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHi!<|im_end|>\n" +
+			"<|im_start|>assistant\nHello.<|im_end|>\n<|im_start|>user\n2 + 2 = ?<|im_end|>\n<|im_start|>assistant\n",
+		ChatTemplateLlama2:  "[INST] <<SYS>>\nBe brief.\n<</SYS>>\n\nHi! [/INST] Hello. </s><s>[INST] 2 + 2 = ? [/INST]",
+		ChatTemplateMistral: "[INST] Be brief.\n\nHi! [/INST] Hello. </s>[INST] 2 + 2 = ? [/INST]",
+	}
+	for template, prompt := range expected {
+		if rendered := template.Render(chat); rendered != prompt {
+			t.Errorf("%s: unexpected prompt:\n%q\nexpected:\n%q", template.Name, rendered, prompt)
+		}
+	}
+
+	// agents send just a system message
+	if rendered := ChatTemplateMistral.Render(chat[:1]); rendered != "[INST] Be brief. [/INST]" {
+		t.Errorf("unexpected system-only prompt: %q", rendered)
+	}
+
+	for model, template := range map[string]*ChatTemplate{
+		"TheBloke/Mistral-7B-Instruct-v0.1-AWQ":  ChatTemplateMistral,
+		"TheBloke/dolphin-2.2.1-mistral-7B-GGUF": ChatTemplateChatML,
+		"meta-llama/Llama-2-13b-chat-hf":         ChatTemplateLlama2,
+		"TheBloke/zephyr-7B-beta-AWQ":            DefaultChatTemplate,
+	} {
+		if detected := DetectChatTemplate(model); detected != template {
+			t.Errorf("%s: detected %s, expected %s", model, detected.Name, template.Name)
+		}
+	}
+}
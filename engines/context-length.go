@@ -0,0 +1,234 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/tokenizers"
+	"strings"
+)
+
+// TruncationStrategy tells what to do with prompts which don't fit into the context window
+type TruncationStrategy string
+
+const (
+	TruncationReject         TruncationStrategy = "reject"
+	TruncationMiddle         TruncationStrategy = "truncate-middle"
+	TruncationOldestMessages TruncationStrategy = "truncate-oldest-messages"
+)
+
+// DefaultCompletionReserve is the number of tokens left for the completion, if max-tokens isn't set
+const DefaultCompletionReserve = 512
+
+// messageTokensOverhead accounts for role markers chat templates add to every message
+const messageTokensOverhead = 4
+
+const truncationMarker = "\n...\n"
+
+func (s TruncationStrategy) Validate() error {
+	switch s {
+	case "", TruncationReject, TruncationMiddle, TruncationOldestMessages:
+		return nil
+	}
+
+	return fmt.Errorf("unknown truncation strategy: %s", s)
+}
+
+// ErrContextLengthExceeded is wrapped by all the errors caused by prompts being too long
+var ErrContextLengthExceeded = errors.New("prompt exceeds context length")
+
+// ContextLengthError is returned for requests which can't fit into the context window,
+// counters are zero if the error was reported by the backend
+type ContextLengthError struct {
+	PromptTokens  int `json:"prompt-tokens"`
+	MaxTokens     int `json:"max-tokens"`
+	ContextLength int `json:"context-length"`
+}
+
+func (e *ContextLengthError) Error() string {
+	if e.ContextLength == 0 {
+		return ErrContextLengthExceeded.Error()
+	}
+
+	return fmt.Sprintf("%s: %d prompt tokens and %d completion tokens don't fit into %d",
+		ErrContextLengthExceeded, e.PromptTokens, e.MaxTokens, e.ContextLength)
+}
+
+func (e *ContextLengthError) Unwrap() error {
+	return ErrContextLengthExceeded
+}
+
+// FitContext makes sure the prompt and the completion fit into contextLength tokens, applying
+// the truncation strategy, if needed, on success req.ContextTokens is set, so the job is
+// only scheduled to nodes which can run it
+func FitContext(req *GenerationSettings, contextLength int, strategy TruncationStrategy, tokenizer tokenizers.Tokenizer) error {
+	reserve := req.maxTokensOr(DefaultCompletionReserve)
+	promptTokens := countPromptTokens(req, tokenizer)
+	budget := contextLength - reserve
+	if contextLength <= 0 || promptTokens <= budget {
+		req.ContextTokens = promptTokens + reserve
+		return nil
+	}
+
+	contextErr := &ContextLengthError{
+		PromptTokens:  promptTokens,
+		MaxTokens:     reserve,
+		ContextLength: contextLength,
+	}
+	if budget <= 0 {
+		return contextErr
+	}
+
+	switch strategy {
+	case TruncationMiddle:
+		if len(req.Messages) == 0 {
+			req.RawPrompt = truncateText(req.RawPrompt, budget, true, tokenizer)
+		} else {
+			req.Messages = truncateLongestMessage(req.Messages, promptTokens-budget, tokenizer)
+		}
+	case TruncationOldestMessages:
+		if len(req.Messages) == 0 {
+			req.RawPrompt = truncateText(req.RawPrompt, budget, false, tokenizer)
+		} else {
+			req.Messages = dropOldestMessages(req.Messages, budget, tokenizer)
+		}
+	default:
+		return contextErr
+	}
+
+	promptTokens = countPromptTokens(req, tokenizer)
+	if promptTokens > budget {
+		contextErr.PromptTokens = promptTokens
+		return contextErr
+	}
+	req.ContextTokens = promptTokens + reserve
+
+	return nil
+}
+
+func countPromptTokens(req *GenerationSettings, tokenizer tokenizers.Tokenizer) int {
+	if len(req.Messages) == 0 {
+		return countTokens(tokenizer, req.RawPrompt)
+	}
+
+	tokens := 0
+	for idx := range req.Messages {
+		tokens += countMessageTokens(&req.Messages[idx], tokenizer)
+	}
+
+	return tokens
+}
+
+func countMessageTokens(message *Message, tokenizer tokenizers.Tokenizer) int {
+	return countTokens(tokenizer, message.Content) + messageTokensOverhead
+}
+
+// truncateText cuts the text down to budget tokens, either keeping its beginning
+// and its end, or only the end, cut part is replaced by the marker
+func truncateText(text string, budget int, keepHead bool, tokenizer tokenizers.Tokenizer) string {
+	runes := []rune(text)
+	tokens := countTokens(tokenizer, text)
+	keep := len(runes)
+	for tokens > budget && keep > 0 {
+		// characters per token are not constant, so leave some margin
+		keep = keep * budget / tokens * 9 / 10
+		headLen := 0
+		if keepHead {
+			headLen = keep / 2
+		}
+		text = string(runes[:headLen]) + truncationMarker + string(runes[len(runes)-(keep-headLen):])
+		tokens = countTokens(tokenizer, text)
+	}
+
+	return text
+}
+
+// truncateLongestMessage cuts excess tokens out of the middle of the longest message
+func truncateLongestMessage(messages []Message, excess int, tokenizer tokenizers.Tokenizer) []Message {
+	longest, longestTokens := 0, 0
+	for idx := range messages {
+		if tokens := countMessageTokens(&messages[idx], tokenizer); tokens > longestTokens {
+			longest, longestTokens = idx, tokens
+		}
+	}
+
+	result := copyMessages(messages)
+	budget := longestTokens - messageTokensOverhead - excess
+	if budget <= 0 {
+		return result
+	}
+	result[longest].Content = truncateText(result[longest].Content, budget, true, tokenizer)
+
+	return result
+}
+
+// dropOldestMessages removes messages after the system prompt, starting from the oldest
+// one, until the rest fits into the budget, the last message is always kept
+func dropOldestMessages(messages []Message, budget int, tokenizer tokenizers.Tokenizer) []Message {
+	first := 0
+	if messages[0].Role == ChatRoleSystem {
+		first = 1
+	}
+
+	tokens := make([]int, len(messages))
+	total := 0
+	for idx := range messages {
+		tokens[idx] = countMessageTokens(&messages[idx], tokenizer)
+		total += tokens[idx]
+	}
+
+	dropped := first
+	for total > budget && dropped < len(messages)-1 {
+		total -= tokens[dropped]
+		dropped++
+	}
+
+	result := copyMessages(messages[:first])
+	return append(result, copyMessages(messages[dropped:])...)
+}
+
+func copyMessages(messages []Message) []Message {
+	result := make([]Message, len(messages))
+	for idx := range messages {
+		result[idx] = Message{
+			ID:       messages[idx].ID,
+			ReplyTo:  messages[idx].ReplyTo,
+			MetaInfo: messages[idx].MetaInfo,
+			Role:     messages[idx].Role,
+			Content:  messages[idx].Content,
+			Logprobs: messages[idx].Logprobs,
+		}
+	}
+
+	return result
+}
+
+// isContextLengthExceeded checks backend's error response for
+// the messages different servers use for oversized prompts
+func isContextLengthExceeded(body string) bool {
+	body = strings.ToLower(body)
+	for _, marker := range []string{
+		"context length",
+		"context_length_exceeded",
+		"maximum context",
+		"context window",
+		"exceeds the available context size",
+		"prompt is too long",
+	} {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backendHttpError makes an error of backend's non-200 response, wrapping
+// ErrContextLengthExceeded, if backend complains about the prompt's length
+func backendHttpError(url string, statusCode int, body string) error {
+	if isContextLengthExceeded(body) {
+		return fmt.Errorf("error sending request to %s, http code is %d: %w: %s",
+			url, statusCode, ErrContextLengthExceeded, body)
+	}
+
+	return fmt.Errorf("error sending request to %s, http code is %d: %s", url, statusCode, body)
+}
@@ -0,0 +1,51 @@
+package engines
+
+import (
+	"errors"
+	"github.com/d0rc/agent-os/tokenizers"
+	"strings"
+	"testing"
+)
+
+func TestFitContext(t *testing.T) {
+	longText := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 100)
+
+	req := &GenerationSettings{RawPrompt: longText, SamplingParams: SamplingParams{MaxTokens: 16}}
+	err := FitContext(req, 128, TruncationReject, tokenizers.GPT2())
+	contextErr := &ContextLengthError{}
+	if !errors.As(err, &contextErr) || !errors.Is(err, ErrContextLengthExceeded) {
+		t.Fatalf("expected context length error, got: %v", err)
+	}
+	if contextErr.ContextLength != 128 || contextErr.MaxTokens != 16 || contextErr.PromptTokens <= 112 {
+		t.Fatalf("unexpected error details: %+v", contextErr)
+	}
+
+	req = &GenerationSettings{RawPrompt: "start " + longText + " end", SamplingParams: SamplingParams{MaxTokens: 16}}
+	if err = FitContext(req, 128, TruncationMiddle, tokenizers.GPT2()); err != nil {
+		t.Fatalf("truncate-middle failed: %v", err)
+	}
+	if !strings.HasPrefix(req.RawPrompt, "start") || !strings.HasSuffix(req.RawPrompt, "end") ||
+		!strings.Contains(req.RawPrompt, truncationMarker) {
+		t.Fatalf("middle of the prompt should be cut: %s", req.RawPrompt)
+	}
+	if req.ContextTokens == 0 || req.ContextTokens > 128 {
+		t.Fatalf("unexpected context tokens: %d", req.ContextTokens)
+	}
+
+	mediumText := strings.Repeat("the quick brown fox jumps over the lazy dog. ", 30)
+	req = &GenerationSettings{
+		Messages: []Message{
+			{Role: ChatRoleSystem, Content: "you are a helpful assistant"},
+			{Role: ChatRoleUser, Content: mediumText},
+			{Role: ChatRoleAssistant, Content: mediumText},
+			{Role: ChatRoleUser, Content: "what's next?"},
+		},
+	}
+	if err = FitContext(req, 1024, TruncationOldestMessages, tokenizers.GPT2()); err != nil {
+		t.Fatalf("truncate-oldest-messages failed: %v", err)
+	}
+	if len(req.Messages) != 3 || req.Messages[0].Role != ChatRoleSystem ||
+		req.Messages[1].Role != ChatRoleAssistant || req.Messages[2].Content != "what's next?" {
+		t.Fatalf("oldest message should be dropped: %+v", req.Messages)
+	}
+}
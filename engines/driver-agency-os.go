@@ -0,0 +1,371 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ProtocolAgencyOS is used to register remote AgencyOS server as a compute node,
+// requests are forwarded to peer's own compute router
+const ProtocolAgencyOS = "http-agency-os"
+
+// it's the same as borrow_engine.PRIO_Background, peers are
+// expected to serve our jobs only using their spare capacity
+const agencyOSPeerPriority = 3
+const agencyOSPeerProcessName = "federation"
+const agencyOSCapacityTimeout = 5 * time.Second
+
+// peer's capacity is reused for a while, so batches don't wait for an extra round trip
+const agencyOSCapacityTTL = 2 * time.Second
+
+// ErrNoSpareCapacity is returned if peer is busy, or didn't answer the capacity probe, in time,
+// peer didn't run the batch, so it's not counted as peer's failure
+var ErrNoSpareCapacity = errors.New("peer has no spare capacity")
+
+type agencyOSDriver struct {
+	capacitiesLock sync.Mutex
+	capacities     map[string]*cachedAgencyOSCapacity // by peer's endpoint url
+}
+
+type cachedAgencyOSCapacity struct {
+	capacity  *agencyOSCapacity
+	fetchedAt time.Time
+}
+
+func init() {
+	RegisterDriver(ProtocolAgencyOS, &agencyOSDriver{
+		capacities: make(map[string]*cachedAgencyOSCapacity),
+	})
+}
+
+// wire format mirrors cmds.ClientRequest and cmds.ServerResponse,
+// we can't use these directly, since cmds depends on engines
+type agencyOSCompletionRequest struct {
+	Model        string    `json:"model-mask"`
+	RawPrompt    string    `json:"raw-prompt"`
+	Temperature  float32   `json:"temperature"`
+	StopTokens   []string  `json:"stop-tokens"`
+	MinResults   int       `json:"min-results"`
+	BestOf       int       `json:"best-of"`
+	NoFederation bool      `json:"no-federation"`
+	Grammar      string    `json:"grammar"`
+	Messages     []Message `json:"messages,omitempty"`
+	CachePolicy  string    `json:"cache-policy,omitempty"`
+	Truncation   string    `json:"truncation,omitempty"`
+	SamplingParams
+}
+
+type agencyOSEmbeddingsRequest struct {
+	Model        string `json:"model-mask"`
+	RawPrompt    string `json:"raw-prompt"`
+	NoFederation bool   `json:"no-federation"`
+}
+
+type agencyOSClientRequest struct {
+	ProcessName           string                      `json:"process-name"`
+	Priority              int                         `json:"priority"`
+	GetCompletionRequests []agencyOSCompletionRequest `json:"get-completion-requests,omitempty"`
+	GetEmbeddingsRequests []agencyOSEmbeddingsRequest `json:"get-embeddings-requests,omitempty"`
+	GetComputeCapacity    *struct{}                   `json:"get-compute-capacity,omitempty"`
+}
+
+type agencyOSCapacity struct {
+	Advertised     bool     `json:"advertised"`
+	FreeSlots      int      `json:"free-slots"`
+	TotalSlots     int      `json:"total-slots"`
+	QueuedJobs     int      `json:"queued-jobs"`
+	Models         []string `json:"models"`
+	EmbeddingsDims uint64   `json:"embeddings-dims"`
+}
+
+type agencyOSServerResponse struct {
+	GetCompletionResponse []*struct {
+		Choices         []string            `json:"choices"`
+		ChoicesLogprobs [][]TokenLogprob    `json:"choices-logprobs"`
+		ContextError    *ContextLengthError `json:"context-length-error"`
+		Usage           *struct {
+			PromptTokens     int    `json:"prompt-tokens"`
+			CompletionTokens int    `json:"completion-tokens"`
+			Model            string `json:"model"`
+		} `json:"usage"`
+	} `json:"get-completion-response"`
+	GetEmbeddingsResponse []*struct {
+		Embeddings []float64 `json:"embeddings"`
+		Model      string    `json:"model"`
+	} `json:"get-embeddings-response"`
+	ComputeCapacity *agencyOSCapacity `json:"compute-capacity"`
+}
+
+func runAgencyOSRequest(inferenceEngine *RemoteInferenceEngine, req *agencyOSClientRequest, timeout time.Duration) (*agencyOSServerResponse, error) {
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest("POST", inferenceEngine.EndpointUrl, bytes.NewBuffer(reqJson))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if inferenceEngine.Token != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", inferenceEngine.Token))
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error sending request to peer, http code is %d", resp.StatusCode)
+	}
+
+	parsedResponse := &agencyOSServerResponse{}
+	err = json.Unmarshal(result, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedResponse, nil
+}
+
+func getAgencyOSCapacity(inferenceEngine *RemoteInferenceEngine) (*agencyOSCapacity, error) {
+	resp, err := runAgencyOSRequest(inferenceEngine, &agencyOSClientRequest{
+		ProcessName:        agencyOSPeerProcessName,
+		Priority:           agencyOSPeerPriority,
+		GetComputeCapacity: &struct{}{},
+	}, agencyOSCapacityTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ComputeCapacity == nil {
+		return nil, fmt.Errorf("peer %s returned no capacity information", inferenceEngine.EndpointUrl)
+	}
+
+	return resp.ComputeCapacity, nil
+}
+
+// getCapacity asks the peer for its capacity and remembers the answer
+func (d *agencyOSDriver) getCapacity(inferenceEngine *RemoteInferenceEngine) (*agencyOSCapacity, error) {
+	capacity, err := getAgencyOSCapacity(inferenceEngine)
+	if err != nil {
+		return nil, err
+	}
+
+	d.capacitiesLock.Lock()
+	d.capacities[inferenceEngine.EndpointUrl] = &cachedAgencyOSCapacity{
+		capacity:  capacity,
+		fetchedAt: time.Now(),
+	}
+	d.capacitiesLock.Unlock()
+
+	return capacity, nil
+}
+
+// cachedCapacity returns capacity fetched less than agencyOSCapacityTTL ago,
+// or asks the peer for a fresh one
+func (d *agencyOSDriver) cachedCapacity(inferenceEngine *RemoteInferenceEngine) (*agencyOSCapacity, error) {
+	d.capacitiesLock.Lock()
+	cached, exists := d.capacities[inferenceEngine.EndpointUrl]
+	d.capacitiesLock.Unlock()
+	if exists && time.Since(cached.fetchedAt) < agencyOSCapacityTTL {
+		return cached.capacity, nil
+	}
+
+	return d.getCapacity(inferenceEngine)
+}
+
+func (d *agencyOSDriver) checkSpareCapacity(inferenceEngine *RemoteInferenceEngine) error {
+	capacity, err := d.cachedCapacity(inferenceEngine)
+	if err != nil {
+		return fmt.Errorf("%w: capacity probe of %s failed: %v", ErrNoSpareCapacity, inferenceEngine.EndpointUrl, err)
+	}
+
+	if !capacity.Advertised || capacity.FreeSlots == 0 {
+		return fmt.Errorf("%w: peer %s, queued jobs: %d",
+			ErrNoSpareCapacity, inferenceEngine.EndpointUrl, capacity.QueuedJobs)
+	}
+
+	return nil
+}
+
+func (d *agencyOSDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	if err := d.checkSpareCapacity(inferenceEngine); err != nil {
+		return nil, err
+	}
+
+	req := &agencyOSClientRequest{
+		ProcessName:           agencyOSPeerProcessName,
+		Priority:              agencyOSPeerPriority,
+		GetCompletionRequests: make([]agencyOSCompletionRequest, len(batch)),
+	}
+	for idx, job := range batch {
+		// jobs falling back to any model carry "*", peer should run the model we've matched
+		modelName, _ := inferenceEngine.ResolveModel(job.Req.Model)
+		if modelName == "" {
+			modelName = job.Req.Model
+		}
+		req.GetCompletionRequests[idx] = agencyOSCompletionRequest{
+			Model:          modelName,
+			RawPrompt:      job.Req.RawPrompt,
+			Temperature:    job.Req.Temperature,
+			StopTokens:     job.Req.StopTokens,
+			MinResults:     1,
+			BestOf:         job.Req.BestOf,
+			NoFederation:   true,
+			Grammar:        job.Req.Grammar,
+			Messages:       job.Req.Messages,
+			CachePolicy:    job.Req.CachePolicy,
+			Truncation:     string(job.Req.Truncation),
+			SamplingParams: job.Req.SamplingParams,
+		}
+	}
+
+	resp, err := runAgencyOSRequest(inferenceEngine, req, InferenceTimeout)
+	if err != nil {
+		zlog.Error().Err(err).
+			Msgf("completion: error forwarding batch to peer %s", inferenceEngine.EndpointUrl)
+		return nil, err
+	}
+
+	if len(resp.GetCompletionResponse) != len(batch) {
+		return nil, fmt.Errorf("peer %s returned %d completions for batch of %d",
+			inferenceEngine.EndpointUrl, len(resp.GetCompletionResponse), len(batch))
+	}
+
+	results := make([]*Message, len(batch))
+	for idx, completion := range resp.GetCompletionResponse {
+		if completion != nil && completion.ContextError != nil {
+			// peer's nodes are smaller than ours, retrying won't help
+			return nil, completion.ContextError
+		}
+		if completion == nil || len(completion.Choices) == 0 {
+			return nil, fmt.Errorf("peer %s returned no choices for job %d", inferenceEngine.EndpointUrl, idx)
+		}
+	}
+
+	for idx, job := range batch {
+		// freshly generated choice is always the last one
+		choices := resp.GetCompletionResponse[idx].Choices
+		results[idx] = &Message{
+			Role:    ChatRoleAssistant,
+			Content: choices[len(choices)-1],
+		}
+		if choicesLogprobs := resp.GetCompletionResponse[idx].ChoicesLogprobs; len(choicesLogprobs) == len(choices) {
+			results[idx].Logprobs = choicesLogprobs[len(choices)-1]
+		}
+		if usage := resp.GetCompletionResponse[idx].Usage; usage != nil {
+			job.Stats = &StatisticsInfo{
+				PromptTokens:    usage.PromptTokens,
+				TokensGenerated: usage.CompletionTokens,
+				Model:           usage.Model,
+			}
+		}
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *agencyOSDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	if err := d.checkSpareCapacity(inferenceEngine); err != nil {
+		return nil, err
+	}
+
+	req := &agencyOSClientRequest{
+		ProcessName:           agencyOSPeerProcessName,
+		Priority:              agencyOSPeerPriority,
+		GetEmbeddingsRequests: make([]agencyOSEmbeddingsRequest, len(batch)),
+	}
+	for idx, job := range batch {
+		// peer's embeddings cache is keyed by the exact model name
+		modelName, _ := inferenceEngine.ResolveModel(job.Req.Model)
+		req.GetEmbeddingsRequests[idx] = agencyOSEmbeddingsRequest{
+			Model:        modelName,
+			RawPrompt:    job.Req.RawPrompt,
+			NoFederation: true,
+		}
+	}
+
+	resp, err := runAgencyOSRequest(inferenceEngine, req, InferenceTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.GetEmbeddingsResponse) != len(batch) {
+		return nil, fmt.Errorf("peer %s returned %d embeddings for batch of %d",
+			inferenceEngine.EndpointUrl, len(resp.GetEmbeddingsResponse), len(batch))
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	for idx, embeddings := range resp.GetEmbeddingsResponse {
+		if embeddings == nil || len(embeddings.Embeddings) == 0 {
+			return nil, fmt.Errorf("peer %s returned no embeddings for job %d", inferenceEngine.EndpointUrl, idx)
+		}
+	}
+
+	for idx, job := range batch {
+		parsedModelName := parseModelName(resp.GetEmbeddingsResponse[idx].Model)
+		results[idx] = &vectors.Vector{
+			VecF64: resp.GetEmbeddingsResponse[idx].Embeddings,
+			Model:  &parsedModelName,
+		}
+		if job.ResEmbeddings != nil {
+			job.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+// ListModels doesn't need to probe the peer with inference requests,
+// peer reports its models and embeddings dimensions along with its capacity
+func (d *agencyOSDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	capacity, err := d.getCapacity(inferenceEngine)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]*ModelInfo, 0, len(capacity.Models))
+	for _, model := range capacity.Models {
+		models = append(models, &ModelInfo{
+			Name:           parseModelName(model),
+			EmbeddingsDims: capacity.EmbeddingsDims,
+			Completion:     true,
+			Embeddings:     capacity.EmbeddingsDims > 0,
+		})
+	}
+
+	return models, nil
+}
+
+func (d *agencyOSDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	_, err := d.getCapacity(inferenceEngine)
+	return err
+}
+
+func (d *agencyOSDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *agencyOSDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	return "", ErrNotSupported
+}
@@ -0,0 +1,73 @@
+package engines
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// AgencyOS peer stand-in, it records cache policies of the forwarded requests
+func newAgencyOSStandIn(t *testing.T, freeSlots int, cachePolicies *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &agencyOSClientRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			t.Errorf("bad peer request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response := map[string]interface{}{}
+		if req.GetComputeCapacity != nil {
+			response["compute-capacity"] = &agencyOSCapacity{Advertised: true, FreeSlots: freeSlots, TotalSlots: 1}
+		}
+		completions := make([]interface{}, 0, len(req.GetCompletionRequests))
+		for _, completion := range req.GetCompletionRequests {
+			*cachePolicies = append(*cachePolicies, completion.CachePolicy)
+			completions = append(completions, map[string]interface{}{"choices": []string{"peer's answer"}})
+		}
+		response["get-completion-response"] = completions
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestAgencyOSDriverForwardsCachePolicy(t *testing.T) {
+	cachePolicies := make([]string, 0)
+	server := newAgencyOSStandIn(t, 1, &cachePolicies)
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: ProtocolAgencyOS}
+	batch := []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "a"}},
+		{Req: &GenerationSettings{RawPrompt: "b", NoCache: true, CachePolicy: "bypass"}},
+		{Req: &GenerationSettings{RawPrompt: "c", NoCache: true, CachePolicy: "refresh"}},
+	}
+	if _, err := RunCompletionRequest(engine, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cachePolicies) != 3 || cachePolicies[0] != "" || cachePolicies[1] != "bypass" || cachePolicies[2] != "refresh" {
+		t.Fatalf("peer should get caller's cache policies, got %v", cachePolicies)
+	}
+}
+
+func TestAgencyOSDriverReportsNoSpareCapacity(t *testing.T) {
+	cachePolicies := make([]string, 0)
+	server := newAgencyOSStandIn(t, 0, &cachePolicies)
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: ProtocolAgencyOS}
+	_, err := RunCompletionRequest(engine, []*JobQueueTask{{Req: &GenerationSettings{RawPrompt: "a"}}})
+	if !errors.Is(err, ErrNoSpareCapacity) || len(cachePolicies) != 0 {
+		t.Fatalf("busy peer shouldn't get the batch, got %v", err)
+	}
+
+	// capacity is cached by url, so the probe goes to another peer, which is down
+	down := newAgencyOSStandIn(t, 1, &cachePolicies)
+	down.Close()
+	engine = &RemoteInferenceEngine{EndpointUrl: down.URL, Protocol: ProtocolAgencyOS}
+	if _, err = RunCompletionRequest(engine, []*JobQueueTask{{Req: &GenerationSettings{RawPrompt: "a"}}}); !errors.Is(err, ErrNoSpareCapacity) {
+		t.Fatalf("failed capacity probe should be reported as no spare capacity, got %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ProtocolLlamaCpp talks to llama.cpp `server` natively, endpoint is the root URL
+// of the server, i.e. http://localhost:8080
+const ProtocolLlamaCpp = "http-llama-cpp"
+
+const llamaCppMaxTokens = 4096
+
+type llamaCppDriver struct{}
+
+func init() {
+	RegisterDriver(ProtocolLlamaCpp, &llamaCppDriver{})
+}
+
+type llamaCppCompletionRequest struct {
+	Prompt           string   `json:"prompt"`
+	NPredict         int      `json:"n_predict"`
+	Temperature      float32  `json:"temperature"`
+	Stop             []string `json:"stop,omitempty"`
+	CachePrompt      bool     `json:"cache_prompt"`
+	Grammar          string   `json:"grammar,omitempty"`
+	NProbs           int      `json:"n_probs,omitempty"`
+	TopP             float32  `json:"top_p,omitempty"`
+	TopK             int      `json:"top_k,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+}
+
+type llamaCppCompletionResponse struct {
+	Content                 string                `json:"content"`
+	Model                   string                `json:"model"`
+	TokensPredicted         int                   `json:"tokens_predicted"`
+	TokensEvaluated         int                   `json:"tokens_evaluated"`
+	CompletionProbabilities llamaCppProbabilities `json:"completion_probabilities"`
+}
+
+func llamaCppBaseUrl(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	for _, suffix := range []string{"/completion", "/embedding", "/v1/completions"} {
+		endpoint = strings.TrimSuffix(endpoint, suffix)
+	}
+
+	return endpoint
+}
+
+func (d *llamaCppDriver) doRequest(inferenceEngine *RemoteInferenceEngine, method, path string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqJson, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(reqJson)
+	}
+
+	url := path
+	if strings.HasPrefix(path, "/") {
+		url = llamaCppBaseUrl(inferenceEngine.EndpointUrl) + path
+	}
+
+	httpReq, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if inferenceEngine.Token != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", inferenceEngine.Token))
+	}
+
+	client := http.Client{Timeout: InferenceTimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return backendHttpError(url, resp.StatusCode, string(data))
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// RunCompletion sends every job of the batch as a separate request, llama.cpp
+// server processes them in parallel, as long as it has free slots
+func (d *llamaCppDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	results := make([]*Message, len(batch))
+	errs := make([]error, len(batch))
+	wg := sync.WaitGroup{}
+	for idx, job := range batch {
+		wg.Add(1)
+		go func(idx int, job *JobQueueTask) {
+			defer wg.Done()
+			prompt, stopTokens := inferenceEngine.RenderPrompt(job.Req)
+			parsedResponse := &llamaCppCompletionResponse{}
+			errs[idx] = d.doRequest(inferenceEngine, "POST", "/completion", &llamaCppCompletionRequest{
+				Prompt:           prompt,
+				NPredict:         job.Req.maxTokensOr(llamaCppMaxTokens),
+				Temperature:      job.Req.Temperature,
+				Stop:             stopTokens,
+				CachePrompt:      true,
+				Grammar:          job.Req.Grammar,
+				NProbs:           job.Req.Logprobs,
+				TopP:             job.Req.TopP,
+				TopK:             job.Req.TopK,
+				Seed:             job.Req.Seed,
+				PresencePenalty:  job.Req.PresencePenalty,
+				FrequencyPenalty: job.Req.FrequencyPenalty,
+			}, parsedResponse)
+			if errs[idx] != nil {
+				return
+			}
+
+			results[idx] = &Message{
+				Role:     ChatRoleAssistant,
+				Content:  parsedResponse.Content,
+				Logprobs: parsedResponse.CompletionProbabilities.toTokenLogprobs(),
+			}
+			job.Stats = &StatisticsInfo{
+				PromptTokens:    parsedResponse.TokensEvaluated,
+				TokensGenerated: parsedResponse.TokensPredicted,
+			}
+		}(idx, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			zlog.Error().Err(err).
+				Msgf("completion: error running batch on %s", inferenceEngine.EndpointUrl)
+			return nil, err
+		}
+	}
+
+	for idx, job := range batch {
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *llamaCppDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	url := "/embedding"
+	if inferenceEngine.EmbeddingsEndpointUrl != "" {
+		url = inferenceEngine.EmbeddingsEndpointUrl
+	}
+
+	// llama.cpp server runs a single model
+	modelName, _ := inferenceEngine.ResolveModel("")
+
+	type embeddingRequest struct {
+		Content string `json:"content"`
+	}
+	type embeddingResponse struct {
+		Embedding []float64 `json:"embedding"`
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	errs := make([]error, len(batch))
+	wg := sync.WaitGroup{}
+	for idx, job := range batch {
+		wg.Add(1)
+		go func(idx int, job *JobQueueTask) {
+			defer wg.Done()
+			parsedResponse := &embeddingResponse{}
+			errs[idx] = d.doRequest(inferenceEngine, "POST", url, &embeddingRequest{
+				Content: job.Req.RawPrompt,
+			}, parsedResponse)
+			if errs[idx] == nil && len(parsedResponse.Embedding) == 0 {
+				errs[idx] = fmt.Errorf("no embeddings in response, is server started with --embedding?")
+			}
+			if errs[idx] != nil {
+				return
+			}
+
+			results[idx] = &vectors.Vector{
+				VecF64: parsedResponse.Embedding,
+				Model:  &modelName,
+			}
+		}(idx, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for idx, job := range batch {
+		if job.ResEmbeddings != nil {
+			job.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+// ListModels reads server's /props, it also records number of server's slots,
+// context length reported is the one of a single slot
+func (d *llamaCppDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	type propsResponse struct {
+		DefaultGenerationSettings struct {
+			Model string `json:"model"`
+			NCtx  int    `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+		TotalSlots int `json:"total_slots"`
+	}
+
+	props := &propsResponse{}
+	err := d.doRequest(inferenceEngine, "GET", "/props", nil, props)
+	if err != nil {
+		return nil, err
+	}
+
+	inferenceEngine.Slots = props.TotalSlots
+	if props.DefaultGenerationSettings.Model == "" {
+		return nil, nil
+	}
+
+	return []*ModelInfo{
+		{
+			Name:          parseModelName(props.DefaultGenerationSettings.Model),
+			ContextLength: props.DefaultGenerationSettings.NCtx,
+			Completion:    true,
+		},
+	}, nil
+}
+
+func (d *llamaCppDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	parsedResponse := &llamaCppCompletionResponse{}
+	return d.doRequest(inferenceEngine, "POST", "/completion", &llamaCppCompletionRequest{
+		Prompt:      "2 + 2 =",
+		NPredict:    4,
+		Temperature: 0.1,
+		Stop:        []string{"\n"},
+	}, parsedResponse)
+}
+
+func (d *llamaCppDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	type tokenizeRequest struct {
+		Content string `json:"content"`
+	}
+	type tokenizeResponse struct {
+		Tokens []int `json:"tokens"`
+	}
+
+	parsedResponse := &tokenizeResponse{}
+	err := d.doRequest(inferenceEngine, "POST", "/tokenize", &tokenizeRequest{
+		Content: text,
+	}, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedResponse.Tokens, nil
+}
+
+func (d *llamaCppDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	type detokenizeRequest struct {
+		Tokens []int `json:"tokens"`
+	}
+	type detokenizeResponse struct {
+		Content string `json:"content"`
+	}
+
+	parsedResponse := &detokenizeResponse{}
+	err := d.doRequest(inferenceEngine, "POST", "/detokenize", &detokenizeRequest{
+		Tokens: tokens,
+	}, parsedResponse)
+	if err != nil {
+		return "", err
+	}
+
+	return parsedResponse.Content, nil
+}
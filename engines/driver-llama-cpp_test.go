@@ -0,0 +1,134 @@
+package engines
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// llama.cpp server stand-in, tokens are the words' indices in the vocabulary
+func newLlamaCppStandIn(t *testing.T, vocabulary []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/props":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"default_generation_settings": map[string]interface{}{
+					"model": "/models/TheBloke/dolphin-2.2.1-mistral-7B-GGUF/dolphin-2.2.1-mistral-7b.Q6_K.gguf",
+					"n_ctx": 2048,
+				},
+				"total_slots": 4,
+			})
+		case "/completion":
+			req := &llamaCppCompletionRequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				t.Errorf("bad completion request: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if req.Grammar != "" && !req.CachePrompt {
+				t.Errorf("prompt of the job should be cached in server's slot")
+			}
+			response := map[string]interface{}{
+				"content":          "grammar=" + req.Grammar + ": " + req.Prompt,
+				"tokens_predicted": 3,
+				"tokens_evaluated": 7,
+			}
+			if req.NProbs > 0 {
+				response["completion_probabilities"] = []interface{}{
+					map[string]interface{}{"content": "yes", "probs": []interface{}{
+						map[string]interface{}{"tok_str": "yes", "prob": 0.75},
+						map[string]interface{}{"tok_str": "no", "prob": 0.25},
+					}},
+				}
+			}
+			_ = json.NewEncoder(w).Encode(response)
+		case "/tokenize":
+			req := map[string]string{}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			tokens := make([]int, 0)
+			for _, word := range strings.Fields(req["content"]) {
+				for idx, known := range vocabulary {
+					if known == word {
+						tokens = append(tokens, idx)
+					}
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens})
+		case "/detokenize":
+			req := map[string][]int{}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			words := make([]string, 0, len(req["tokens"]))
+			for _, token := range req["tokens"] {
+				words = append(words, vocabulary[token])
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"content": strings.Join(words, " ")})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestLlamaCppDriver(t *testing.T) {
+	vocabulary := []string{"Hello", "world", "again"}
+	server := newLlamaCppStandIn(t, vocabulary)
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{
+		EndpointUrl: server.URL + "/completion",
+		Protocol:    ProtocolLlamaCpp,
+	}
+	done := make(chan struct{}, 1)
+	StartInferenceEngine(engine, done)
+	<-done
+
+	if engine.CompletionFailed {
+		t.Fatalf("engine failed to start: %+v", engine)
+	}
+	if !engine.EmbeddingsFailed {
+		t.Fatalf("stand-in isn't started with --embedding")
+	}
+	if engine.Slots != 4 {
+		t.Fatalf("expected 4 slots from /props, got %d", engine.Slots)
+	}
+	if engine.ContextLength != 2048 {
+		t.Fatalf("expected context length of 2048 from /props, got %d", engine.ContextLength)
+	}
+	if models := engine.GetModels(); len(models) != 1 || models[0] != "TheBloke/dolphin-2.2.1-mistral-7B-GGUF" {
+		t.Fatalf("unexpected models from /props: %v", models)
+	}
+	if !engine.CanTokenize {
+		t.Fatalf("engine should be able to tokenize")
+	}
+
+	job := &JobQueueTask{Req: &GenerationSettings{
+		RawPrompt:      "Is 2 + 2 = 4?",
+		Grammar:        `root ::= "yes" | "no"`,
+		SamplingParams: SamplingParams{Logprobs: 2},
+	}}
+	results, err := RunCompletionRequest(engine, []*JobQueueTask{job})
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+	if results[0].Content != `grammar=root ::= "yes" | "no": Is 2 + 2 = 4?` {
+		t.Fatalf("unexpected completion: %s", results[0].Content)
+	}
+	if len(results[0].Logprobs) != 1 || results[0].Logprobs[0].Token != "yes" ||
+		len(results[0].Logprobs[0].TopLogprobs) != 2 {
+		t.Fatalf("unexpected logprobs: %+v", results[0].Logprobs)
+	}
+	if job.Stats == nil || job.Stats.PromptTokens != 7 || job.Stats.TokensGenerated != 3 {
+		t.Fatalf("usage is not reported: %+v", job.Stats)
+	}
+
+	tokens, err := RunTokenizeRequest(engine, "", "Hello world again")
+	if err != nil || len(tokens) != 3 || tokens[2] != 2 {
+		t.Fatalf("unexpected tokens: %v, %v", tokens, err)
+	}
+	driver, _ := GetDriver(ProtocolLlamaCpp)
+	text, err := driver.Detokenize(engine, "", []int{2, 0})
+	if err != nil || text != "again Hello" {
+		t.Fatalf("unexpected text: %q, %v", text, err)
+	}
+}
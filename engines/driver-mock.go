@@ -0,0 +1,114 @@
+package engines
+
+import (
+	mock_inference "github.com/d0rc/agent-os/mock-inference"
+	"github.com/d0rc/agent-os/vectors"
+	"sync"
+)
+
+const ProtocolMock = "mock"
+
+// mockDriver runs jobs on the in-process fake engine, configured by `mock` section of the node
+type mockDriver struct {
+	engines sync.Map // *RemoteInferenceEngine -> *mock_inference.Engine
+}
+
+func init() {
+	RegisterDriver(ProtocolMock, &mockDriver{})
+}
+
+func (d *mockDriver) getEngine(inferenceEngine *RemoteInferenceEngine) *mock_inference.Engine {
+	engine, _ := d.engines.LoadOrStore(inferenceEngine, mock_inference.NewEngine(inferenceEngine.Mock))
+
+	return engine.(*mock_inference.Engine)
+}
+
+func (d *mockDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	model, err := inferenceEngine.RequestModel(batch[0].Req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	prompts := make([]string, len(batch))
+	var stopTokens []string
+	for idx, job := range batch {
+		var jobStopTokens []string
+		prompts[idx], jobStopTokens = inferenceEngine.RenderPrompt(job.Req)
+		if idx == 0 {
+			stopTokens = jobStopTokens
+		}
+	}
+
+	texts, err := d.getEngine(inferenceEngine).Complete(model, prompts, batch[0].Req.MaxTokens, stopTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Message, len(batch))
+	for idx, job := range batch {
+		results[idx] = &Message{
+			Role:    ChatRoleAssistant,
+			Content: texts[idx],
+		}
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *mockDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	model, err := inferenceEngine.RequestModel(batch[0].Req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make([]string, len(batch))
+	for idx, job := range batch {
+		inputs[idx] = job.Req.RawPrompt
+	}
+
+	embeddings, err := d.getEngine(inferenceEngine).Embed(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	for idx := range batch {
+		results[idx] = &vectors.Vector{
+			VecF64: embeddings[idx],
+			Model:  &model,
+		}
+	}
+
+	return results, nil
+}
+
+func (d *mockDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	engine := d.getEngine(inferenceEngine)
+	models := make([]*ModelInfo, 0, len(engine.Models()))
+	for _, model := range engine.Models() {
+		models = append(models, &ModelInfo{
+			Name:           model,
+			EmbeddingsDims: uint64(engine.EmbeddingsDims()),
+			ContextLength:  engine.ContextLength(),
+			Completion:     true,
+			Embeddings:     true,
+		})
+	}
+
+	return models, nil
+}
+
+func (d *mockDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	return nil
+}
+
+func (d *mockDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *mockDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	return "", ErrNotSupported
+}
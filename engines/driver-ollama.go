@@ -0,0 +1,318 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ProtocolOllama talks to Ollama's native API, endpoint is the root URL
+// of the server, i.e. http://localhost:11434
+const ProtocolOllama = "ollama"
+
+type ollamaDriver struct{}
+
+func init() {
+	RegisterDriver(ProtocolOllama, &ollamaDriver{})
+}
+
+// errOllamaModelNotFound is returned by Ollama for models which are not pulled yet
+var errOllamaModelNotFound = errors.New("model is not found, try `ollama pull`")
+
+type ollamaOptions struct {
+	Temperature      float32  `json:"temperature"`
+	Stop             []string `json:"stop,omitempty"`
+	NumPredict       int      `json:"num_predict,omitempty"`
+	TopP             float32  `json:"top_p,omitempty"`
+	TopK             int      `json:"top_k,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaGenerateRequest struct {
+	Model   string          `json:"model"`
+	Prompt  string          `json:"prompt,omitempty"`
+	Raw     bool            `json:"raw,omitempty"`
+	Format  string          `json:"format,omitempty"`
+	Stream  bool            `json:"stream"`
+	Options *ollamaOptions  `json:"options,omitempty"`
+	Chat    []ollamaMessage `json:"messages,omitempty"`
+}
+
+type ollamaGenerateResponse struct {
+	Model           string         `json:"model"`
+	Response        string         `json:"response"`
+	Message         *ollamaMessage `json:"message"`
+	PromptEvalCount int            `json:"prompt_eval_count"`
+	EvalCount       int            `json:"eval_count"`
+}
+
+func ollamaBaseUrl(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if idx := strings.Index(endpoint, "/api/"); idx != -1 {
+		return endpoint[:idx]
+	}
+
+	return endpoint
+}
+
+func (d *ollamaDriver) doRequest(inferenceEngine *RemoteInferenceEngine, method, path string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqJson, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(reqJson)
+	}
+
+	httpReq, err := http.NewRequest(method, ollamaBaseUrl(inferenceEngine.EndpointUrl)+path, reqBody)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: InferenceTimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound && strings.Contains(string(data), "not found") {
+		return fmt.Errorf("%w: %s", errOllamaModelNotFound, string(data))
+	}
+
+	if resp.StatusCode != 200 {
+		return backendHttpError(path, resp.StatusCode, string(data))
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// refreshModels is called once Ollama says model is not found,
+// it might have been removed, or other models pulled since we've listed them
+func (d *ollamaDriver) refreshModels(inferenceEngine *RemoteInferenceEngine) {
+	if err := RefreshModels(inferenceEngine); err != nil {
+		zlog.Error().Err(err).Str("url", inferenceEngine.EndpointUrl).Msg("failed to refresh ollama models")
+	}
+}
+
+func (d *ollamaDriver) runCompletionJob(inferenceEngine *RemoteInferenceEngine, job *JobQueueTask) (*Message, error) {
+	model, err := inferenceEngine.RequestModel(job.Req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &ollamaGenerateRequest{
+		Model:  model,
+		Stream: false,
+		Options: &ollamaOptions{
+			Temperature:      job.Req.Temperature,
+			Stop:             job.Req.StopTokens,
+			NumPredict:       job.Req.MaxTokens,
+			TopP:             job.Req.TopP,
+			TopK:             job.Req.TopK,
+			Seed:             job.Req.Seed,
+			PresencePenalty:  job.Req.PresencePenalty,
+			FrequencyPenalty: job.Req.FrequencyPenalty,
+		},
+	}
+	if job.Req.Grammar != "" {
+		// Ollama can't use custom grammars, but it can at least guarantee JSON
+		req.Format = "json"
+	}
+
+	path := "/api/generate"
+	if len(job.Req.Messages) > 0 {
+		path = "/api/chat"
+		req.Chat = make([]ollamaMessage, len(job.Req.Messages))
+		for idx := range job.Req.Messages {
+			req.Chat[idx] = ollamaMessage{
+				Role:    string(job.Req.Messages[idx].Role),
+				Content: job.Req.Messages[idx].Content,
+			}
+		}
+	} else {
+		// prompts are rendered by us, Ollama should not apply model's template
+		req.Prompt = job.Req.RawPrompt
+		req.Raw = true
+	}
+
+	parsedResponse := &ollamaGenerateResponse{}
+	err = d.doRequest(inferenceEngine, "POST", path, req, parsedResponse)
+	if err != nil {
+		if errors.Is(err, errOllamaModelNotFound) {
+			d.refreshModels(inferenceEngine)
+		}
+		return nil, err
+	}
+
+	content := parsedResponse.Response
+	if parsedResponse.Message != nil {
+		content = parsedResponse.Message.Content
+	}
+	if parsedResponse.EvalCount > 0 {
+		job.Stats = &StatisticsInfo{
+			PromptTokens:    parsedResponse.PromptEvalCount,
+			TokensGenerated: parsedResponse.EvalCount,
+			Model:           parsedResponse.Model,
+		}
+	}
+
+	return &Message{
+		Role:    ChatRoleAssistant,
+		Content: content,
+	}, nil
+}
+
+func (d *ollamaDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	results := make([]*Message, len(batch))
+	errs := make([]error, len(batch))
+	wg := sync.WaitGroup{}
+	for idx, job := range batch {
+		wg.Add(1)
+		go func(idx int, job *JobQueueTask) {
+			defer wg.Done()
+			results[idx], errs[idx] = d.runCompletionJob(inferenceEngine, job)
+		}(idx, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			zlog.Error().Err(err).
+				Msgf("completion: error running batch on %s", inferenceEngine.EndpointUrl)
+			return nil, err
+		}
+	}
+
+	for idx, job := range batch {
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *ollamaDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	type embeddingsRequest struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}
+	type embeddingsResponse struct {
+		Embedding []float64 `json:"embedding"`
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	for idx, job := range batch {
+		model, err := inferenceEngine.RequestModel(job.Req.Model)
+		if err != nil {
+			return nil, err
+		}
+
+		parsedResponse := &embeddingsResponse{}
+		err = d.doRequest(inferenceEngine, "POST", "/api/embeddings", &embeddingsRequest{
+			Model:  model,
+			Prompt: job.Req.RawPrompt,
+		}, parsedResponse)
+		if err != nil {
+			if errors.Is(err, errOllamaModelNotFound) {
+				d.refreshModels(inferenceEngine)
+			}
+			return nil, err
+		}
+
+		if len(parsedResponse.Embedding) == 0 {
+			return nil, fmt.Errorf("no embeddings returned by %s for model %s", inferenceEngine.EndpointUrl, model)
+		}
+
+		results[idx] = &vectors.Vector{
+			VecF64: parsedResponse.Embedding,
+			Model:  &model,
+		}
+	}
+
+	for idx, job := range batch {
+		if job.ResEmbeddings != nil {
+			job.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *ollamaDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	type tagsResponse struct {
+		Models []struct {
+			Name    string `json:"name"`
+			Details struct {
+				Family   string   `json:"family"`
+				Families []string `json:"families"`
+			} `json:"details"`
+		} `json:"models"`
+	}
+
+	parsedResponse := &tagsResponse{}
+	err := d.doRequest(inferenceEngine, "GET", "/api/tags", nil, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]*ModelInfo, 0, len(parsedResponse.Models))
+	for _, model := range parsedResponse.Models {
+		// Ollama computes embeddings with any model, but BERT-like ones can't generate text
+		families := append([]string{model.Details.Family}, model.Details.Families...)
+		completion := true
+		for _, family := range families {
+			if strings.Contains(strings.ToLower(family), "bert") {
+				completion = false
+			}
+		}
+		if model.Details.Family == "" {
+			completion = guessCapabilities(&ModelInfo{Name: model.Name}).Completion
+		}
+		models = append(models, &ModelInfo{
+			Name:       model.Name,
+			Completion: completion,
+			Embeddings: true,
+		})
+	}
+
+	return models, nil
+}
+
+func (d *ollamaDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	_, err := d.runCompletionJob(inferenceEngine, &JobQueueTask{
+		Req: &GenerationSettings{RawPrompt: "2 + 2 =", StopTokens: []string{"\n"}, MaxRetries: 1, Temperature: 0.1},
+	})
+
+	return err
+}
+
+func (d *ollamaDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *ollamaDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	return "", ErrNotSupported
+}
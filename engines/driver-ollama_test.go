@@ -0,0 +1,149 @@
+package engines
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newOllamaStandIn(t *testing.T, models []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			type tag struct {
+				Name string `json:"name"`
+			}
+			tags := make([]tag, len(models))
+			for idx, model := range models {
+				tags[idx] = tag{Name: model}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"models": tags})
+		case "/api/generate", "/api/chat", "/api/embeddings":
+			req := &ollamaGenerateRequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				t.Fatalf("bad request: %v", err)
+			}
+			known := false
+			for _, model := range models {
+				known = known || model == req.Model
+			}
+			if !known {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"model '` + req.Model + `' not found, try pulling it first"}`))
+				return
+			}
+
+			switch r.URL.Path {
+			case "/api/generate":
+				if !req.Raw {
+					t.Errorf("raw prompt expected for /api/generate")
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"model": req.Model, "response": req.Model + ": " + req.Prompt,
+					"prompt_eval_count": 5, "eval_count": 3})
+			case "/api/chat":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"model": req.Model, "message": map[string]string{
+					"role":    "assistant",
+					"content": req.Model + ": " + req.Chat[len(req.Chat)-1].Content,
+				}})
+			case "/api/embeddings":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"embedding": []float64{0.1, 0.2, 0.3}})
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestOllamaDriver(t *testing.T) {
+	models := []string{"mistral:latest", "llama2:13b", "nomic-embed-text:latest"}
+	server := newOllamaStandIn(t, models)
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{
+		EndpointUrl: server.URL,
+		Protocol:    ProtocolOllama,
+	}
+	done := make(chan struct{}, 1)
+	StartInferenceEngine(engine, done)
+	<-done
+
+	if engine.CompletionFailed || engine.EmbeddingsFailed {
+		t.Fatalf("engine failed to start: %+v", engine)
+	}
+	if len(engine.GetModels()) != 3 {
+		t.Fatalf("expected 3 models from tags, got %v", engine.GetModels())
+	}
+	if engine.EmbeddingsDims == nil || *engine.EmbeddingsDims != 3 {
+		t.Fatalf("expected embeddings dims to be detected")
+	}
+
+	if !engine.ServesModel("llama2") || !engine.ServesModel("*embed*") || engine.ServesModel("phi") {
+		t.Fatalf("model masks are not matched correctly")
+	}
+	if info := engine.GetModelInfo("nomic-embed-text:latest"); info == nil || info.Completion || !info.Embeddings {
+		t.Fatalf("embeddings model capabilities are not detected: %+v", info)
+	}
+	if info := engine.GetModelInfo("mistral:latest"); info == nil || !info.Completion {
+		t.Fatalf("completion model capabilities are not detected: %+v", info)
+	}
+	if engine.EmbeddingsModel() != "nomic-embed-text:latest" {
+		t.Fatalf("unexpected embeddings model: %s", engine.EmbeddingsModel())
+	}
+
+	var reportedUsage *StatisticsInfo
+	tasks := []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "hello", Model: "llama2", StatisticsCallback: func(info *StatisticsInfo) {
+			reportedUsage = info
+		}}},
+		{Req: &GenerationSettings{Messages: []Message{{Role: ChatRoleUser, Content: "hi"}}, Model: "mistral*"}},
+	}
+	results, err := RunCompletionRequest(engine, tasks)
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+	if results[0].Content != "llama2:13b: hello" || results[1].Content != "mistral:latest: hi" {
+		t.Fatalf("unexpected results: %s, %s", results[0].Content, results[1].Content)
+	}
+
+	// usage reported by the backend is used as is, otherwise tokens are counted
+	if reportedUsage == nil || reportedUsage.PromptTokens != 5 || reportedUsage.TokensGenerated != 3 ||
+		reportedUsage.TokensProcessed != 8 || reportedUsage.Model != "llama2:13b" {
+		t.Fatalf("unexpected usage reported: %+v", reportedUsage)
+	}
+	if tasks[1].Stats == nil || tasks[1].Stats.PromptTokens == 0 || tasks[1].Stats.TokensGenerated == 0 ||
+		tasks[1].Stats.Model != "mistral:latest" {
+		t.Fatalf("usage is not estimated: %+v", tasks[1].Stats)
+	}
+	if engine.TokensGenerated != uint64(3+tasks[1].Stats.TokensGenerated) {
+		t.Fatalf("engine's tokens generated is not updated: %d", engine.TokensGenerated)
+	}
+
+	embeddings, err := RunEmbeddingsRequest(engine, []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "hello", Model: "nomic-embed-text"}},
+	})
+	if err != nil || *embeddings[0].Model != "nomic-embed-text:latest" {
+		t.Fatalf("embeddings failed: %v", err)
+	}
+
+	// model removed from the server, driver should notice it
+	engine.SetModels(append(engine.GetModels(), "phi:latest"))
+	_, err = RunCompletionRequest(engine, []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "hello", Model: "phi"}},
+	})
+	if err == nil {
+		t.Fatalf("expected model not found error")
+	}
+	if engine.ServesModel("phi") {
+		t.Fatalf("models list is not refreshed after model not found error")
+	}
+
+	// model swapped on the server is picked up by refresh
+	models[0] = "phi:latest"
+	if err = RefreshModels(engine); err != nil {
+		t.Fatalf("failed to refresh models: %v", err)
+	}
+	if !engine.ServesModel("phi") || engine.ServesModel("mistral") {
+		t.Fatalf("swapped model is not picked up: %v", engine.GetModels())
+	}
+}
@@ -0,0 +1,203 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ProtocolOpenAIChat sends messages to OpenAI-compatible /v1/chat/completions,
+// chat template is applied by the backend itself
+const ProtocolOpenAIChat = "http-openai-chat"
+
+type openAIChatDriver struct{}
+
+func init() {
+	RegisterDriver(ProtocolOpenAIChat, &openAIChatDriver{})
+}
+
+type openAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChatRequest struct {
+	Model            string              `json:"model"`
+	Messages         []openAIChatMessage `json:"messages"`
+	Temperature      float32             `json:"temperature"`
+	Stop             []string            `json:"stop,omitempty"`
+	MaxTokens        int                 `json:"max_tokens,omitempty"`
+	N                int                 `json:"n"`
+	TopP             float32             `json:"top_p,omitempty"`
+	Seed             *int                `json:"seed,omitempty"`
+	PresencePenalty  float32             `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32             `json:"frequency_penalty,omitempty"`
+	Logprobs         bool                `json:"logprobs,omitempty"`
+	TopLogprobs      int                 `json:"top_logprobs,omitempty"`
+}
+
+func (d *openAIChatDriver) runChatJob(inferenceEngine *RemoteInferenceEngine, job *JobQueueTask, maxTokens int) (*Message, error) {
+	model, err := inferenceEngine.RequestModel(job.Req.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxTokens == 0 {
+		maxTokens = job.Req.MaxTokens
+	}
+
+	req := &openAIChatRequest{
+		Model:            model,
+		Temperature:      job.Req.Temperature,
+		Stop:             job.Req.StopTokens,
+		MaxTokens:        maxTokens,
+		N:                1,
+		TopP:             job.Req.TopP,
+		Seed:             job.Req.Seed,
+		PresencePenalty:  job.Req.PresencePenalty,
+		FrequencyPenalty: job.Req.FrequencyPenalty,
+		Logprobs:         job.Req.Logprobs > 0,
+		TopLogprobs:      job.Req.Logprobs,
+	}
+	if len(job.Req.Messages) > 0 {
+		req.Messages = make([]openAIChatMessage, len(job.Req.Messages))
+		for idx := range job.Req.Messages {
+			req.Messages[idx] = openAIChatMessage{
+				Role:    string(job.Req.Messages[idx].Role),
+				Content: job.Req.Messages[idx].Content,
+			}
+		}
+	} else {
+		// raw prompt is sent as it is, hoping the model can deal with it
+		req.Messages = []openAIChatMessage{
+			{Role: string(ChatRoleUser), Content: job.Req.RawPrompt},
+		}
+	}
+
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest("POST", inferenceEngine.EndpointUrl, bytes.NewBuffer(reqJson))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if inferenceEngine.Token != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", inferenceEngine.Token))
+	}
+
+	client := http.Client{Timeout: InferenceTimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, backendHttpError(inferenceEngine.EndpointUrl, resp.StatusCode, string(result))
+	}
+
+	type chatResponse struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message  openAIChatMessage   `json:"message"`
+			Logprobs *openAIChatLogprobs `json:"logprobs"`
+		} `json:"choices"`
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	parsedResponse := &chatResponse{}
+	err = json.Unmarshal(result, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parsedResponse.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in chat completion response")
+	}
+
+	if parsedResponse.Usage != nil {
+		job.Stats = &StatisticsInfo{
+			PromptTokens:    parsedResponse.Usage.PromptTokens,
+			TokensGenerated: parsedResponse.Usage.CompletionTokens,
+			Model:           parsedResponse.Model,
+		}
+	}
+
+	return &Message{
+		Role:     ChatRoleAssistant,
+		Content:  parsedResponse.Choices[0].Message.Content,
+		Logprobs: parsedResponse.Choices[0].Logprobs.toTokenLogprobs(),
+	}, nil
+}
+
+func (d *openAIChatDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	results := make([]*Message, len(batch))
+	errs := make([]error, len(batch))
+	wg := sync.WaitGroup{}
+	for idx, job := range batch {
+		wg.Add(1)
+		go func(idx int, job *JobQueueTask) {
+			defer wg.Done()
+			results[idx], errs[idx] = d.runChatJob(inferenceEngine, job, 0)
+		}(idx, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			zlog.Error().Err(err).
+				Msgf("completion: error running batch on %s", inferenceEngine.EndpointUrl)
+			return nil, err
+		}
+	}
+
+	for idx, job := range batch {
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *openAIChatDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	return runOpenAIEmbeddingsRequest(inferenceEngine, batch)
+}
+
+func (d *openAIChatDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	return listOpenAIModels(inferenceEngine)
+}
+
+func (d *openAIChatDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	_, err := d.runChatJob(inferenceEngine, &JobQueueTask{
+		Req: &GenerationSettings{
+			Messages:    []Message{{Role: ChatRoleUser, Content: "2 + 2 ="}},
+			Temperature: 0.1,
+		},
+	}, 4)
+
+	return err
+}
+
+func (d *openAIChatDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *openAIChatDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	return "", ErrNotSupported
+}
@@ -0,0 +1,461 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const ProtocolOpenAI = "http-openai"
+
+type openAIDriver struct{}
+
+func init() {
+	RegisterDriver(ProtocolOpenAI, &openAIDriver{})
+}
+
+func (d *openAIDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	client := http.Client{
+		Timeout: InferenceTimeout,
+	}
+
+	type commandList struct {
+		Prompts          []string `json:"prompt"`
+		N                int      `json:"n"`
+		Max              int      `json:"max_tokens"`
+		Stop             []string `json:"stop"`
+		Temperature      float32  `json:"temperature"`
+		Model            string   `json:"model"`
+		BestOf           int      `json:"best_of"`
+		TopP             float32  `json:"top_p,omitempty"`
+		TopK             int      `json:"top_k,omitempty"`
+		Seed             *int     `json:"seed,omitempty"`
+		PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+		FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+		Logprobs         int      `json:"logprobs,omitempty"`
+		Echo             bool     `json:"echo,omitempty"`
+	}
+
+	type commandSingle struct {
+		Prompts          string   `json:"prompt"`
+		N                int      `json:"n"`
+		Max              int      `json:"max_tokens"`
+		Stop             []string `json:"stop"`
+		Temperature      float32  `json:"temperature"`
+		Model            string   `json:"model"`
+		BestOf           int      `json:"best_of"`
+		TopP             float32  `json:"top_p,omitempty"`
+		TopK             int      `json:"top_k,omitempty"`
+		Seed             *int     `json:"seed,omitempty"`
+		PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+		FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+		Logprobs         int      `json:"logprobs,omitempty"`
+		Echo             bool     `json:"echo,omitempty"`
+	}
+
+	// jobs are batched by their generation settings, so the first job's ones are used
+	sampling := &batch[0].Req.SamplingParams
+
+	var stopTokens = []string{"###"}
+	promptBodies := make([]string, len(batch))
+	for i, b := range batch {
+		prompt, jobStopTokens := inferenceEngine.RenderPrompt(b.Req)
+		promptBodies[i] = prompt
+		if i == 0 && jobStopTokens != nil {
+			stopTokens = append(stopTokens, jobStopTokens...)
+		}
+	}
+
+	if batch[0].Req.BestOf == 0 {
+		batch[0].Req.BestOf = 1
+	}
+
+	// servers which don't list their models get no model, as they did before models were known
+	model, err := inferenceEngine.RequestModel(batch[0].Req.Model)
+	if err != nil && len(inferenceEngine.GetModels()) > 0 {
+		return nil, err
+	}
+
+	var commandBuffer []byte
+	if len(batch) > 1 {
+		cmd := &commandList{
+			Prompts:          promptBodies,
+			N:                1,
+			Max:              sampling.maxTokensOr(512),
+			Stop:             stopTokens,
+			Temperature:      batch[0].Req.Temperature,
+			Model:            model,
+			BestOf:           batch[0].Req.BestOf,
+			TopP:             sampling.TopP,
+			TopK:             sampling.TopK,
+			Seed:             sampling.Seed,
+			PresencePenalty:  sampling.PresencePenalty,
+			FrequencyPenalty: sampling.FrequencyPenalty,
+			Logprobs:         sampling.Logprobs,
+			Echo:             sampling.Echo,
+		}
+
+		commandBuffer, err = json.Marshal(cmd)
+		if err != nil {
+			zlog.Fatal().Err(err).Msg("error marshaling command")
+		}
+	} else {
+		cmd := &commandSingle{
+			Prompts:          promptBodies[0],
+			N:                1,
+			Max:              sampling.maxTokensOr(4096),
+			Stop:             stopTokens,
+			Temperature:      batch[0].Req.Temperature,
+			Model:            model,
+			BestOf:           batch[0].Req.BestOf,
+			TopP:             sampling.TopP,
+			TopK:             sampling.TopK,
+			Seed:             sampling.Seed,
+			PresencePenalty:  sampling.PresencePenalty,
+			FrequencyPenalty: sampling.FrequencyPenalty,
+			Logprobs:         sampling.Logprobs,
+			Echo:             sampling.Echo,
+		}
+
+		commandBuffer, err = json.Marshal(cmd)
+		if err != nil {
+			zlog.Fatal().Err(err).Msg("error marshaling command")
+		}
+	}
+
+	// sending the request here...!
+	resp, err := client.Post(inferenceEngine.EndpointUrl,
+		"application/json",
+		bytes.NewBuffer(commandBuffer))
+
+	// whatever happened here, it's not of our business, we should just log it
+	if err != nil {
+		zlog.Error().Err(err).
+			Interface("batch", batch).
+			Msg("error sending request")
+		return nil, err
+	}
+
+	// read resp.Body to result
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		zlog.Error().Err(err).
+			Interface("batch", batch).
+			Msg("error reading response")
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		err = backendHttpError(inferenceEngine.EndpointUrl, resp.StatusCode, string(result))
+		zlog.Error().Err(err).
+			Msgf("completion: http code is %d, url: %s, err: %v", resp.StatusCode, inferenceEngine.EndpointUrl, err)
+		return nil, err
+	}
+
+	// now, let us parse all the response in choices
+	type response struct {
+		Choices []struct {
+			Text     string          `json:"text"`
+			Logprobs *openAILogprobs `json:"logprobs"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	parsedResponse := &response{}
+	err = json.Unmarshal(result, parsedResponse)
+	if err != nil {
+		zlog.Error().Err(err).
+			Str("response", string(result)).
+			Msg("error unmarshalling response")
+		return nil, err
+	}
+
+	if len(parsedResponse.Choices) < len(batch) {
+		return nil, fmt.Errorf("got %d choices for batch of %d", len(parsedResponse.Choices), len(batch))
+	}
+
+	// usage is reported for the whole batch, engine counts tokens of batched jobs itself
+	if len(batch) == 1 && parsedResponse.Usage.TotalTokens > 0 {
+		batch[0].Stats = &StatisticsInfo{
+			PromptTokens:    parsedResponse.Usage.PromptTokens,
+			TokensGenerated: parsedResponse.Usage.CompletionTokens,
+		}
+	}
+
+	results := make([]*Message, len(batch))
+	// ok now each choice goes to its caller
+	for idx, job := range batch {
+		results[idx] = &Message{
+			Role:     ChatRoleAssistant,
+			Content:  parsedResponse.Choices[idx].Text,
+			Logprobs: parsedResponse.Choices[idx].Logprobs.toTokenLogprobs(),
+		}
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *openAIDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	return runOpenAIEmbeddingsRequest(inferenceEngine, batch)
+}
+
+func (d *openAIDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	return listOpenAIModels(inferenceEngine)
+}
+
+func (d *openAIDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	_, err := d.RunCompletion(inferenceEngine, []*JobQueueTask{
+		{
+			Req: &GenerationSettings{RawPrompt: "2 + 2 =", StopTokens: []string{"\n"}, MaxRetries: 1, Temperature: 0.1},
+		},
+	})
+
+	return err
+}
+
+// Tokenize uses vLLM's /tokenize, other OpenAI-compatible servers don't have it
+func (d *openAIDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, mask string, text string) ([]int, error) {
+	model, err := inferenceEngine.RequestModel(mask)
+	if err != nil {
+		return nil, err
+	}
+
+	type tokenizeResponse struct {
+		Tokens []int `json:"tokens"`
+	}
+	parsedResponse := &tokenizeResponse{}
+	err = doVLLMRequest(inferenceEngine, "/tokenize", map[string]interface{}{
+		"model":  model,
+		"prompt": text,
+	}, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedResponse.Tokens, nil
+}
+
+func (d *openAIDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, mask string, tokens []int) (string, error) {
+	model, err := inferenceEngine.RequestModel(mask)
+	if err != nil {
+		return "", err
+	}
+
+	type detokenizeResponse struct {
+		Prompt string `json:"prompt"`
+	}
+	parsedResponse := &detokenizeResponse{}
+	err = doVLLMRequest(inferenceEngine, "/detokenize", map[string]interface{}{
+		"model":  model,
+		"tokens": tokens,
+	}, parsedResponse)
+	if err != nil {
+		return "", err
+	}
+
+	return parsedResponse.Prompt, nil
+}
+
+// doVLLMRequest sends request to vLLM's own endpoints, which are served next to /v1
+func doVLLMRequest(inferenceEngine *RemoteInferenceEngine, path string, body interface{}, result interface{}) error {
+	reqJson, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(openAIBaseUrl(inferenceEngine.EndpointUrl), "/v1") + path
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(reqJson))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if inferenceEngine.Token != "" {
+		httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", inferenceEngine.Token))
+	}
+
+	client := http.Client{Timeout: InferenceTimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotSupported
+	}
+	if resp.StatusCode != 200 {
+		return backendHttpError(url, resp.StatusCode, string(data))
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// openAIBaseUrl turns http://host:8000/v1/completions into http://host:8000/v1
+func openAIBaseUrl(endpoint string) string {
+	if idx := strings.LastIndex(endpoint, "/v1/"); idx != -1 {
+		return endpoint[:idx+3]
+	}
+
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+func listOpenAIModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	client := http.Client{
+		Timeout: InferenceTimeout,
+	}
+
+	req, err := http.NewRequest("GET", openAIBaseUrl(inferenceEngine.EndpointUrl)+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	if inferenceEngine.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", inferenceEngine.Token))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error listing models http code is %d", resp.StatusCode)
+	}
+
+	type modelsResponse struct {
+		Data []struct {
+			Id          string `json:"id"`
+			MaxModelLen int    `json:"max_model_len"` // reported by vLLM
+		} `json:"data"`
+	}
+	parsedResponse := &modelsResponse{}
+	err = json.Unmarshal(result, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]*ModelInfo, 0, len(parsedResponse.Data))
+	for _, model := range parsedResponse.Data {
+		models = append(models, guessCapabilities(&ModelInfo{
+			Name:          parseModelName(model.Id),
+			ContextLength: model.MaxModelLen,
+		}))
+	}
+
+	return models, nil
+}
+
+func runOpenAIEmbeddingsRequest(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	if inferenceEngine.EmbeddingsEndpointUrl == "" {
+		return nil, fmt.Errorf("embeddings endpoint is not configured for inference engine %v", inferenceEngine)
+	}
+	client := http.Client{
+		Timeout: InferenceTimeout,
+	}
+
+	type command struct {
+		Input []string `json:"input"`
+	}
+
+	promptBodies := make([]string, len(batch))
+	for i, b := range batch {
+		promptBodies[i] = b.Req.RawPrompt
+	}
+
+	// '{"input":["hello", "hello", "hello", "hello"]}'
+	cmd := &command{
+		Input: promptBodies,
+	}
+
+	commandBuffer, err := json.Marshal(cmd)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("error marshaling command")
+	}
+
+	// sending the request here...!
+	resp, err := client.Post(inferenceEngine.EmbeddingsEndpointUrl,
+		"application/json",
+		bytes.NewBuffer(commandBuffer))
+
+	// whatever happened here, it's not of our business, we should just log it
+	if err != nil {
+		return nil, err
+	}
+
+	// read resp.Body to result
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		zlog.Error().Err(err).
+			Msgf("embeddings: http code is %d, endpoint: %s, err: %v", resp.StatusCode, inferenceEngine.EmbeddingsEndpointUrl, err)
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("error sending request http code is %d", resp.StatusCode)
+		zlog.Error().Err(err).
+			Msgf("embeddings: http code is %d, endpoint: %s, err: %s", resp.StatusCode, inferenceEngine.EmbeddingsEndpointUrl, string(result))
+		return nil, err
+	}
+
+	type embeddingsResponse struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+		Model string `json:"model"`
+	}
+
+	// now, let us parse all the response
+	parsedResponse := &embeddingsResponse{}
+	err = json.Unmarshal(result, parsedResponse)
+	if err != nil || parsedResponse.Data == nil {
+		zlog.Error().Err(err).
+			Str("response", string(result)).
+			Msg("error unmarshalling response")
+		if err == nil {
+			err = fmt.Errorf("no embeddings data in response")
+		}
+		return nil, err
+	}
+
+	if len(parsedResponse.Data) < len(batch) {
+		return nil, fmt.Errorf("got %d embeddings for batch of %d", len(parsedResponse.Data), len(batch))
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	// ok now each choice goes to its caller
+	parsedModelName := parseModelName(parsedResponse.Model)
+	for idx, job := range batch {
+		results[idx] = &vectors.Vector{
+			VecF64: parsedResponse.Data[idx].Embedding,
+			Model:  &parsedModelName,
+		}
+		if job.ResEmbeddings != nil {
+			job.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,398 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/settings"
+	"github.com/d0rc/agent-os/vectors"
+	pongo2 "github.com/flosch/pongo2/v6"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ProtocolTemplate is a generic HTTP driver, requests and responses are
+// described in node's `template` config section
+const ProtocolTemplate = "http-template"
+
+type templateDriver struct {
+	templates sync.Map // template source -> *pongo2.Template
+}
+
+func init() {
+	RegisterDriver(ProtocolTemplate, &templateDriver{})
+
+	if !pongo2.FilterExists("tojson") {
+		pongo2.RegisterFilter("tojson", func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			data, err := json.Marshal(in.Interface())
+			if err != nil {
+				return nil, &pongo2.Error{Sender: "filter:tojson", OrigError: err}
+			}
+			return pongo2.AsSafeValue(string(data)), nil
+		})
+	}
+}
+
+func (d *templateDriver) render(source string, context pongo2.Context) (string, error) {
+	tpl, exists := d.templates.Load(source)
+	if !exists {
+		compiled, err := pongo2.FromString(source)
+		if err != nil {
+			return "", fmt.Errorf("error parsing template: %w", err)
+		}
+		tpl, _ = d.templates.LoadOrStore(source, compiled)
+	}
+
+	return tpl.(*pongo2.Template).Execute(context)
+}
+
+func getTemplateSettings(inferenceEngine *RemoteInferenceEngine) (*settings.HttpTemplateConfigurationSection, error) {
+	if inferenceEngine.Template == nil {
+		return nil, fmt.Errorf("no template section configured for %s", inferenceEngine.EndpointUrl)
+	}
+
+	return inferenceEngine.Template, nil
+}
+
+func (d *templateDriver) doRequest(inferenceEngine *RemoteInferenceEngine, url string, body string) (interface{}, error) {
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	if inferenceEngine.Template.AuthHeader != "" {
+		header, err := d.render(inferenceEngine.Template.AuthHeader, pongo2.Context{"token": inferenceEngine.Token})
+		if err != nil {
+			return nil, err
+		}
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			return nil, fmt.Errorf("auth-header should look like `Name: value`, got: %s", inferenceEngine.Template.AuthHeader)
+		}
+		httpReq.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	client := http.Client{Timeout: InferenceTimeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, backendHttpError(url, resp.StatusCode, string(result))
+	}
+
+	var parsedResponse interface{}
+	err = json.Unmarshal(result, &parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsedResponse, nil
+}
+
+func (d *templateDriver) completionContext(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) pongo2.Context {
+	model, err := inferenceEngine.RequestModel(batch[0].Req.Model)
+	if err != nil {
+		// provider might have a default model, so it's up to the template
+		model = ""
+	}
+
+	prompts := make([]string, len(batch))
+	var stopTokens []string
+	for idx, job := range batch {
+		var jobStopTokens []string
+		prompts[idx], jobStopTokens = inferenceEngine.RenderPrompt(job.Req)
+		if idx == 0 {
+			stopTokens = jobStopTokens
+		}
+	}
+	if stopTokens == nil {
+		stopTokens = []string{}
+	}
+
+	messages := make([]map[string]string, len(batch[0].Req.Messages))
+	for idx := range batch[0].Req.Messages {
+		messages[idx] = map[string]string{
+			"role":    string(batch[0].Req.Messages[idx].Role),
+			"content": batch[0].Req.Messages[idx].Content,
+		}
+	}
+
+	sampling := &batch[0].Req.SamplingParams
+	var seed interface{}
+	if sampling.Seed != nil {
+		seed = *sampling.Seed
+	}
+
+	return pongo2.Context{
+		"model":             model,
+		"prompt":            prompts[0],
+		"prompts":           prompts,
+		"messages":          messages,
+		"stop":              stopTokens,
+		"temperature":       batch[0].Req.Temperature,
+		"best_of":           batch[0].Req.BestOf,
+		"grammar":           batch[0].Req.Grammar,
+		"max_tokens":        sampling.MaxTokens,
+		"top_p":             sampling.TopP,
+		"top_k":             sampling.TopK,
+		"seed":              seed,
+		"presence_penalty":  sampling.PresencePenalty,
+		"frequency_penalty": sampling.FrequencyPenalty,
+		"logprobs":          sampling.Logprobs,
+		"echo":              sampling.Echo,
+	}
+}
+
+// runCompletionBatch sends a single request, it's either a single job,
+// or a whole batch, if template is configured for batches
+func (d *templateDriver) runCompletionBatch(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	template := inferenceEngine.Template
+	body, err := d.render(template.CompletionBody, d.completionContext(inferenceEngine, batch))
+	if err != nil {
+		return nil, err
+	}
+
+	parsedResponse, err := d.doRequest(inferenceEngine, inferenceEngine.EndpointUrl, body)
+	if err != nil {
+		return nil, err
+	}
+
+	choices, err := evaluateJsonPath(parsedResponse, template.ChoicesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(choices) < len(batch) {
+		return nil, fmt.Errorf("got %d choices for batch of %d", len(choices), len(batch))
+	}
+
+	results := make([]*Message, len(batch))
+	for idx := range batch {
+		content, ok := choices[idx].(string)
+		if !ok {
+			return nil, fmt.Errorf("choice %d is not a string: %v", idx, choices[idx])
+		}
+		results[idx] = &Message{
+			Role:    ChatRoleAssistant,
+			Content: content,
+		}
+	}
+
+	// usage reported for a batch can't be split between jobs, so it's counted by the engine then
+	if len(batch) == 1 && template.PromptTokensPath != "" && template.CompletionTokensPath != "" {
+		batch[0].Stats = &StatisticsInfo{
+			PromptTokens:    sumJsonPath(parsedResponse, template.PromptTokensPath),
+			TokensGenerated: sumJsonPath(parsedResponse, template.CompletionTokensPath),
+		}
+	}
+
+	return results, nil
+}
+
+func sumJsonPath(document interface{}, path string) int {
+	if path == "" {
+		return 0
+	}
+
+	values, err := evaluateJsonPath(document, path)
+	if err != nil {
+		return 0
+	}
+
+	sum := 0
+	for _, value := range values {
+		if number, ok := value.(float64); ok {
+			sum += int(number)
+		}
+	}
+
+	return sum
+}
+
+func (d *templateDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	template, err := getTemplateSettings(inferenceEngine)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Message
+	if template.Batch {
+		results, err = d.runCompletionBatch(inferenceEngine, batch)
+	} else {
+		results = make([]*Message, len(batch))
+		errs := make([]error, len(batch))
+		wg := sync.WaitGroup{}
+		for idx, job := range batch {
+			wg.Add(1)
+			go func(idx int, job *JobQueueTask) {
+				defer wg.Done()
+				var jobResults []*Message
+				jobResults, errs[idx] = d.runCompletionBatch(inferenceEngine, []*JobQueueTask{job})
+				if errs[idx] == nil {
+					results[idx] = jobResults[0]
+				}
+			}(idx, job)
+		}
+		wg.Wait()
+
+		for _, jobErr := range errs {
+			if jobErr != nil {
+				err = jobErr
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		zlog.Error().Err(err).
+			Msgf("completion: error running batch on %s", inferenceEngine.EndpointUrl)
+		return nil, err
+	}
+
+	for idx, job := range batch {
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+func (d *templateDriver) runEmbeddingsBatch(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	template := inferenceEngine.Template
+	model, err := inferenceEngine.RequestModel(batch[0].Req.Model)
+	if err != nil {
+		model = ""
+	}
+
+	inputs := make([]string, len(batch))
+	for idx, job := range batch {
+		inputs[idx] = job.Req.RawPrompt
+	}
+
+	body, err := d.render(template.EmbeddingsBody, pongo2.Context{
+		"model":  model,
+		"input":  inputs[0],
+		"inputs": inputs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := inferenceEngine.EmbeddingsEndpointUrl
+	if url == "" {
+		url = inferenceEngine.EndpointUrl
+	}
+
+	parsedResponse, err := d.doRequest(inferenceEngine, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings, err := evaluateJsonPath(parsedResponse, template.EmbeddingsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(embeddings) < len(batch) {
+		return nil, fmt.Errorf("got %d embeddings for batch of %d", len(embeddings), len(batch))
+	}
+
+	results := make([]*vectors.Vector, len(batch))
+	for idx := range batch {
+		values, ok := embeddings[idx].([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("embeddings %d is not a list of numbers", idx)
+		}
+		vector := make([]float64, len(values))
+		for i, value := range values {
+			if vector[i], ok = value.(float64); !ok {
+				return nil, fmt.Errorf("embeddings %d is not a list of numbers", idx)
+			}
+		}
+		results[idx] = &vectors.Vector{
+			VecF64: vector,
+			Model:  &model,
+		}
+	}
+
+	return results, nil
+}
+
+func (d *templateDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	template, err := getTemplateSettings(inferenceEngine)
+	if err != nil {
+		return nil, err
+	}
+	if template.EmbeddingsBody == "" || template.EmbeddingsPath == "" {
+		return nil, ErrNotSupported
+	}
+
+	var results []*vectors.Vector
+	if template.Batch {
+		results, err = d.runEmbeddingsBatch(inferenceEngine, batch)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		results = make([]*vectors.Vector, len(batch))
+		for idx, job := range batch {
+			jobResults, err := d.runEmbeddingsBatch(inferenceEngine, []*JobQueueTask{job})
+			if err != nil {
+				return nil, err
+			}
+			results[idx] = jobResults[0]
+		}
+	}
+
+	for idx, job := range batch {
+		if job.ResEmbeddings != nil {
+			job.ResEmbeddings <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+// ListModels returns nothing, models served by the provider are listed in config
+func (d *templateDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *templateDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	template, err := getTemplateSettings(inferenceEngine)
+	if err != nil {
+		return err
+	}
+	if template.CompletionBody == "" || template.ChoicesPath == "" {
+		return fmt.Errorf("completion-body and choices-path are required for completions")
+	}
+
+	_, err = d.runCompletionBatch(inferenceEngine, []*JobQueueTask{
+		{
+			Req: &GenerationSettings{RawPrompt: "2 + 2 =", StopTokens: []string{"\n"}, MaxRetries: 1, Temperature: 0.1},
+		},
+	})
+
+	return err
+}
+
+func (d *templateDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *templateDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	return "", ErrNotSupported
+}
@@ -0,0 +1,111 @@
+package engines
+
+import (
+	"encoding/json"
+	"github.com/d0rc/agent-os/settings"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTemplateDriver(t *testing.T) {
+	// provider with together-like completions and openai-like embeddings
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		req := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("template rendered invalid JSON: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/inference":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"output": map[string]interface{}{
+					"choices": []interface{}{
+						map[string]interface{}{"text": req["model"].(string) + ": " + req["prompt"].(string)},
+					},
+				},
+				"usage": map[string]interface{}{"prompt_tokens": 7, "completion_tokens": 3},
+			})
+		case "/embeddings":
+			data := make([]interface{}, 0)
+			for range req["input"].([]interface{}) {
+				data = append(data, map[string]interface{}{"embedding": []float64{1, 2}})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+		}
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{
+		EndpointUrl:           server.URL + "/inference",
+		EmbeddingsEndpointUrl: server.URL + "/embeddings",
+		Protocol:              ProtocolTemplate,
+		Token:                 "secret",
+		Models:                []string{"acme/model-7b"},
+		Template: &settings.HttpTemplateConfigurationSection{
+			AuthHeader:           "X-Api-Key: {{ token }}",
+			CompletionBody:       `{"model": {{ model|tojson }}, "prompt": {{ prompt|tojson }}, "stop": {{ stop|tojson }}, "temperature": {{ temperature }}}`,
+			ChoicesPath:          "$.output.choices[*].text",
+			PromptTokensPath:     "$.usage.prompt_tokens",
+			CompletionTokensPath: "$.usage.completion_tokens",
+			EmbeddingsBody:       `{"input": {{ inputs|tojson }}}`,
+			EmbeddingsPath:       "$.data[*].embedding",
+			Batch:                true,
+		},
+	}
+	done := make(chan struct{}, 1)
+	StartInferenceEngine(engine, done)
+	<-done
+
+	if engine.CompletionFailed || engine.EmbeddingsFailed || *engine.EmbeddingsDims != 2 {
+		t.Fatalf("engine failed to start: %+v", engine)
+	}
+
+	var stats *StatisticsInfo
+	prompt := "say \"hi\"\n<b>"
+	results, err := RunCompletionRequest(engine, []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: prompt, StatisticsCallback: func(info *StatisticsInfo) {
+			stats = info
+		}}},
+	})
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+	if results[0].Content != "acme/model-7b: "+prompt {
+		t.Fatalf("unexpected completion: %s", results[0].Content)
+	}
+	if stats == nil || stats.PromptTokens != 7 || stats.TokensGenerated != 3 {
+		t.Fatalf("usage is not extracted: %+v", stats)
+	}
+
+	engine.Token = "wrong"
+	_, err = RunCompletionRequest(engine, []*JobQueueTask{{Req: &GenerationSettings{RawPrompt: "hi"}}})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected auth error, got: %v", err)
+	}
+}
+
+func TestJsonPath(t *testing.T) {
+	var document interface{}
+	_ = json.Unmarshal([]byte(`{"a": {"b": [{"c": 1}, {"c": 2}], "d-e": "x"}}`), &document)
+
+	for path, expected := range map[string]int{
+		"$.a.b[*].c":  2,
+		"$.a.b[-1].c": 1,
+		"$.a['d-e']":  1,
+		"$.a.x":       0,
+	} {
+		values, err := evaluateJsonPath(document, path)
+		if err != nil || len(values) != expected {
+			t.Errorf("%s: got %v, %v", path, values, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package engines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	zlog "github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const ProtocolTogether = "http-together"
+
+// togetherDefaultModel is used if node has no `models` configured
+const togetherDefaultModel = "mistralai/Mistral-7B-Instruct-v0.1"
+
+type togetherDriver struct{}
+
+func init() {
+	RegisterDriver(ProtocolTogether, &togetherDriver{})
+}
+
+func (d *togetherDriver) RunCompletion(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error) {
+	results := make([]*Message, len(batch))
+	errs := make([]error, len(batch))
+	wg := sync.WaitGroup{}
+	for idx, job := range batch {
+		wg.Add(1)
+		go func(idx int, job *JobQueueTask) {
+			defer wg.Done()
+			results[idx], errs[idx] = d.runCompletionJob(inferenceEngine, job)
+		}(idx, job)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			zlog.Error().Err(err).
+				Msgf("completion: error running batch on %s", inferenceEngine.EndpointUrl)
+			return nil, err
+		}
+	}
+
+	for idx, job := range batch {
+		if job.Res != nil {
+			job.Res <- results[idx]
+		}
+	}
+
+	return results, nil
+}
+
+// runCompletionJob sends a job in its own request, together's API takes a single prompt
+func (d *togetherDriver) runCompletionJob(inferenceEngine *RemoteInferenceEngine, job *JobQueueTask) (*Message, error) {
+	// {
+	//  "model": "togethercomputer/RedPajama-INCITE-7B-Instruct",
+	//  "prompt": "Q: The capital of France is?\nA:",
+	//  "temperature": 0.7,
+	//  "top_p": 0.7,
+	//  "top_k": 50,
+	//  "max_tokens": 1,
+	//  "repetition_penalty": 1
+	//}
+	type togetherRequest struct {
+		Model             string   `json:"model"`
+		Prompt            string   `json:"prompt"`
+		Temperature       float32  `json:"temperature"`
+		TopP              float32  `json:"top_p"`
+		TopK              int      `json:"top_k"`
+		MaxTokens         int      `json:"max_tokens"`
+		RepetitionPenalty float32  `json:"repetition_penalty"`
+		Stop              []string `json:"stop"`
+		Seed              *int     `json:"seed,omitempty"`
+		Logprobs          int      `json:"logprobs,omitempty"`
+		Echo              bool     `json:"echo,omitempty"`
+	}
+
+	var stopTokens = []string{"###"}
+	prompt, jobStopTokens := inferenceEngine.RenderPrompt(job.Req)
+	if len(jobStopTokens) > 0 {
+		stopTokens = jobStopTokens
+	}
+
+	model, err := inferenceEngine.RequestModel(job.Req.Model)
+	if err != nil {
+		if len(inferenceEngine.GetModels()) > 0 {
+			return nil, err
+		}
+		model = togetherDefaultModel
+	}
+
+	sampling := &job.Req.SamplingParams
+	req := &togetherRequest{
+		Model:       model,
+		Prompt:      prompt,
+		Temperature: job.Req.Temperature,
+		TopP:        0.9,
+		TopK:        50,
+		MaxTokens:   sampling.maxTokensOr(2048),
+		Stop:        stopTokens,
+		Seed:        sampling.Seed,
+		Logprobs:    sampling.Logprobs,
+		Echo:        sampling.Echo,
+	}
+	if sampling.TopP != 0 {
+		req.TopP = sampling.TopP
+	}
+	if sampling.TopK != 0 {
+		req.TopK = sampling.TopK
+	}
+
+	reqJson, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.doRequest(inferenceEngine, "POST", inferenceEngine.EndpointUrl, reqJson)
+	if err != nil {
+		return nil, err
+	}
+
+	// now, let us parse all the response in choices
+	type togetherResponse struct {
+		Output struct {
+			Choices []struct {
+				Text string `json:"text"`
+			}
+		} `json:"output"`
+	}
+	parsedResponse := &togetherResponse{}
+
+	err = json.Unmarshal(result, parsedResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parsedResponse.Output.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in together response")
+	}
+
+	return &Message{
+		Role:    ChatRoleAssistant,
+		Content: parsedResponse.Output.Choices[0].Text,
+	}, nil
+}
+
+func (d *togetherDriver) RunEmbeddings(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error) {
+	return runOpenAIEmbeddingsRequest(inferenceEngine, batch)
+}
+
+func (d *togetherDriver) ListModels(inferenceEngine *RemoteInferenceEngine) ([]*ModelInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *togetherDriver) HealthCheck(inferenceEngine *RemoteInferenceEngine) error {
+	_, err := d.runCompletionJob(inferenceEngine, &JobQueueTask{
+		Req: &GenerationSettings{RawPrompt: "2 + 2 =", StopTokens: []string{"\n"}, MaxRetries: 1, Temperature: 0.1},
+	})
+
+	return err
+}
+
+func (d *togetherDriver) Tokenize(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	return nil, ErrNotSupported
+}
+
+func (d *togetherDriver) Detokenize(inferenceEngine *RemoteInferenceEngine, model string, tokens []int) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (d *togetherDriver) doRequest(inferenceEngine *RemoteInferenceEngine, method, url string, body []byte) ([]byte, error) {
+	headers := map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": fmt.Sprintf("Bearer %s", inferenceEngine.Token),
+	}
+
+	// send request with the headers
+	client := http.Client{Timeout: InferenceTimeout}
+	httpReq, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error sending request http code is %d", resp.StatusCode)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,37 @@
+package engines
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTogetherDriverRunsEveryJobOfBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"output": map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"text": "answer to " + req["prompt"].(string)}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{EndpointUrl: server.URL, Protocol: ProtocolTogether}
+	batch := []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "first", Temperature: 0.1}},
+		{Req: &GenerationSettings{RawPrompt: "second", Temperature: 0.9}},
+	}
+	results, err := RunCompletionRequest(engine, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Content != "answer to first" || results[1].Content != "answer to second" {
+		t.Fatalf("every job should get its own result: %+v", results)
+	}
+	if batch[1].Stats == nil || batch[1].Stats.TokensGenerated == 0 {
+		t.Fatalf("every job should be accounted: %+v", batch[1].Stats)
+	}
+}
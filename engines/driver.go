@@ -0,0 +1,87 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/vectors"
+	"sort"
+	"sync"
+)
+
+// ErrNotSupported is returned by drivers for operations backend can't do
+var ErrNotSupported = errors.New("operation is not supported by the engine driver")
+
+type ModelInfo struct {
+	Name           string
+	EmbeddingsDims uint64 // 0 - unknown
+	ContextLength  int    // 0 - unknown
+	Completion     bool   // model can run completions
+	Embeddings     bool   // model can compute embeddings
+}
+
+// EngineDriver is implemented by every inference backend, drivers are registered
+// by the compute `type` used in configuration file, i.e. `http-openai`
+type EngineDriver interface {
+	// RunCompletion runs a batch of completion jobs, besides returning results,
+	// each result has to be sent to the job's Res channel, if it's set
+	RunCompletion(engine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*Message, error)
+	// RunEmbeddings runs a batch of embeddings jobs, besides returning results,
+	// each result has to be sent to the job's ResEmbeddings channel, if it's set
+	RunEmbeddings(engine *RemoteInferenceEngine, batch []*JobQueueTask) ([]*vectors.Vector, error)
+	// ListModels returns models served by the engine
+	ListModels(engine *RemoteInferenceEngine) ([]*ModelInfo, error)
+	// HealthCheck returns nil if engine is able to run completions
+	HealthCheck(engine *RemoteInferenceEngine) error
+	// Tokenize returns token ids of text, as seen by the engine's model matching the mask
+	Tokenize(engine *RemoteInferenceEngine, model string, text string) ([]int, error)
+	// Detokenize turns token ids of the engine's model matching the mask back into text
+	Detokenize(engine *RemoteInferenceEngine, model string, tokens []int) (string, error)
+}
+
+var drivers = make(map[string]EngineDriver)
+var driversLock = sync.RWMutex{}
+
+// RegisterDriver makes driver available by the name of compute type,
+// it panics if called twice with the same name, or if driver is nil
+func RegisterDriver(name string, driver EngineDriver) {
+	driversLock.Lock()
+	defer driversLock.Unlock()
+
+	if driver == nil {
+		panic("engines: RegisterDriver driver is nil")
+	}
+	if _, exists := drivers[name]; exists {
+		panic("engines: RegisterDriver called twice for driver " + name)
+	}
+
+	drivers[name] = driver
+}
+
+func GetDriver(name string) (EngineDriver, error) {
+	driversLock.RLock()
+	defer driversLock.RUnlock()
+
+	driver, exists := drivers[name]
+	if !exists {
+		return nil, fmt.Errorf("unsupported protocol %s, known are: %v", name, listDrivers())
+	}
+
+	return driver, nil
+}
+
+func Drivers() []string {
+	driversLock.RLock()
+	defer driversLock.RUnlock()
+
+	return listDrivers()
+}
+
+func listDrivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -1,7 +1,11 @@
 package engines
 
 import (
+	"errors"
+	"github.com/d0rc/agent-os/settings"
+	zlog "github.com/rs/zerolog/log"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -66,22 +70,61 @@ type RemoteInferenceEngine struct {
 	EmbeddingsFailed      bool
 	Protocol              string
 	Token                 string
+	Slots                 int    // parallel slots reported by the engine, 0 - unknown
+	ContextLength         int    // context window in tokens, declared in config or reported by the engine, 0 - unknown
+	CanTokenize           bool   // engine's driver is able to tokenize text with engine's model
+	ChatTemplate          string // overrides chat template detection, i.e. chatml
+	Template              *settings.HttpTemplateConfigurationSection
+	Mock                  *settings.MockConfigurationSection
+	modelsLock            sync.RWMutex
+	modelsInfo            map[string]*ModelInfo // discovered models, guarded by modelsLock
+	configuredModels      []string
+	// context length was declared in config, so it's not replaced by the one models report
+	contextLengthConfigured bool
 }
 
 func StartInferenceEngine(engine *RemoteInferenceEngine, done chan struct{}) {
-	// we need to send a completion request to the engine
-	// detect the model, then send embeddings request to the engine and
-	// detect the model and dimensions
-	_, err := RunCompletionRequest(engine, []*JobQueueTask{
-		{
-			Req: &GenerationSettings{RawPrompt: "### Instruction\nProvide an answer. 2 + 2 = ?\n### Assistant: ", MaxRetries: 1, Temperature: 0.1},
-		},
-	})
+	// ask the driver which models engine serves, then check if it's able
+	// to run completions, and if embeddings dimensions are still unknown,
+	// send embeddings request to the engine to detect the model and dimensions
+	driver, err := GetDriver(engine.Protocol)
 	if err != nil {
+		zlog.Error().Err(err).Str("url", engine.EndpointUrl).Msg("no driver for inference engine")
+		engine.CompletionFailed = true
+		engine.EmbeddingsFailed = true
+		done <- struct{}{}
+		return
+	}
+
+	if engine.ChatTemplate != "" {
+		if _, err = GetChatTemplate(engine.ChatTemplate); err != nil {
+			zlog.Error().Err(err).Str("url", engine.EndpointUrl).Msg("chat template is detected by model name")
+		}
+	}
+
+	models, err := driver.ListModels(engine)
+	if err != nil && !errors.Is(err, ErrNotSupported) {
+		zlog.Warn().Err(err).Str("url", engine.EndpointUrl).Msg("failed to list engine's models")
+	}
+	engine.configuredModels = engine.GetModels()
+	engine.contextLengthConfigured = engine.GetContextLength() > 0
+	if len(models) > 0 {
+		setDiscoveredModels(engine, models)
+	}
+
+	_, err = driver.Tokenize(engine, "", "Hello world")
+	engine.CanTokenize = err == nil
+
+	if err = driver.HealthCheck(engine); err != nil {
 		// engine failed to run completion
 		engine.CompletionFailed = true
 	}
 
+	if engine.GetEmbeddingsDims() > 0 {
+		done <- struct{}{}
+		return
+	}
+
 	cEmb, err := RunEmbeddingsRequest(engine, []*JobQueueTask{
 		{
 			Req: &GenerationSettings{RawPrompt: "Hello world", MaxRetries: 1, Temperature: 0.1},
@@ -94,28 +137,36 @@ func StartInferenceEngine(engine *RemoteInferenceEngine, done chan struct{}) {
 
 	if len(cEmb) > 0 {
 		if cEmb[0].Model != nil {
-			parsedModelName := parseModelName(*cEmb[0].Model)
-			added := false
-			for idx, model := range engine.Models {
-				if model == parsedModelName || model == "" {
-					added = true
-					engine.Models[idx] = parsedModelName
-					break
-				}
-			}
-			if !added {
-				engine.Models = append(engine.Models, parsedModelName)
-			}
+			addEngineModel(engine, parseModelName(*cEmb[0].Model))
 		}
 		if len(cEmb[0].VecF64) > 0 {
 			dims := uint64(len(cEmb[0].VecF64))
+			engine.modelsLock.Lock()
 			engine.EmbeddingsDims = &dims
+			engine.modelsLock.Unlock()
+			if cEmb[0].Model != nil {
+				setModelEmbeddings(engine, parseModelName(*cEmb[0].Model), dims)
+			}
 		}
 	}
 
 	done <- struct{}{}
 }
 
+func addEngineModel(engine *RemoteInferenceEngine, modelName string) {
+	engine.modelsLock.Lock()
+	defer engine.modelsLock.Unlock()
+
+	for idx, model := range engine.Models {
+		if model == modelName || model == "" {
+			engine.Models[idx] = modelName
+			return
+		}
+	}
+
+	engine.Models = append(engine.Models, modelName)
+}
+
 func parseModelName(s string) string {
 	// /Users/ds/.cache/lm-studio/models/TheBloke/dolphin-2.2.1-mistral-7B-GGUF/dolphin-2.2.1-mistral-7b.Q6_K.gguf
 	if strings.HasSuffix(s, ".gguf") {
@@ -0,0 +1,80 @@
+package engines
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// evaluateJsonPath supports a minimal subset of JSONPath, enough to pick things
+// from inference APIs responses: $.field.other[0].text, $.data[*].embedding
+func evaluateJsonPath(document interface{}, path string) ([]interface{}, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("json path must start with $: %s", path)
+	}
+
+	current := []interface{}{document}
+	rest := path[1:]
+	for len(rest) > 0 {
+		var next []interface{}
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			rest = rest[end+1:]
+			if key == "" {
+				return nil, fmt.Errorf("empty key in json path: %s", path)
+			}
+			for _, value := range current {
+				if object, ok := value.(map[string]interface{}); ok {
+					if child, exists := object[key]; exists {
+						next = append(next, child)
+					}
+				}
+			}
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed [ in json path: %s", path)
+			}
+			selector := strings.Trim(rest[1:end], `'"`)
+			rest = rest[end+1:]
+			for _, value := range current {
+				switch typedValue := value.(type) {
+				case []interface{}:
+					if selector == "*" {
+						next = append(next, typedValue...)
+						continue
+					}
+					idx, err := strconv.Atoi(selector)
+					if err != nil {
+						return nil, fmt.Errorf("bad index %s in json path: %s", selector, path)
+					}
+					if idx < 0 {
+						idx += len(typedValue)
+					}
+					if idx >= 0 && idx < len(typedValue) {
+						next = append(next, typedValue[idx])
+					}
+				case map[string]interface{}:
+					if selector == "*" {
+						for _, child := range typedValue {
+							next = append(next, child)
+						}
+					} else if child, exists := typedValue[selector]; exists {
+						next = append(next, child)
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %c in json path: %s", rest[0], path)
+		}
+		current = next
+	}
+
+	return current, nil
+}
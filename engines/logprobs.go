@@ -0,0 +1,132 @@
+package engines
+
+import "math"
+
+// TokenLogprob is a generated token with its log probability,
+// along with the most likely alternatives, if engine reports them
+type TokenLogprob struct {
+	Token       string             `json:"token"`
+	Logprob     float64            `json:"logprob"`
+	TopLogprobs map[string]float64 `json:"top-logprobs,omitempty"`
+}
+
+// openAILogprobs is the legacy /v1/completions format
+type openAILogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []*float64           `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+}
+
+func (l *openAILogprobs) toTokenLogprobs() []TokenLogprob {
+	if l == nil {
+		return nil
+	}
+
+	result := make([]TokenLogprob, 0, len(l.Tokens))
+	for idx, token := range l.Tokens {
+		// first token of echoed prompt has no logprob
+		if idx >= len(l.TokenLogprobs) || l.TokenLogprobs[idx] == nil {
+			continue
+		}
+		tokenLogprob := TokenLogprob{
+			Token:   token,
+			Logprob: *l.TokenLogprobs[idx],
+		}
+		if idx < len(l.TopLogprobs) {
+			tokenLogprob.TopLogprobs = l.TopLogprobs[idx]
+		}
+		result = append(result, tokenLogprob)
+	}
+
+	return result
+}
+
+// openAIChatLogprobs is /v1/chat/completions format
+type openAIChatLogprobs struct {
+	Content []struct {
+		Token       string  `json:"token"`
+		Logprob     float64 `json:"logprob"`
+		TopLogprobs []struct {
+			Token   string  `json:"token"`
+			Logprob float64 `json:"logprob"`
+		} `json:"top_logprobs"`
+	} `json:"content"`
+}
+
+func (l *openAIChatLogprobs) toTokenLogprobs() []TokenLogprob {
+	if l == nil {
+		return nil
+	}
+
+	result := make([]TokenLogprob, len(l.Content))
+	for idx, content := range l.Content {
+		result[idx] = TokenLogprob{
+			Token:       content.Token,
+			Logprob:     content.Logprob,
+			TopLogprobs: make(map[string]float64, len(content.TopLogprobs)),
+		}
+		for _, top := range content.TopLogprobs {
+			result[idx].TopLogprobs[top.Token] = top.Logprob
+		}
+	}
+
+	return result
+}
+
+// llamaCppProbabilities is llama.cpp's `completion_probabilities`, it has probabilities, not logs
+type llamaCppProbabilities []struct {
+	Content string `json:"content"`
+	Probs   []struct {
+		TokStr string  `json:"tok_str"`
+		Prob   float64 `json:"prob"`
+	} `json:"probs"`
+}
+
+func (p llamaCppProbabilities) toTokenLogprobs() []TokenLogprob {
+	if len(p) == 0 {
+		return nil
+	}
+
+	result := make([]TokenLogprob, len(p))
+	for idx, token := range p {
+		result[idx] = TokenLogprob{
+			Token:       token.Content,
+			Logprob:     0,
+			TopLogprobs: make(map[string]float64, len(token.Probs)),
+		}
+		found := false
+		for _, prob := range token.Probs {
+			logprob := safeLog(prob.Prob)
+			result[idx].TopLogprobs[prob.TokStr] = logprob
+			if prob.TokStr == token.Content {
+				result[idx].Logprob = logprob
+				found = true
+			} else if !found && logprob < result[idx].Logprob {
+				// sampled token out of the top ones is at most as likely as the least likely of them
+				result[idx].Logprob = logprob
+			}
+		}
+	}
+
+	return result
+}
+
+// safeLog keeps logprobs finite, infinities can't be encoded to JSON
+func safeLog(p float64) float64 {
+	return math.Log(math.Max(p, 1e-12))
+}
+
+// SequenceConfidence is the geometric mean of generated tokens' probabilities,
+// false is returned if there are no logprobs
+func SequenceConfidence(logprobs []TokenLogprob) (float64, bool) {
+	if len(logprobs) == 0 {
+		return 0, false
+	}
+
+	sum := 0.0
+	for _, logprob := range logprobs {
+		sum += logprob.Logprob
+	}
+
+	return math.Exp(sum / float64(len(logprobs))), true
+}
@@ -0,0 +1,181 @@
+package engines
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ModelsRefreshInterval is how often nodes are asked for their models,
+// so models swapped on the node are picked up
+const ModelsRefreshInterval = 5 * time.Minute
+
+// embeddingsModelMarkers are parts of the names of well known embeddings-only models
+var embeddingsModelMarkers = []string{"embed", "bge-", "e5-", "gte-", "minilm", "mxbai"}
+
+// guessCapabilities sets model's capabilities by its name, for the backends which don't report them
+func guessCapabilities(model *ModelInfo) *ModelInfo {
+	name := strings.ToLower(model.Name)
+	for _, marker := range embeddingsModelMarkers {
+		if strings.Contains(name, marker) {
+			model.Embeddings = true
+			return model
+		}
+	}
+
+	model.Completion = true
+	return model
+}
+
+// RefreshModels asks the driver for engine's models and replaces
+// the list of engine's models, models declared in config are kept
+func RefreshModels(engine *RemoteInferenceEngine) error {
+	driver, err := GetDriver(engine.Protocol)
+	if err != nil {
+		return err
+	}
+
+	models, err := driver.ListModels(engine)
+	if err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			return nil
+		}
+		return err
+	}
+	if len(models) > 0 {
+		setDiscoveredModels(engine, models)
+	}
+
+	return nil
+}
+
+func setDiscoveredModels(engine *RemoteInferenceEngine, models []*ModelInfo) {
+	engine.modelsLock.Lock()
+	defer engine.modelsLock.Unlock()
+
+	discovered := make(map[string]*ModelInfo, len(models))
+	for _, model := range models {
+		info := *model
+		if previous, ok := engine.modelsInfo[model.Name]; ok {
+			// capabilities detected by probing aren't reported by listing
+			info.Embeddings = info.Embeddings || previous.Embeddings
+			info.Completion = info.Completion || previous.Completion
+			if info.EmbeddingsDims == 0 {
+				info.EmbeddingsDims = previous.EmbeddingsDims
+			}
+		}
+		discovered[model.Name] = &info
+	}
+
+	names := make([]string, 0, len(engine.configuredModels)+len(models))
+	for _, name := range engine.configuredModels {
+		if _, isDiscovered := discovered[name]; !isDiscovered && name != "" {
+			names = append(names, name)
+		}
+	}
+	for _, model := range models {
+		names = append(names, model.Name)
+	}
+
+	engine.Models = names
+	engine.modelsInfo = discovered
+
+	if !engine.contextLengthConfigured {
+		engine.ContextLength = 0
+		for _, model := range models {
+			if model.ContextLength > 0 {
+				engine.ContextLength = model.ContextLength
+				break
+			}
+		}
+	}
+	if engine.EmbeddingsDims == nil {
+		for _, model := range discovered {
+			if model.EmbeddingsDims > 0 {
+				dims := model.EmbeddingsDims
+				engine.EmbeddingsDims = &dims
+				break
+			}
+		}
+	}
+}
+
+// setModelEmbeddings records embeddings detected by probing the engine
+func setModelEmbeddings(engine *RemoteInferenceEngine, name string, dims uint64) {
+	engine.modelsLock.Lock()
+	defer engine.modelsLock.Unlock()
+
+	if engine.modelsInfo == nil {
+		engine.modelsInfo = make(map[string]*ModelInfo)
+	}
+	info, ok := engine.modelsInfo[name]
+	if !ok {
+		info = &ModelInfo{Name: name}
+		engine.modelsInfo[name] = info
+	}
+	info.Embeddings = true
+	info.EmbeddingsDims = dims
+}
+
+// GetContextLength returns engine's context window in tokens, 0 - unknown,
+// it's read under the lock, as models refresh may update it at any time
+func (engine *RemoteInferenceEngine) GetContextLength() int {
+	engine.modelsLock.RLock()
+	defer engine.modelsLock.RUnlock()
+
+	return engine.ContextLength
+}
+
+// GetEmbeddingsDims returns dimensions of engine's embeddings, 0 - unknown
+func (engine *RemoteInferenceEngine) GetEmbeddingsDims() uint64 {
+	engine.modelsLock.RLock()
+	defer engine.modelsLock.RUnlock()
+
+	if engine.EmbeddingsDims == nil {
+		return 0
+	}
+
+	return *engine.EmbeddingsDims
+}
+
+// GetModelInfo returns a copy of what is known about engine's model, or nil
+func (engine *RemoteInferenceEngine) GetModelInfo(name string) *ModelInfo {
+	engine.modelsLock.RLock()
+	defer engine.modelsLock.RUnlock()
+
+	info, ok := engine.modelsInfo[name]
+	if !ok {
+		return nil
+	}
+	result := *info
+
+	return &result
+}
+
+// EmbeddingsModel returns the name of engine's model able to compute embeddings,
+// or the first model, if capabilities are unknown, or empty string if no models are known
+func (engine *RemoteInferenceEngine) EmbeddingsModel() string {
+	engine.modelsLock.RLock()
+	defer engine.modelsLock.RUnlock()
+
+	// dedicated embeddings models are preferred over generative ones able to compute embeddings
+	embeddingsModel := ""
+	for _, name := range engine.Models {
+		if info, ok := engine.modelsInfo[name]; ok && info.Embeddings {
+			if !info.Completion {
+				return name
+			}
+			if embeddingsModel == "" {
+				embeddingsModel = name
+			}
+		}
+	}
+	if embeddingsModel != "" {
+		return embeddingsModel
+	}
+	if len(engine.Models) > 0 {
+		return engine.Models[0]
+	}
+
+	return ""
+}
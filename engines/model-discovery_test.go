@@ -0,0 +1,30 @@
+package engines
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDiscoveredModelsAreReadUnderLock(t *testing.T) {
+	engine := &RemoteInferenceEngine{Protocol: ProtocolMock}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for contextLength := 1; contextLength <= 100; contextLength++ {
+			setDiscoveredModels(engine, []*ModelInfo{
+				{Name: "mock", ContextLength: contextLength, EmbeddingsDims: 16, Embeddings: true},
+			})
+		}
+	}()
+	for idx := 0; idx < 100; idx++ {
+		_ = engine.GetContextLength()
+		_ = engine.GetEmbeddingsDims()
+	}
+	wg.Wait()
+
+	if engine.GetContextLength() != 100 || engine.GetEmbeddingsDims() != 16 {
+		t.Fatalf("refreshed models aren't reported: %d, %d", engine.GetContextLength(), engine.GetEmbeddingsDims())
+	}
+}
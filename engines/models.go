@@ -0,0 +1,114 @@
+package engines
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MatchModel checks if model name matches the mask, empty mask and "*" match
+// any model, "*" in the mask matches any sequence of characters, matching is
+// case-insensitive, mask without a tag matches any tag, i.e. "llama2" matches "llama2:13b"
+func MatchModel(mask, model string) bool {
+	if mask == "" || mask == "*" {
+		return true
+	}
+
+	mask = strings.ToLower(mask)
+	model = strings.ToLower(model)
+	if matchWildcard(mask, model) {
+		return true
+	}
+
+	if !strings.Contains(mask, ":") {
+		if idx := strings.LastIndex(model, ":"); idx != -1 {
+			return matchWildcard(mask, model[:idx])
+		}
+	}
+
+	return false
+}
+
+func matchWildcard(mask, s string) bool {
+	parts := strings.Split(mask, "*")
+	if len(parts) == 1 {
+		return mask == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(s, part)
+		if idx == -1 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// GetModels returns a copy of models served by the engine
+func (engine *RemoteInferenceEngine) GetModels() []string {
+	engine.modelsLock.RLock()
+	defer engine.modelsLock.RUnlock()
+
+	models := make([]string, len(engine.Models))
+	copy(models, engine.Models)
+
+	return models
+}
+
+// SetModels replaces the list of models served by the engine
+func (engine *RemoteInferenceEngine) SetModels(models []string) {
+	engine.modelsLock.Lock()
+	defer engine.modelsLock.Unlock()
+
+	engine.Models = models
+}
+
+// ResolveModel returns the first engine's model matching the mask,
+// empty string is returned if engine doesn't know its models
+func (engine *RemoteInferenceEngine) ResolveModel(mask string) (string, bool) {
+	engine.modelsLock.RLock()
+	defer engine.modelsLock.RUnlock()
+
+	if len(engine.Models) == 0 {
+		return "", true
+	}
+
+	for _, model := range engine.Models {
+		if MatchModel(mask, model) {
+			return model, true
+		}
+	}
+
+	return "", false
+}
+
+// RequestModel returns model name to send to the backends requiring it, if engine
+// doesn't know its models yet, mask is used as is, unless it has wildcards
+func (engine *RemoteInferenceEngine) RequestModel(mask string) (string, error) {
+	model, ok := engine.ResolveModel(mask)
+	if !ok {
+		return "", fmt.Errorf("no model matching %s on %s", mask, engine.EndpointUrl)
+	}
+
+	if model == "" {
+		if mask == "" || strings.Contains(mask, "*") {
+			return "", fmt.Errorf("no models known on %s, can't resolve %s", engine.EndpointUrl, mask)
+		}
+		model = mask
+	}
+
+	return model, nil
+}
+
+// ServesModel checks if engine can run jobs for the model mask,
+// engines which don't report their models are assumed to serve anything
+func (engine *RemoteInferenceEngine) ServesModel(mask string) bool {
+	_, ok := engine.ResolveModel(mask)
+	return ok
+}
@@ -0,0 +1,83 @@
+package engines
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SamplingParams are optional generation parameters, zero values mean engine's defaults,
+// drivers pass those their backends support, the rest is ignored
+type SamplingParams struct {
+	MaxTokens        int     `json:"max-tokens,omitempty"`
+	TopP             float32 `json:"top-p,omitempty"`
+	TopK             int     `json:"top-k,omitempty"`
+	Seed             *int    `json:"seed,omitempty"`
+	PresencePenalty  float32 `json:"presence-penalty,omitempty"`
+	FrequencyPenalty float32 `json:"frequency-penalty,omitempty"`
+	Logprobs         int     `json:"logprobs,omitempty"` // number of most likely tokens to return with each token
+	Echo             bool    `json:"echo,omitempty"`     // return prompt along with the completion
+}
+
+// Signature is used as a part of the cache key and to put only jobs
+// with the same parameters into a batch, it's empty for the defaults
+func (p *SamplingParams) Signature() string {
+	parts := make([]string, 0, 8)
+	if p.MaxTokens != 0 {
+		parts = append(parts, fmt.Sprintf("max-tokens=%d", p.MaxTokens))
+	}
+	if p.TopP != 0 {
+		parts = append(parts, fmt.Sprintf("top-p=%g", p.TopP))
+	}
+	if p.TopK != 0 {
+		parts = append(parts, fmt.Sprintf("top-k=%d", p.TopK))
+	}
+	if p.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *p.Seed))
+	}
+	if p.PresencePenalty != 0 {
+		parts = append(parts, fmt.Sprintf("presence-penalty=%g", p.PresencePenalty))
+	}
+	if p.FrequencyPenalty != 0 {
+		parts = append(parts, fmt.Sprintf("frequency-penalty=%g", p.FrequencyPenalty))
+	}
+	if p.Logprobs != 0 {
+		parts = append(parts, fmt.Sprintf("logprobs=%d", p.Logprobs))
+	}
+	if p.Echo {
+		parts = append(parts, "echo")
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// BatchSignature puts jobs into the same batch only if a driver sending the batch in a single request
+// would run each of them with its own settings, those drivers take them from the first job
+func (r *GenerationSettings) BatchSignature() string {
+	parts := []string{r.SamplingParams.Signature()}
+	if r.Temperature != 0 {
+		parts = append(parts, fmt.Sprintf("temperature=%g", r.Temperature))
+	}
+	if r.BestOf > 1 {
+		parts = append(parts, fmt.Sprintf("best-of=%d", r.BestOf))
+	}
+	if len(r.StopTokens) > 0 {
+		parts = append(parts, fmt.Sprintf("stop=%q", r.StopTokens))
+	}
+	if r.Model != "" {
+		parts = append(parts, "model="+r.Model)
+	}
+	if r.Grammar != "" {
+		parts = append(parts, "grammar="+r.Grammar)
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// maxTokensOr returns max tokens requested, or the driver's default
+func (p *SamplingParams) maxTokensOr(defaultMaxTokens int) int {
+	if p.MaxTokens > 0 {
+		return p.MaxTokens
+	}
+
+	return defaultMaxTokens
+}
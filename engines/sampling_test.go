@@ -0,0 +1,44 @@
+package engines
+
+import "testing"
+
+func TestSamplingSignature(t *testing.T) {
+	if (&SamplingParams{}).Signature() != "" {
+		t.Fatalf("default parameters should have empty signature")
+	}
+
+	seed := 0
+	signature := (&SamplingParams{MaxTokens: 16, TopP: 0.5, Seed: &seed}).Signature()
+	if signature != "max-tokens=16;top-p=0.5;seed=0" {
+		t.Fatalf("unexpected signature: %s", signature)
+	}
+}
+
+func TestBatchSignature(t *testing.T) {
+	settings := func(modify func(r *GenerationSettings)) string {
+		r := &GenerationSettings{Temperature: 0.7, StopTokens: []string{"###"}, Model: "mistral*"}
+		modify(r)
+		return r.BatchSignature()
+	}
+	base := settings(func(r *GenerationSettings) {})
+
+	if settings(func(r *GenerationSettings) { r.RawPrompt = "other prompt" }) != base {
+		t.Fatalf("jobs with different prompts should share a batch")
+	}
+	if settings(func(r *GenerationSettings) { r.BestOf = 1 }) != base {
+		t.Fatalf("best of 1 is the default")
+	}
+
+	for name, modify := range map[string]func(r *GenerationSettings){
+		"temperature": func(r *GenerationSettings) { r.Temperature = 0.1 },
+		"stop tokens": func(r *GenerationSettings) { r.StopTokens = []string{"\n"} },
+		"model":       func(r *GenerationSettings) { r.Model = "llama*" },
+		"grammar":     func(r *GenerationSettings) { r.Grammar = `root ::= "yes"` },
+		"best of":     func(r *GenerationSettings) { r.BestOf = 3 },
+		"sampling":    func(r *GenerationSettings) { r.MaxTokens = 16 },
+	} {
+		if settings(modify) == base {
+			t.Fatalf("jobs with different %s shouldn't share a batch", name)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package engines
+
+// RunTokenizeRequest returns token ids of text, as seen by the engine's model matching the mask,
+// ErrNotSupported is returned if engine's driver can't tokenize
+func RunTokenizeRequest(inferenceEngine *RemoteInferenceEngine, model string, text string) ([]int, error) {
+	driver, err := GetDriver(inferenceEngine.Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.Tokenize(inferenceEngine, model, text)
+}
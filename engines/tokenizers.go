@@ -0,0 +1,98 @@
+package engines
+
+import (
+	"fmt"
+	"github.com/d0rc/agent-os/tokenizers"
+	"sync"
+)
+
+type registeredTokenizer struct {
+	modelMask string
+	tokenizer tokenizers.Tokenizer
+}
+
+var tokenizersRegistry []registeredTokenizer
+var tokenizersRegistryLock = sync.RWMutex{}
+
+// RegisterTokenizer makes tokenizer used for the models matching the mask,
+// tokenizers registered first take precedence
+func RegisterTokenizer(modelMask string, tokenizer tokenizers.Tokenizer) {
+	tokenizersRegistryLock.Lock()
+	defer tokenizersRegistryLock.Unlock()
+
+	tokenizersRegistry = append(tokenizersRegistry, registeredTokenizer{
+		modelMask: modelMask,
+		tokenizer: tokenizer,
+	})
+}
+
+// GetTokenizer returns tokenizer registered for the model, or nil
+func GetTokenizer(model string) tokenizers.Tokenizer {
+	tokenizersRegistryLock.RLock()
+	defer tokenizersRegistryLock.RUnlock()
+
+	for _, registered := range tokenizersRegistry {
+		if MatchModel(registered.modelMask, model) {
+			return registered.tokenizer
+		}
+	}
+
+	return nil
+}
+
+// GetTokenizer returns tokenizer of the engine's model matching the mask, it's
+// the registered one, or the engine's own, if it can tokenize, or GPT-2 otherwise
+func (engine *RemoteInferenceEngine) GetTokenizer(mask string) tokenizers.Tokenizer {
+	model := engine.tokenizerModel(mask)
+	if tokenizer := GetTokenizer(model); tokenizer != nil {
+		return tokenizer
+	}
+
+	if engine.CanTokenize {
+		return &engineTokenizer{engine: engine, model: model}
+	}
+
+	return tokenizers.GPT2()
+}
+
+// localTokenizer is the registered tokenizer of the model, or GPT-2, it never asks the engine,
+// so it's used where a round trip per text is too expensive, i.e. to count tokens of every job
+func (engine *RemoteInferenceEngine) localTokenizer(mask string) tokenizers.Tokenizer {
+	if tokenizer := GetTokenizer(engine.tokenizerModel(mask)); tokenizer != nil {
+		return tokenizer
+	}
+
+	return tokenizers.GPT2()
+}
+
+func (engine *RemoteInferenceEngine) tokenizerModel(mask string) string {
+	model, _ := engine.ResolveModel(mask)
+	if model == "" {
+		model = mask
+	}
+
+	return model
+}
+
+// engineTokenizer asks the engine to tokenize text
+type engineTokenizer struct {
+	engine *RemoteInferenceEngine
+	model  string
+}
+
+func (t *engineTokenizer) Name() string {
+	return fmt.Sprintf("engine:%s", t.model)
+}
+
+func (t *engineTokenizer) Encode(text string) ([]int, error) {
+	return RunTokenizeRequest(t.engine, t.model, text)
+}
+
+func (t *engineTokenizer) Decode(tokens []int) (string, error) {
+	driver, err := GetDriver(t.engine.Protocol)
+	if err != nil {
+		return "", err
+	}
+
+	return driver.Detokenize(t.engine, t.model, tokens)
+}
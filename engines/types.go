@@ -5,6 +5,7 @@ import (
 	"github.com/d0rc/agent-os/vectors"
 	"github.com/google/uuid"
 	"sync"
+	"time"
 )
 
 type ChatRole string
@@ -21,6 +22,7 @@ type Message struct {
 	MetaInfo interface{}         `json:"meta,omitempty"`
 	Role     ChatRole            `json:"role"`
 	Content  string              `json:"content"`
+	Logprobs []TokenLogprob      `json:"logprobs,omitempty"` // set if requested and engine supports it
 	lock     sync.RWMutex
 }
 
@@ -48,22 +50,35 @@ type GenerationSettings struct {
 	Messages           []Message                  `json:"messages"`
 	AfterJoinPrefix    string                     `json:"after_join_prefix"`
 	RawPrompt          string                     `json:"raw_prompt"`
-	NoCache            bool                       `json:"no_cache"`
+	NoCache            bool                       `json:"no_cache"`     // cached choices must not be returned
+	CachePolicy        string                     `json:"cache_policy"` // request's cache policy, federation peers apply it to their caches
 	Temperature        float32                    `json:"temperature"`
 	StopTokens         []string                   `json:"stop_tokens"`
 	BestOf             int                        `json:"best_of"`
-	StatisticsCallback func(info *StatisticsInfo) `json:"statistics_callback"`
+	StatisticsCallback func(info *StatisticsInfo) `json:"-"`
 	MaxRetries         int                        `json:"max_retries"`
+	LocalOnly          bool                       `json:"local_only"`     // never forward to federation peers
+	Grammar            string                     `json:"grammar"`        // GBNF, ignored by drivers which can't constrain sampling
+	Model              string                     `json:"model"`          // model mask, empty or * - any model
+	Truncation         TruncationStrategy         `json:"truncation"`     // what to do if prompt doesn't fit into the context window
+	ContextTokens      int                        `json:"-"`              // prompt and completion tokens, set by FitContext, 0 - unknown
+	Fallback           []string                   `json:"fallback"`       // model masks to switch to, if model has no healthy node, scheduler updates Model
+	FallbackWait       time.Duration              `json:"fallback_wait"`  // time to wait for each model before switching, 0 - default
+	RequireLabels      map[string]string          `json:"require_labels"` // job runs only on nodes with all of these labels
+	PreferLabels       map[string]string          `json:"prefer_labels"`  // nodes with more of these labels get the job first
+	SamplingParams
 }
 
 type StatisticsInfo struct {
 	TokensProcessed int
 	TokensGenerated int
 	PromptTokens    int
+	Model           string
 }
 
 type JobQueueTask struct {
 	Req           *GenerationSettings
 	Res           chan *Message
 	ResEmbeddings chan *vectors.Vector
+	Stats         *StatisticsInfo // set by drivers if backend reports usage
 }
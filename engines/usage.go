@@ -0,0 +1,54 @@
+package engines
+
+import (
+	"github.com/d0rc/agent-os/tokenizers"
+	"github.com/d0rc/agent-os/utils"
+	"sync/atomic"
+)
+
+// accountUsage makes sure every job of the batch has its token usage, it's taken from the backend
+// if it reports it, otherwise tokens are counted by the model's local tokenizer, asking the engine
+// to tokenize would take two more round trips per job
+func accountUsage(inferenceEngine *RemoteInferenceEngine, batch []*JobQueueTask, results []*Message) {
+	for idx, job := range batch {
+		// driver may return less results than jobs, there's nothing to account for the rest
+		if idx >= len(results) || results[idx] == nil {
+			continue
+		}
+
+		if job.Stats == nil {
+			prompt, _ := inferenceEngine.RenderPrompt(job.Req)
+			tokenizer := inferenceEngine.localTokenizer(job.Req.Model)
+			job.Stats = &StatisticsInfo{
+				PromptTokens:    countTokens(tokenizer, prompt),
+				TokensGenerated: countTokens(tokenizer, results[idx].Content),
+			}
+		}
+		job.Stats.TokensProcessed = job.Stats.PromptTokens + job.Stats.TokensGenerated
+		if job.Stats.Model == "" {
+			job.Stats.Model, _ = inferenceEngine.ResolveModel(job.Req.Model)
+		}
+
+		atomic.AddUint64(&inferenceEngine.PromptTokens, uint64(job.Stats.PromptTokens))
+		atomic.AddUint64(&inferenceEngine.TokensGenerated, uint64(job.Stats.TokensGenerated))
+		atomic.AddUint64(&inferenceEngine.TokensProcessed, uint64(job.Stats.TokensProcessed))
+
+		if job.Req.StatisticsCallback != nil {
+			job.Req.StatisticsCallback(job.Stats)
+		}
+	}
+}
+
+func countTokens(tokenizer tokenizers.Tokenizer, text string) int {
+	if text == "" {
+		return 0
+	}
+
+	// if tokenizer fails, the estimate is still better than nothing
+	count, err := tokenizers.Count(tokenizer, text)
+	if err == nil {
+		return count
+	}
+
+	return utils.CountTokensGPT2(text)
+}
@@ -0,0 +1,52 @@
+package engines
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUsageIsCountedWithoutAskingEngine(t *testing.T) {
+	requests := int64(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		_, _ = w.Write([]byte(`{"tokens": [1, 2, 3]}`))
+	}))
+	defer server.Close()
+
+	engine := &RemoteInferenceEngine{
+		EndpointUrl: server.URL,
+		Protocol:    ProtocolLlamaCpp,
+		CanTokenize: true,
+	}
+	job := &JobQueueTask{Req: &GenerationSettings{RawPrompt: "Hello world, how are you?"}}
+	accountUsage(engine, []*JobQueueTask{job}, []*Message{{Role: ChatRoleAssistant, Content: "I'm fine"}})
+
+	if atomic.LoadInt64(&requests) != 0 {
+		t.Fatalf("engine shouldn't be asked to tokenize, got %d requests", requests)
+	}
+	if job.Stats.PromptTokens == 0 || job.Stats.TokensGenerated == 0 {
+		t.Fatalf("tokens should be counted locally: %+v", job.Stats)
+	}
+	if engine.TokensProcessed != uint64(job.Stats.PromptTokens+job.Stats.TokensGenerated) {
+		t.Fatalf("engine's counters aren't updated: %d", engine.TokensProcessed)
+	}
+}
+
+func TestUsageSkipsJobsWithoutResults(t *testing.T) {
+	engine := &RemoteInferenceEngine{Protocol: ProtocolLlamaCpp}
+	batch := []*JobQueueTask{
+		{Req: &GenerationSettings{RawPrompt: "first"}},
+		{Req: &GenerationSettings{RawPrompt: "second"}},
+		{Req: &GenerationSettings{RawPrompt: "third"}},
+	}
+	accountUsage(engine, batch, []*Message{{Role: ChatRoleAssistant, Content: "one"}, nil})
+
+	if batch[0].Stats == nil {
+		t.Fatalf("job with result should be accounted")
+	}
+	if batch[1].Stats != nil || batch[2].Stats != nil {
+		t.Fatalf("jobs without results shouldn't be accounted")
+	}
+}
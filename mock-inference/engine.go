@@ -0,0 +1,188 @@
+package mock_inference
+
+import (
+	"errors"
+	"fmt"
+	"github.com/d0rc/agent-os/settings"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultModel = "mock"
+const DefaultEmbeddingsDims = 384
+
+// ErrInjectedFailure is returned for the batches failed according to failure rate
+var ErrInjectedFailure = errors.New("mock engine failure")
+
+// ErrBatchTooLarge is returned for the batches exceeding max batch size
+var ErrBatchTooLarge = errors.New("batch is too large for mock engine")
+
+var words = strings.Fields(`the a an agent task plan result answer question data model search
+	find check run build write read update list value note step next first final report tool
+	user system context goal idea and or with for from to of in on is are was will should can`)
+
+// Engine generates deterministic completions and embeddings, simulating
+// latency and failures of a real inference engine
+type Engine struct {
+	config *settings.MockConfigurationSection
+	lock   sync.Mutex
+	random *rand.Rand // jitter and failures
+}
+
+// NewEngine creates mock engine, nil config means defaults: no latency, no failures
+func NewEngine(config *settings.MockConfigurationSection) *Engine {
+	if config == nil {
+		config = &settings.MockConfigurationSection{}
+	}
+
+	return &Engine{
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+func (e *Engine) Models() []string {
+	if len(e.config.Models) == 0 {
+		return []string{DefaultModel}
+	}
+
+	return e.config.Models
+}
+
+func (e *Engine) EmbeddingsDims() int {
+	if e.config.EmbeddingsDims <= 0 {
+		return DefaultEmbeddingsDims
+	}
+
+	return e.config.EmbeddingsDims
+}
+
+func (e *Engine) ContextLength() int {
+	return e.config.ContextLength
+}
+
+// Complete returns completions of the prompts, scripted ones, or made of words picked by prompt's hash,
+// at most maxTokens words are generated, if it's set, texts are cut at the first stop token
+func (e *Engine) Complete(model string, prompts []string, maxTokens int, stop []string) ([]string, error) {
+	if err := e.runBatch(len(prompts)); err != nil {
+		return nil, err
+	}
+
+	results := make([]string, len(prompts))
+	for idx, prompt := range prompts {
+		results[idx] = cutAtStop(e.completion(model, prompt, maxTokens), stop)
+	}
+
+	return results, nil
+}
+
+// Embed returns normalized embeddings of the inputs, each word adds its own hash-based vector,
+// so inputs sharing words are similar
+func (e *Engine) Embed(inputs []string) ([][]float64, error) {
+	if err := e.runBatch(len(inputs)); err != nil {
+		return nil, err
+	}
+
+	results := make([][]float64, len(inputs))
+	for idx, input := range inputs {
+		results[idx] = e.embedding(input)
+	}
+
+	return results, nil
+}
+
+// runBatch sleeps for the configured latency and decides if batch fails
+func (e *Engine) runBatch(size int) error {
+	if e.config.MaxBatchSize > 0 && size > e.config.MaxBatchSize {
+		return fmt.Errorf("%w: %d jobs, max is %d", ErrBatchTooLarge, size, e.config.MaxBatchSize)
+	}
+
+	e.lock.Lock()
+	latency := time.Duration(e.config.LatencyMs) * time.Millisecond
+	if e.config.JitterMs > 0 {
+		latency += time.Duration(e.random.Intn(e.config.JitterMs)) * time.Millisecond
+	}
+	failed := e.config.FailureRate > 0 && e.random.Float64() < e.config.FailureRate
+	e.lock.Unlock()
+
+	time.Sleep(latency)
+	if failed {
+		return ErrInjectedFailure
+	}
+
+	return nil
+}
+
+func (e *Engine) completion(model, prompt string, maxTokens int) string {
+	for _, entry := range e.config.Script {
+		if strings.Contains(prompt, entry.PromptContains) {
+			return entry.Response
+		}
+	}
+
+	random := rand.New(rand.NewSource(e.hash(model, prompt)))
+	length := 8 + random.Intn(24)
+	if maxTokens > 0 && length > maxTokens {
+		length = maxTokens
+	}
+
+	text := make([]string, length)
+	for idx := range text {
+		text[idx] = words[random.Intn(len(words))]
+	}
+
+	return " " + strings.Join(text, " ")
+}
+
+func (e *Engine) embedding(input string) []float64 {
+	vector := make([]float64, e.EmbeddingsDims())
+	for _, word := range strings.Fields(strings.ToLower(input)) {
+		random := rand.New(rand.NewSource(e.hash("", word)))
+		for idx := range vector {
+			vector[idx] += random.NormFloat64()
+		}
+	}
+
+	norm := 0.0
+	for _, value := range vector {
+		norm += value * value
+	}
+	if norm == 0 {
+		vector[0], norm = 1, 1
+	}
+	norm = math.Sqrt(norm)
+	for idx := range vector {
+		vector[idx] /= norm
+	}
+
+	return vector
+}
+
+func (e *Engine) hash(model, text string) int64 {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d\x00%s\x00%s", e.config.Seed, model, text)
+
+	return int64(h.Sum64())
+}
+
+func cutAtStop(text string, stop []string) string {
+	for _, token := range stop {
+		if token == "" {
+			continue
+		}
+		if idx := strings.Index(text, token); idx != -1 {
+			text = text[:idx]
+		}
+	}
+
+	return text
+}
+
+// CountTokens approximates number of tokens in the text by the number of words
+func CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
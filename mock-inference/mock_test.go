@@ -0,0 +1,134 @@
+package mock_inference_test
+
+import (
+	"errors"
+	"github.com/d0rc/agent-os/engines"
+	mock_inference "github.com/d0rc/agent-os/mock-inference"
+	"github.com/d0rc/agent-os/settings"
+	"testing"
+)
+
+func startEngine(t *testing.T, engine *engines.RemoteInferenceEngine) *engines.RemoteInferenceEngine {
+	done := make(chan struct{}, 1)
+	engines.StartInferenceEngine(engine, done)
+	<-done
+	if engine.CompletionFailed || engine.EmbeddingsFailed {
+		t.Fatalf("engine failed to start: %+v", engine)
+	}
+
+	return engine
+}
+
+func complete(t *testing.T, engine *engines.RemoteInferenceEngine, prompts ...string) []string {
+	batch := make([]*engines.JobQueueTask, len(prompts))
+	for idx, prompt := range prompts {
+		batch[idx] = &engines.JobQueueTask{Req: &engines.GenerationSettings{RawPrompt: prompt, Model: "mock"}}
+	}
+	results, err := engines.RunCompletionRequest(engine, batch)
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+
+	texts := make([]string, len(results))
+	for idx, result := range results {
+		texts[idx] = result.Content
+	}
+
+	return texts
+}
+
+func TestServer(t *testing.T) {
+	server := mock_inference.StartServer(&settings.MockConfigurationSection{
+		EmbeddingsDims: 16,
+		ContextLength:  2048,
+		MaxBatchSize:   2,
+		Script:         []settings.MockScriptResponse{{PromptContains: "2 + 2", Response: " 4"}},
+	})
+	defer server.Close()
+
+	engine := startEngine(t, &engines.RemoteInferenceEngine{
+		EndpointUrl:           server.CompletionsUrl(),
+		EmbeddingsEndpointUrl: server.EmbeddingsUrl(),
+		Protocol:              engines.ProtocolOpenAI,
+	})
+	if !engine.ServesModel("mock") || engine.ContextLength != 2048 || *engine.EmbeddingsDims != 16 {
+		t.Fatalf("models are not discovered: %v, %d, %d", engine.GetModels(), engine.ContextLength, *engine.EmbeddingsDims)
+	}
+
+	first := complete(t, engine, "hello", "what is 2 + 2?")
+	second := complete(t, engine, "hello")
+	if first[0] == "" || first[0] != second[0] {
+		t.Fatalf("completions are not deterministic: %q, %q", first[0], second[0])
+	}
+	if first[1] != " 4" {
+		t.Fatalf("scripted response expected, got %q", first[1])
+	}
+
+	_, err := engines.RunCompletionRequest(engine, []*engines.JobQueueTask{
+		{Req: &engines.GenerationSettings{RawPrompt: "1"}},
+		{Req: &engines.GenerationSettings{RawPrompt: "2"}},
+		{Req: &engines.GenerationSettings{RawPrompt: "3"}},
+	})
+	if err == nil {
+		t.Fatalf("batch larger than max batch size should fail")
+	}
+}
+
+func TestDriver(t *testing.T) {
+	engine := startEngine(t, &engines.RemoteInferenceEngine{
+		Protocol: engines.ProtocolMock,
+		Mock:     &settings.MockConfigurationSection{Models: []string{"mock-7b"}, Seed: 42},
+	})
+	if !engine.ServesModel("mock-7b") || *engine.EmbeddingsDims != mock_inference.DefaultEmbeddingsDims {
+		t.Fatalf("mock models are not reported: %v", engine.GetModels())
+	}
+
+	embeddings, err := engines.RunEmbeddingsRequest(engine, []*engines.JobQueueTask{
+		{Req: &engines.GenerationSettings{RawPrompt: "red apple"}},
+		{Req: &engines.GenerationSettings{RawPrompt: "green apple"}},
+		{Req: &engines.GenerationSettings{RawPrompt: "quantum physics"}},
+	})
+	if err != nil {
+		t.Fatalf("embeddings failed: %v", err)
+	}
+	similarity := func(a, b []float64) float64 {
+		result := 0.0
+		for idx := range a {
+			result += a[idx] * b[idx]
+		}
+		return result
+	}
+	if similarity(embeddings[0].VecF64, embeddings[1].VecF64) <= similarity(embeddings[0].VecF64, embeddings[2].VecF64) {
+		t.Fatalf("texts sharing words should be more similar")
+	}
+
+	failing := mock_inference.NewEngine(&settings.MockConfigurationSection{FailureRate: 1})
+	if _, err = failing.Complete("mock", []string{"hello"}, 0, nil); !errors.Is(err, mock_inference.ErrInjectedFailure) {
+		t.Fatalf("expected injected failure, got %v", err)
+	}
+}
+
+func TestServerGetsRequestedModel(t *testing.T) {
+	config := &settings.MockConfigurationSection{Models: []string{"mock-a", "mock-b"}, EmbeddingsDims: 16}
+	server := mock_inference.StartServer(config)
+	defer server.Close()
+
+	engine := startEngine(t, &engines.RemoteInferenceEngine{
+		EndpointUrl:           server.CompletionsUrl(),
+		EmbeddingsEndpointUrl: server.EmbeddingsUrl(),
+		Protocol:              engines.ProtocolOpenAI,
+	})
+
+	for _, model := range []string{"mock-a", "mock-b"} {
+		results, err := engines.RunCompletionRequest(engine, []*engines.JobQueueTask{
+			{Req: &engines.GenerationSettings{RawPrompt: "hello", Model: model}},
+		})
+		if err != nil {
+			t.Fatalf("completion failed: %v", err)
+		}
+		expected, _ := mock_inference.NewEngine(config).Complete(model, []string{"hello"}, 512, []string{"###"})
+		if results[0].Content != expected[0] {
+			t.Fatalf("completion isn't generated by %s: %q", model, results[0].Content)
+		}
+	}
+}
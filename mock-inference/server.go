@@ -0,0 +1,216 @@
+package mock_inference
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/d0rc/agent-os/settings"
+	"net/http"
+	"net/http/httptest"
+)
+
+// Server is an in-process fake of OpenAI-compatible inference server,
+// serving /v1/completions, /v1/embeddings and /v1/models
+type Server struct {
+	Engine *Engine
+	URL    string // root URL, i.e. http://127.0.0.1:41234
+	server *httptest.Server
+}
+
+// StartServer starts fake server on a random local port
+func StartServer(config *settings.MockConfigurationSection) *Server {
+	engine := NewEngine(config)
+	server := httptest.NewServer(engine.Handler())
+
+	return &Server{
+		Engine: engine,
+		URL:    server.URL,
+		server: server,
+	}
+}
+
+func (s *Server) CompletionsUrl() string {
+	return s.URL + "/v1/completions"
+}
+
+func (s *Server) EmbeddingsUrl() string {
+	return s.URL + "/v1/embeddings"
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Handler returns HTTP handler serving the engine with OpenAI API
+func (e *Engine) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/completions", e.handleCompletions)
+	mux.HandleFunc("/v1/embeddings", e.handleEmbeddings)
+	mux.HandleFunc("/v1/models", e.handleModels)
+
+	return mux
+}
+
+type completionRequest struct {
+	Model     string          `json:"model"`
+	Prompt    json.RawMessage `json:"prompt"`
+	MaxTokens int             `json:"max_tokens"`
+	Stop      []string        `json:"stop"`
+}
+
+type completionChoice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type completionResponse struct {
+	Object  string             `json:"object"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	Usage   usage              `json:"usage"`
+}
+
+type embeddingsRequest struct {
+	Model string          `json:"model"`
+	Input json.RawMessage `json:"input"`
+}
+
+type embedding struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+type embeddingsResponse struct {
+	Object string      `json:"object"`
+	Model  string      `json:"model"`
+	Data   []embedding `json:"data"`
+}
+
+func (e *Engine) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	req := &completionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	prompts, err := parseStrings(req.Prompt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	model := e.requestModel(req.Model)
+
+	texts, err := e.Complete(model, prompts, req.MaxTokens, req.Stop)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	response := &completionResponse{
+		Object:  "text_completion",
+		Model:   model,
+		Choices: make([]completionChoice, len(texts)),
+	}
+	for idx, text := range texts {
+		response.Choices[idx] = completionChoice{Index: idx, Text: text, FinishReason: "stop"}
+		response.Usage.PromptTokens += CountTokens(prompts[idx])
+		response.Usage.CompletionTokens += CountTokens(text)
+	}
+	response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+
+	writeJSON(w, response)
+}
+
+func (e *Engine) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	req := &embeddingsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	inputs, err := parseStrings(req.Input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	vectors, err := e.Embed(inputs)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	response := &embeddingsResponse{
+		Object: "list",
+		Model:  e.requestModel(req.Model),
+		Data:   make([]embedding, len(vectors)),
+	}
+	for idx, vector := range vectors {
+		response.Data[idx] = embedding{Object: "embedding", Index: idx, Embedding: vector}
+	}
+
+	writeJSON(w, response)
+}
+
+func (e *Engine) handleModels(w http.ResponseWriter, _ *http.Request) {
+	type model struct {
+		Id          string `json:"id"`
+		Object      string `json:"object"`
+		MaxModelLen int    `json:"max_model_len,omitempty"`
+	}
+
+	models := make([]model, 0, len(e.Models()))
+	for _, name := range e.Models() {
+		models = append(models, model{Id: name, Object: "model", MaxModelLen: e.ContextLength()})
+	}
+
+	writeJSON(w, map[string]interface{}{"object": "list", "data": models})
+}
+
+func (e *Engine) requestModel(model string) string {
+	if model == "" {
+		return e.Models()[0]
+	}
+
+	return model
+}
+
+// parseStrings accepts both a single string and a list of strings
+func parseStrings(data json.RawMessage) ([]string, error) {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var list []string
+	err := json.Unmarshal(data, &list)
+
+	return list, err
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeEngineError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrBatchTooLarge) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": err.Error(), "type": "server_error"},
+	})
+}
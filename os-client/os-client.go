@@ -112,6 +112,8 @@ func isRequestEmpty(req *cmds.ClientRequest) bool {
 	isEmpty = isEmpty && (req.GetCacheRecords == nil || len(req.GetCacheRecords) == 0)
 	isEmpty = isEmpty && (req.SetCacheRecords == nil || len(req.SetCacheRecords) == 0)
 	isEmpty = isEmpty && (req.GoogleSearchRequests == nil || len(req.GoogleSearchRequests) == 0)
+	isEmpty = isEmpty && req.GetComputeCapacity == nil
+	isEmpty = isEmpty && (req.TokenizeRequests == nil || len(req.TokenizeRequests) == 0)
 
 	return isEmpty
 }
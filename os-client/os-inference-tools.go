@@ -1,6 +1,7 @@
 package os_client
 
 import (
+	"fmt"
 	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
 	"github.com/d0rc/agent-os/cmds"
 	"time"
@@ -20,3 +21,25 @@ func ProcessGetCompletions(request []cmds.GetCompletionRequest, ctx *AgentOSClie
 
 	return resp, nil
 }
+
+// ProcessTokenize tokenizes, detokenizes or counts tokens with the tokenizer of the model
+func ProcessTokenize(request []cmds.TokenizeRequest, ctx *AgentOSClient) (response *cmds.ServerResponse, err error) {
+	resp, err := ctx.RunRequest(&cmds.ClientRequest{
+		TokenizeRequests: request,
+	}, 60*time.Second, REP_IO)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.TokenizeResponse) != len(request) {
+		return nil, fmt.Errorf("got %d tokenize responses for %d requests", len(resp.TokenizeResponse), len(request))
+	}
+
+	for _, tokenizeResponse := range resp.TokenizeResponse {
+		if tokenizeResponse.Error != "" {
+			return nil, fmt.Errorf("tokenize request failed: %s", tokenizeResponse.Error)
+		}
+	}
+
+	return resp, nil
+}
@@ -19,14 +19,24 @@ const (
 	VDB_QDRANT VectorDBType = "qdrant"
 )
 
-const defaultCollectionNamePrefix = "embeddings-llm-cache"
-
 type EmbeddingsQueueRecord struct {
 	Id           int64  `db:"id"`
 	QueueName    string `db:"queue_name"`
 	QueuePointer int64  `db:"queue_pointer"`
 }
 
+// llmCacheQueueName is the queue of LLM cache records, which points are inserted with the namespace
+// payload and record scoped ids, points inserted before were keyed by the text and had no namespace,
+// semantic cache never finds them, so the queue is started anew to insert the points of all the records
+func llmCacheQueueName(collection string) string {
+	return collection + "-records"
+}
+
+// pointId is scoped by the record, so records of the same text don't overwrite each other's points
+func pointId(namespace string, recordId int64) string {
+	return uuid.NewHash(sha512.New(), uuid.Nil, []byte(fmt.Sprintf("%s:%d", namespace, recordId)), 5).String()
+}
+
 func BackgroundEmbeddingsWorker(ctx *server.Context, name string) {
 	// let's see what we have in our vector DBs configs
 	lg := ctx.Log.With().Str("bg-wrk", "embeddings").Logger()
@@ -46,21 +56,28 @@ func BackgroundEmbeddingsWorker(ctx *server.Context, name string) {
 	}
 
 	defaultVectorStorage := ctx.VectorDBs[0]
-	defaultCollectionName := fmt.Sprintf("%s-%d",
-		defaultCollectionNamePrefix,
-		ctx.GetDefaultEmbeddingDims())
+	defaultCollectionName := ctx.GetLLMCacheCollection()
 	// let's find out what models for embeddings do we have at hand
 	// this can only be done by using completion command which will scan available models
 	// let's start processing embeddings, first lets read our queue pointer
 	// and then start processing embeddings
-	pointers := make([]EmbeddingsQueueRecord, 0, 1)
+	// queue named by the collection is the one of text keyed points
+	legacyPointers := make([]EmbeddingsQueueRecord, 0, 1)
 	err := ctx.Storage.Db.GetStructsSlice("get-embeddings-queue-pointer",
-		&pointers,
+		&legacyPointers,
 		defaultCollectionName)
 	if err != nil {
 		ctx.Log.Error().Err(err).Msg("error getting embeddings queue pointer")
 		return
 	}
+	pointers := make([]EmbeddingsQueueRecord, 0, 1)
+	err = ctx.Storage.Db.GetStructsSlice("get-embeddings-queue-pointer",
+		&pointers,
+		llmCacheQueueName(defaultCollectionName))
+	if err != nil {
+		ctx.Log.Error().Err(err).Msg("error getting embeddings queue pointer")
+		return
+	}
 
 	if ctx.GetDefaultEmbeddingDims() == 0 {
 		ctx.Log.Error().Msg("no default embedding dims found")
@@ -69,7 +86,8 @@ func BackgroundEmbeddingsWorker(ctx *server.Context, name string) {
 
 	ctx.Log.Info().Msgf("default embedding dims: %d", ctx.GetDefaultEmbeddingDims())
 
-	if len(pointers) == 0 || pointers[0].QueuePointer == 0 {
+	if (len(pointers) == 0 || pointers[0].QueuePointer == 0) &&
+		(len(legacyPointers) == 0 || legacyPointers[0].QueuePointer == 0) {
 		// there's no queue, it means we've never processed embeddings
 		// so, let's create a new collection in our vector storage
 		err = defaultVectorStorage.CreateCollection(defaultCollectionName, &vectors.CollectionParameters{
@@ -99,13 +117,14 @@ func BackgroundEmbeddingsWorker(ctx *server.Context, name string) {
 }
 
 func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[]EmbeddingsQueueRecord, ctx *server.Context, lg zerolog.Logger, process string, modelName string, modelDims int) {
+	queueName := llmCacheQueueName(collection)
 	pointersMap := make(map[string]*EmbeddingsQueueRecord)
 	for _, pointer := range *pointers {
 		pointersMap[pointer.QueueName] = &pointer
 	}
 
-	if pointersMap[collection] == nil {
-		res, err := ctx.Storage.Db.Exec("set-embeddings-queue-pointer", collection, 0)
+	if pointersMap[queueName] == nil {
+		res, err := ctx.Storage.Db.Exec("set-embeddings-queue-pointer", queueName, 0)
 		if err != nil {
 			lg.Error().Err(err).
 				Str("collection", collection).
@@ -119,9 +138,9 @@ func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[
 				Msg("error getting last insert id")
 			return
 		}
-		pointersMap[collection] = &EmbeddingsQueueRecord{
+		pointersMap[queueName] = &EmbeddingsQueueRecord{
 			Id:           id,
-			QueueName:    collection,
+			QueueName:    queueName,
 			QueuePointer: 0,
 		}
 	}
@@ -131,7 +150,7 @@ func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[
 		llmCacheRecords := make([]cmds.CompletionCacheRecord, 0, batchSize)
 		err := ctx.Storage.Db.GetStructsSlice("query-llm-cache-by-ids-multi",
 			&llmCacheRecords,
-			pointersMap[collection].QueuePointer,
+			pointersMap[queueName].QueuePointer,
 			batchSize)
 
 		if err != nil {
@@ -155,13 +174,13 @@ func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[
 			jobs = append(jobs, cmds.GetEmbeddingsRequest{
 				Model:           modelName,
 				RawPrompt:       llmCacheRecord.Prompt,
-				MetaNamespace:   "llm-cache-prompt",
+				MetaNamespace:   cmds.NamespaceLLMCachePrompt,
 				MetaNamespaceId: llmCacheRecord.Id,
 			})
 			jobs = append(jobs, cmds.GetEmbeddingsRequest{
 				Model:           modelName,
 				RawPrompt:       llmCacheRecord.GenerationResult,
-				MetaNamespace:   "llm-cache-generation",
+				MetaNamespace:   cmds.NamespaceLLMCacheGeneration,
 				MetaNamespaceId: llmCacheRecord.Id,
 			})
 
@@ -181,13 +200,15 @@ func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[
 
 		// let's write embeddings into our vector storage
 		vectorsSlice := make([]*vectors.Vector, 0, len(response.GetEmbeddingsResponse))
-		for _, embedding := range response.GetEmbeddingsResponse {
+		for idx, embedding := range response.GetEmbeddingsResponse {
 			vectorsSlice = append(vectorsSlice, &vectors.Vector{
-				Id:     uuid.NewHash(sha512.New(), uuid.Nil, []byte(embedding.TextHash), 5).String(),
+				Id:     pointId(jobs[idx].MetaNamespace, jobs[idx].MetaNamespaceId),
 				VecF64: embedding.Embeddings,
 				Payload: map[string]interface{}{
-					"model": embedding.Model,
-					"text":  embedding.Text,
+					"model":                 embedding.Model,
+					"text":                  embedding.Text,
+					cmds.PayloadNamespace:   jobs[idx].MetaNamespace,
+					cmds.PayloadNamespaceId: jobs[idx].MetaNamespaceId,
 				},
 			})
 		}
@@ -202,9 +223,9 @@ func processEmbeddings(vectorDb vectors.VectorDB, collection string, pointers *[
 			maxId,
 			len(response.GetEmbeddingsResponse),
 			time.Since(ts))*/
-		pointersMap[collection].QueuePointer = maxId
+		pointersMap[queueName].QueuePointer = maxId
 		// set the queue pointer
-		_, err = ctx.Storage.Db.Exec("set-embeddings-queue-pointer", collection, maxId)
+		_, err = ctx.Storage.Db.Exec("set-embeddings-queue-pointer", queueName, maxId)
 		if err != nil {
 			lg.Error().Err(err).
 				Str("collection", collection).
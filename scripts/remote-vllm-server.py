@@ -1,10 +1,17 @@
 from flask import Flask, request, jsonify
 from vllm import LLM, SamplingParams
+import argparse
 import time
 
+parser = argparse.ArgumentParser()
+parser.add_argument('--model', default="TheBloke/dolphin-2.2.1-mistral-7B-AWQ")
+parser.add_argument('--port', type=int, default=8000)
+args = parser.parse_args()
+
 app = Flask(__name__)
 
-llm = LLM(model="TheBloke/dolphin-2.2.1-mistral-7B-AWQ", quantization="awq", dtype="half")
+quantization = "awq" if "awq" in args.model.lower() else None
+llm = LLM(model=args.model, quantization=quantization, dtype="half")
 
 @app.route('/v1/completions', methods=['POST'])
 def generate():
@@ -43,4 +50,5 @@ def generate():
     return jsonify(response)
 
 if __name__ == '__main__':
-    app.run(host='0.0.0.0', port=8000)
+    # there's no auth, server is reached through ssh tunnel only
+    app.run(host='127.0.0.1', port=args.port)
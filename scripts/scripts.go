@@ -0,0 +1,9 @@
+package scripts
+
+import _ "embed"
+
+// RemoteVLLMServer serves a vLLM model with OpenAI-like completions API, it's uploaded to the boxes
+// by ai-server's -bootstrap, args are --model and --port
+//
+//go:embed remote-vllm-server.py
+var RemoteVLLMServer []byte
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	borrow_engine "github.com/d0rc/agent-os/borrow-engine"
+	"github.com/d0rc/agent-os/engines"
+	"github.com/d0rc/agent-os/scripts"
+	"github.com/d0rc/agent-os/sshtunnel"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"strings"
+	"time"
+)
+
+const bootstrapScript = "remote-vllm-server.py"
+
+// bootstrapTimeout is the time to install vLLM, download the model and load it
+const bootstrapTimeout = 30 * time.Minute
+const bootstrapPollInterval = 5 * time.Second
+
+var ErrBootstrapServerExited = errors.New("inference server exited")
+
+// BootstrapSettings describe a box, which gets vLLM server installed and started over SSH
+type BootstrapSettings struct {
+	Target       string // user@host[:port]
+	KeyFile      string // default is ~/.ssh/id_rsa
+	KnownHosts   string // known_hosts file to check box's host key with, empty - any key is accepted
+	Model        string
+	Port         int // port of the inference server on the box, default is 8000
+	MaxBatchSize int
+}
+
+// BootstrapNode uploads and starts the inference server, waits for it to load the model,
+// and adds the node to the compute router, server listens on box's loopback only,
+// and its port is always tunneled back over SSH
+func (ctx *Context) BootstrapNode(bootstrap *BootstrapSettings) error {
+	if bootstrap.Model == "" {
+		return errors.New("model to serve is not set")
+	}
+	keyFile := bootstrap.KeyFile
+	if keyFile == "" {
+		keyFile = "~/.ssh/id_rsa"
+	}
+	auth, err := sshtunnel.PrivateKeyFile(keyFile)
+	if err != nil {
+		return err
+	}
+	target := sshtunnel.NewEndpoint(bootstrap.Target)
+	if target.Port == 0 {
+		target.Port = 22
+	}
+	if target.User == "" {
+		target.User = os.Getenv("USER")
+	}
+	port := bootstrap.Port
+	if port == 0 {
+		port = 8000
+	}
+	lg := ctx.Log.With().Str("target", bootstrap.Target).Logger()
+
+	var knownHosts []string
+	if bootstrap.KnownHosts != "" {
+		knownHosts = append(knownHosts, bootstrap.KnownHosts)
+	}
+	hostKeyCallback, err := sshtunnel.KnownHosts(knownHosts...)
+	if err != nil {
+		return err
+	}
+	config := sshtunnel.ClientConfig(target.User, auth)
+	config.HostKeyCallback = hostKeyCallback
+
+	client, err := ssh.Dial("tcp", target.String(), config)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", target, err)
+	}
+	defer client.Close()
+
+	if !serverListening(client, port) {
+		lg.Info().Msg("uploading inference server")
+		if err := runRemote(client, "cat > "+bootstrapScript, scripts.RemoteVLLMServer); err != nil {
+			return fmt.Errorf("error uploading %s: %w", bootstrapScript, err)
+		}
+		lg.Info().Msg("installing vLLM")
+		if err := runRemote(client, "python3 -m pip install --quiet vllm flask", nil); err != nil {
+			return fmt.Errorf("error installing vLLM: %w", err)
+		}
+		lg.Info().Str("model", bootstrap.Model).Msg("starting inference server")
+		command := fmt.Sprintf("setsid nohup python3 %s --model %s --port %d < /dev/null > %s 2>&1 &",
+			bootstrapScript, shellQuote(bootstrap.Model), port, bootstrapLog(port))
+		if err := runRemote(client, command, nil); err != nil {
+			return fmt.Errorf("error starting inference server: %w", err)
+		}
+	}
+
+	if err := waitForServer(client, port); err != nil {
+		return err
+	}
+
+	tunnel, err := sshtunnel.NewSSHTunnel(fmt.Sprintf("%s@%s", target.User, target), auth,
+		fmt.Sprintf("127.0.0.1:%d", port), ctx.Log)
+	if err != nil {
+		return fmt.Errorf("error creating ssh tunnel: %w", err)
+	}
+	tunnel.Config.HostKeyCallback = hostKeyCallback
+	tunnel.Start()
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d/v1/completions", tunnel.Local.Port)
+
+	node := <-ctx.ComputeRouter.AddNode(&borrow_engine.InferenceNode{
+		EndpointUrl:  endpoint,
+		MaxRequests:  1,
+		MaxBatchSize: max(bootstrap.MaxBatchSize, 1),
+		JobTypes:     []borrow_engine.JobType{borrow_engine.JT_Completion},
+		Protocol:     engines.ProtocolOpenAI,
+		Models:       []string{bootstrap.Model},
+	})
+	if node.RemoteEngine.CompletionFailed {
+		tunnel.Stop()
+		return fmt.Errorf("bootstrapped node %s failed to run completion", endpoint)
+	}
+	lg.Info().Str("endpoint", endpoint).Msg("bootstrapped node is added")
+
+	return nil
+}
+
+// waitForServer waits for server to start listening, it only does once the model is loaded
+func waitForServer(client *ssh.Client, port int) error {
+	deadline := time.Now().Add(bootstrapTimeout)
+	for !serverListening(client, port) {
+		if !serverRunning(client, port) {
+			output, _ := outputRemote(client, "tail -n 20 "+bootstrapLog(port))
+			return fmt.Errorf("%w: %s", ErrBootstrapServerExited, output)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("inference server didn't start in %s", bootstrapTimeout)
+		}
+		time.Sleep(bootstrapPollInterval)
+	}
+
+	return nil
+}
+
+// serverRunning checks if server's process is alive, pattern is bracketed, so pgrep doesn't find the shell running it
+func serverRunning(client *ssh.Client, port int) bool {
+	pattern := fmt.Sprintf("[%s]%s .*--port %d", bootstrapScript[:1], bootstrapScript[1:], port)
+
+	return runRemote(client, "pgrep -f "+shellQuote(pattern), nil) == nil
+}
+
+func serverListening(client *ssh.Client, port int) bool {
+	conn, err := client.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// runRemote runs the command on the box, input is passed to its stdin, if it's set
+func runRemote(client *ssh.Client, command string, input []byte) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if input != nil {
+		session.Stdin = bytes.NewReader(input)
+	}
+	output, err := session.CombinedOutput(command)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+
+	return nil
+}
+
+func outputRemote(client *ssh.Client, command string) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	output, err := session.CombinedOutput(command)
+
+	return string(output), err
+}
+
+func bootstrapLog(port int) string {
+	return fmt.Sprintf("remote-vllm-server-%d.log", port)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
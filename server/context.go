@@ -6,9 +6,11 @@ import (
 	"github.com/d0rc/agent-os/engines"
 	"github.com/d0rc/agent-os/settings"
 	"github.com/d0rc/agent-os/storage"
+	"github.com/d0rc/agent-os/tokenizers"
 	"github.com/d0rc/agent-os/vectors"
 	"github.com/logrusorgru/aurora"
 	"github.com/rs/zerolog"
+	"io"
 	"os"
 	"time"
 )
@@ -20,12 +22,15 @@ type Context struct {
 	VectorDBs            []vectors.VectorDB
 	ComputeRouter        *borrow_engine.InferenceEngine
 	DefaultEmbeddingsDim int
+	bootstrap            []*BootstrapSettings
 }
 
 type Settings struct {
 	TopInterval time.Duration
 	TermUI      bool
 	LogChan     chan []byte
+	TraceWriter io.Writer // compute jobs are recorded to it, if set
+	Bootstrap   []*BootstrapSettings
 }
 
 func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*Context, error) {
@@ -41,6 +46,15 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 		os.Exit(1)
 	}
 
+	for _, tokenizerConfig := range config.Tokenizers {
+		tokenizer, err := tokenizers.Load(tokenizerConfig.Path)
+		if err != nil {
+			lg.Error().Err(err).Msgf("error loading tokenizer for %s", tokenizerConfig.Model)
+			continue
+		}
+		engines.RegisterTokenizer(tokenizerConfig.Model, tokenizer)
+	}
+
 	computeRouter := borrow_engine.NewInferenceEngine(borrow_engine.ComputeFunction{
 		borrow_engine.JT_Completion: func(n *borrow_engine.InferenceNode, jobs []*borrow_engine.ComputeJob) ([]*borrow_engine.ComputeJob, error) {
 			lg.Warn().Msg("completion job received")
@@ -70,6 +84,7 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 					lg.Error().Msg("completion request timed out")
 					return nil, err
 				case tmpResult := <-resChan[idx]:
+					job.Usage = tasks[idx].Stats
 					job.ComputeResult.CompletionChannel <- tmpResult
 				}
 			}
@@ -116,6 +131,11 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 		TopInterval: srvSettings.TopInterval,
 		TermUI:      srvSettings.TermUI,
 		LogChan:     srvSettings.LogChan,
+		TraceWriter: srvSettings.TraceWriter,
+		Fallbacks:   config.Fallbacks,
+		Budgets:     config.Budgets,
+		Scheduling:  config.Scheduling,
+		Routing:     config.Routing,
 	})
 
 	return &Context{
@@ -123,13 +143,22 @@ func NewContext(configPath string, lg zerolog.Logger, srvSettings *Settings) (*C
 		Log:           lg.With().Str("cfg-file", configPath).Logger(),
 		Storage:       db,
 		ComputeRouter: computeRouter,
+		bootstrap:     srvSettings.Bootstrap,
 	}, nil
 }
 
+const llmCacheCollectionPrefix = "embeddings-llm-cache"
+
+// GetLLMCacheCollection returns name of the vector collection, which
+// has embeddings of LLM cache prompts and generations
+func (ctx *Context) GetLLMCacheCollection() string {
+	return fmt.Sprintf("%s-%d", llmCacheCollectionPrefix, ctx.GetDefaultEmbeddingDims())
+}
+
 func (ctx *Context) GetDefaultEmbeddingDims() uint64 {
 	for _, node := range ctx.ComputeRouter.Nodes {
-		if node.RemoteEngine.EmbeddingsDims != nil {
-			return *node.RemoteEngine.EmbeddingsDims
+		if dims := node.RemoteEngine.GetEmbeddingsDims(); dims > 0 {
+			return dims
 		}
 	}
 
@@ -137,8 +166,13 @@ func (ctx *Context) GetDefaultEmbeddingDims() uint64 {
 }
 
 func (ctx *Context) Start(onStart func(ctx *Context)) {
-	if len(ctx.Config.Compute) > 0 {
+	if len(ctx.Config.Compute) > 0 || ctx.Config.Autoscaling != nil || len(ctx.bootstrap) > 0 {
 		go ctx.ComputeRouter.Run()
+	} else {
+		ctx.Log.Warn().Msg("no compute section in config")
+	}
+
+	if len(ctx.Config.Compute) > 0 {
 		detectedComputes := make([]chan *borrow_engine.InferenceNode, 0, len(ctx.Config.Compute))
 		for _, node := range ctx.Config.Compute {
 			ctx.Log.Info().Msgf("adding compute node: %s", node.Endpoint)
@@ -147,17 +181,40 @@ func (ctx *Context) Start(onStart func(ctx *Context)) {
 				EmbeddingsEndpointUrl: node.EmbeddingsEndpoint,
 				MaxRequests:           node.MaxRequests,
 				MaxBatchSize:          node.MaxBatchSize,
-				JobTypes:              translateJobTypes(node.JobTypes),
+				JobTypes:              ctx.translateJobTypes(node.JobTypes),
 				Protocol:              node.Type,
 				Token:                 node.Token,
+				ChatTemplate:          node.ChatTemplate,
+				Models:                node.Models,
+				Template:              node.Template,
+				Mock:                  node.Mock,
+				ContextLength:         node.ContextLength,
+				Cost:                  node.Cost,
+				Labels:                node.Labels,
 			}))
 		}
 		for _, ch := range detectedComputes {
 			gotNode := <-ch
 			ctx.Log.Info().Msgf("compute node auto-detected: %s", gotNode.EndpointUrl)
 		}
-	} else {
-		ctx.Log.Warn().Msg("no compute section in config")
+	}
+
+	if ctx.Config.Autoscaling != nil {
+		provisioner, err := borrow_engine.NewProvisioner(ctx.Config.Autoscaling)
+		if err != nil {
+			ctx.Log.Error().Err(err).Msg("error creating provisioner, autoscaling is disabled")
+		} else {
+			ctx.Log.Info().Msgf("autoscaling with provisioner: %s", ctx.Config.Autoscaling.Provisioner)
+			go borrow_engine.NewAutoscaler(ctx.ComputeRouter, provisioner, ctx.Config.Autoscaling).Run()
+		}
+	}
+
+	for _, bootstrap := range ctx.bootstrap {
+		go func(bootstrap *BootstrapSettings) {
+			if err := ctx.BootstrapNode(bootstrap); err != nil {
+				ctx.Log.Error().Err(err).Str("target", bootstrap.Target).Msg("error bootstrapping node")
+			}
+		}(bootstrap)
 	}
 
 	onStart(ctx)
@@ -167,16 +224,12 @@ func (ctx *Context) LaunchWorker(name string, worker func(ctx *Context, name str
 	go worker(ctx, name)
 }
 
-func translateJobTypes(types []string) []borrow_engine.JobType {
-	jobTypes := make([]borrow_engine.JobType, len(types))
-	for i, t := range types {
-		switch t {
-		case "embeddings":
-			jobTypes[i] = borrow_engine.JT_Embeddings
-		case "completion":
-			jobTypes[i] = borrow_engine.JT_Completion
-		}
+func (ctx *Context) translateJobTypes(types []string) []borrow_engine.JobType {
+	jobTypes, err := borrow_engine.ParseJobTypes(types)
+	if err != nil {
+		ctx.Log.Error().Err(err).Msgf("error parsing job types %v", types)
 	}
+
 	return jobTypes
 }
 
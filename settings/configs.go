@@ -23,16 +23,149 @@ type ConfigurationFile struct {
 			Token string `yaml:"token"`
 		} `yaml:"proxy-crawl"`
 	} `yaml:"tools"`
-	VectorDBs []VectorDBConfigurationSection `yaml:"vector-dbs"`
-	Compute   []struct {
-		Endpoint           string   `yaml:"endpoint"`
-		EmbeddingsEndpoint string   `yaml:"embeddings-endpoint"`
-		Type               string   `yaml:"type"`
-		MaxBatchSize       int      `yaml:"max-batch-size"`
-		MaxRequests        int      `yaml:"max-requests"`
-		JobTypes           []string `yaml:"job-types"`
-		Token              string   `yaml:"token"`
-	} `yaml:"compute"`
+	VectorDBs  []VectorDBConfigurationSection  `yaml:"vector-dbs"`
+	Compute    []ComputeConfigurationSection   `yaml:"compute"`
+	Federation FederationConfigurationSection  `yaml:"federation"`
+	Tokenizers []TokenizerConfigurationSection `yaml:"tokenizers"`
+	Fallbacks  []FallbackConfigurationSection  `yaml:"fallbacks"`
+	Budgets    []BudgetConfigurationSection    `yaml:"budgets"`
+	Scheduling SchedulingConfigurationSection  `yaml:"scheduling"`
+	Routing    []RoutingConfigurationSection   `yaml:"routing"`
+	// Autoscaling rents nodes, when jobs queue up, and releases them, once they're idle
+	Autoscaling *AutoscalingConfigurationSection `yaml:"autoscaling"`
+}
+
+type ComputeConfigurationSection struct {
+	Endpoint           string   `yaml:"endpoint"`
+	EmbeddingsEndpoint string   `yaml:"embeddings-endpoint"`
+	Type               string   `yaml:"type"`
+	MaxBatchSize       int      `yaml:"max-batch-size"`
+	MaxRequests        int      `yaml:"max-requests"`
+	JobTypes           []string `yaml:"job-types"`
+	Token              string   `yaml:"token"`
+	ChatTemplate       string   `yaml:"chat-template"`  // alpaca, chatml, llama-2 or mistral, detected by model name if empty
+	Models             []string `yaml:"models"`         // for engines which can't list their models
+	ContextLength      int      `yaml:"context-length"` // context window in tokens, discovered if engine reports it
+	// Template describes requests and responses of `http-template` compute type
+	Template *HttpTemplateConfigurationSection `yaml:"template"`
+	// Mock configures in-process fake engine of `mock` compute type
+	Mock *MockConfigurationSection `yaml:"mock"`
+	// Cost is the price of node's compute, it's accounted per process and tenant
+	Cost *CostConfigurationSection `yaml:"cost"`
+	// Labels are matched by routing policies and requests, i.e. pool: cheap, gpu: a6000, private: true
+	Labels map[string]string `yaml:"labels"`
+}
+
+// CostConfigurationSection is the price of the node, in any currency, as long as it's the same for all nodes
+type CostConfigurationSection struct {
+	PerHour                     float64 `yaml:"per-hour"`             // rent of the box, charged for the time batches run
+	PerThousandPromptTokens     float64 `yaml:"per-1k-prompt-tokens"` // hosted API prices
+	PerThousandCompletionTokens float64 `yaml:"per-1k-completion-tokens"`
+}
+
+// BudgetConfigurationSection limits the spend of a process, or of all processes of a tenant,
+// tenant is the part of the process name before the first slash, i.e. acme for acme/agent-test
+type BudgetConfigurationSection struct {
+	Process string  `yaml:"process"`
+	Tenant  string  `yaml:"tenant"`
+	Limit   float64 `yaml:"limit"`  // spend since server start
+	Action  string  `yaml:"action"` // stop (default) rejects new jobs, background runs them with background priority
+}
+
+// HttpTemplateConfigurationSection describes an HTTP inference API, bodies are pongo2 templates,
+// responses are parsed with JSONPath expressions, i.e. $.choices[*].text
+type HttpTemplateConfigurationSection struct {
+	// AuthHeader is the template of the header, i.e. "Authorization: Bearer {{ token }}"
+	AuthHeader string `yaml:"auth-header"`
+	// Batch enables sending all jobs of the batch in a single request,
+	// templates get `prompts` and `inputs` lists instead of `prompt` and `input`
+	Batch                bool   `yaml:"batch"`
+	CompletionBody       string `yaml:"completion-body"`
+	ChoicesPath          string `yaml:"choices-path"`
+	PromptTokensPath     string `yaml:"prompt-tokens-path"`
+	CompletionTokensPath string `yaml:"completion-tokens-path"`
+	EmbeddingsBody       string `yaml:"embeddings-body"`
+	EmbeddingsPath       string `yaml:"embeddings-path"`
+}
+
+// MockConfigurationSection describes behaviour of the fake inference engine, which
+// answers deterministically: with scripted responses, or with text derived from prompt's hash
+type MockConfigurationSection struct {
+	Models         []string             `yaml:"models"`          // default is "mock"
+	EmbeddingsDims int                  `yaml:"embeddings-dims"` // default is 384
+	ContextLength  int                  `yaml:"context-length"`
+	LatencyMs      int                  `yaml:"latency-ms"`     // time each batch takes
+	JitterMs       int                  `yaml:"jitter-ms"`      // random extra latency
+	FailureRate    float64              `yaml:"failure-rate"`   // share of the batches which fail, 0..1
+	MaxBatchSize   int                  `yaml:"max-batch-size"` // larger batches are rejected, 0 - unlimited
+	Seed           int64                `yaml:"seed"`           // seeds generated texts, jitter and failures
+	Script         []MockScriptResponse `yaml:"script"`
+}
+
+// MockScriptResponse is returned for the prompts containing the substring, first matching one wins
+type MockScriptResponse struct {
+	PromptContains string `yaml:"prompt-contains"`
+	Response       string `yaml:"response"`
+}
+
+// FederationConfigurationSection controls peering with other AgencyOS servers,
+// peers themselves are listed in compute section with type `http-agency-os`
+type FederationConfigurationSection struct {
+	// Advertise enables answering capacity requests from peers,
+	// server which doesn't advertise will never receive jobs from peers
+	Advertise bool `yaml:"advertise"`
+	// CacheLookupTimeout is the time in milliseconds to wait for peers
+	// to respond to cache lookups, default is 2000
+	CacheLookupTimeout int `yaml:"cache-lookup-timeout"`
+}
+
+// SchedulingConfigurationSection controls how jobs are placed on nodes and admitted to the queue
+type SchedulingConfigurationSection struct {
+	// Objectives choose nodes for jobs of the priority (system, kernel, user or background), objective is
+	// fastest, cheapest, balanced or first-fit, default is fastest, and cheapest for background jobs
+	Objectives map[string]string `yaml:"objectives"`
+	// TargetWaits are queue time SLOs by priority in milliseconds, job is rejected, if its projected wait
+	// misses the target of its priority, or of a higher one, priorities without target are always admitted
+	TargetWaits map[string]int `yaml:"target-waits"`
+}
+
+// RoutingConfigurationSection makes jobs of a process, or of a tenant, require or prefer nodes with
+// the labels, requests can add labels of their own, but can't override these
+type RoutingConfigurationSection struct {
+	Process string            `yaml:"process"`
+	Tenant  string            `yaml:"tenant"`
+	Require map[string]string `yaml:"require"`
+	Prefer  map[string]string `yaml:"prefer"`
+}
+
+// AutoscalingConfigurationSection controls the nodes rented with a provisioner, nodes listed
+// in compute section aren't counted, jobs of processes over budget don't make it rent more
+type AutoscalingConfigurationSection struct {
+	Provisioner     string            `yaml:"provisioner"` // i.e. local, which starts mock engines
+	MinNodes        int               `yaml:"min-nodes"`
+	MaxNodes        int               `yaml:"max-nodes"`
+	QueueDepth      int               `yaml:"queue-depth"`        // node is rented, when more jobs are queued, default is 64
+	MaxWait         int               `yaml:"max-wait"`           // or when a job waits longer, in milliseconds, default is 10000
+	IdleTimeout     int               `yaml:"idle-timeout"`       // nodes idle longer are released, in seconds, default is 300
+	MaxPricePerHour float64           `yaml:"max-price-per-hour"` // more expensive offers are skipped, 0 - any price
+	Budget          float64           `yaml:"budget"`             // no nodes are rented, once their rent reaches it, 0 - no limit
+	Options         map[string]string `yaml:"options"`            // provisioner's own settings, i.e. API token
+	// Mock configures engines started by the local provisioner
+	Mock *MockConfigurationSection `yaml:"mock"`
+}
+
+// FallbackConfigurationSection is a chain of models jobs switch to, when their model has no healthy node
+type FallbackConfigurationSection struct {
+	Model string   `yaml:"model"` // model mask of the jobs, i.e. mistral-7b-*
+	Chain []string `yaml:"chain"` // model masks to switch to in order, any - any model
+	Wait  int      `yaml:"wait"`  // seconds to wait for each model before switching to the next one, default is 30
+}
+
+// TokenizerConfigurationSection assigns tokenizer loaded from disk to the models,
+// path is HuggingFace `tokenizer.json`, sentencepiece `.model` file, or a directory with one
+type TokenizerConfigurationSection struct {
+	Model string `yaml:"model"` // model mask, i.e. mistral*
+	Path  string `yaml:"path"`
 }
 
 type VectorDBConfigurationSection struct {
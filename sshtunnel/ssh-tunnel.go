@@ -14,6 +14,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type Endpoint struct {
@@ -147,14 +148,23 @@ func (tunnel *SSHTunnel) forward(localConn net.Conn) {
 	copyConn(remoteConn, localConn)
 }
 
-func PrivateKeyFile(file string) (ssh.AuthMethod, error) {
+func expandHomeDir(file string) (string, error) {
 	if strings.HasPrefix(file, "~") {
 		uhd, err := os.UserHomeDir()
 		if err != nil {
-			return nil, fmt.Errorf("error looking up user home dir, and key path (%s) is relative: %w", file, err)
+			return "", fmt.Errorf("error looking up user home dir, and path (%s) is relative: %w", file, err)
 		}
 		file = strings.Replace(file, "~", uhd, 1)
 	}
+
+	return file, nil
+}
+
+func PrivateKeyFile(file string) (ssh.AuthMethod, error) {
+	file, err := expandHomeDir(file)
+	if err != nil {
+		return nil, err
+	}
 	buffer, err := os.ReadFile(file)
 	if err != nil {
 		return nil, fmt.Errorf("error reading key from %s: %w", file, err)
@@ -182,6 +192,43 @@ func PrivateKeyFile(file string) (ssh.AuthMethod, error) {
 	return ssh.PublicKeys(key), nil
 }
 
+// KnownHosts checks host keys against OpenSSH known_hosts files,
+// with no files given, any host key is accepted
+func KnownHosts(files ...string) (ssh.HostKeyCallback, error) {
+	if len(files) == 0 {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	expanded := make([]string, len(files))
+	for idx := range files {
+		file, err := expandHomeDir(files[idx])
+		if err != nil {
+			return nil, err
+		}
+		expanded[idx] = file
+	}
+	callback, err := knownhosts.New(expanded...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts from %v: %w", files, err)
+	}
+
+	return callback, nil
+}
+
+// ClientConfig is used for all the connections, host keys are not checked,
+// unless HostKeyCallback of the config is replaced, i.e. with KnownHosts
+func ClientConfig(user string, auth ssh.AuthMethod) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:    user,
+		Auth:    []ssh.AuthMethod{auth},
+		Timeout: 5 * time.Second,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			// Always accept key.
+			return nil
+		},
+	}
+}
+
 func NewSSHTunnel(tunnel string, auth ssh.AuthMethod, destination string, logger zerolog.Logger) (*SSHTunnel, error) {
 	// A random port will be chosen for us.
 	localEndpoint := NewEndpoint("localhost:0")
@@ -192,15 +239,7 @@ func NewSSHTunnel(tunnel string, auth ssh.AuthMethod, destination string, logger
 	}
 
 	sshTunnel := &SSHTunnel{
-		Config: &ssh.ClientConfig{
-			User:    server.User,
-			Auth:    []ssh.AuthMethod{auth},
-			Timeout: 5 * time.Second,
-			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-				// Always accept key.
-				return nil
-			},
-		},
+		Config:       ClientConfig(server.User, auth),
 		Local:        localEndpoint,
 		Server:       server,
 		Remote:       NewEndpoint(destination),
@@ -0,0 +1,52 @@
+package sshtunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKnownHostsChecksHostKeys(t *testing.T) {
+	hostKey := func() ssh.PublicKey {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	known, unknown := hostKey(), hostKey()
+
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("gpu-1:22")}, known) + "\n"
+	if err := os.WriteFile(file, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := KnownHosts(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+	if err := callback("gpu-1:22", remote, known); err != nil {
+		t.Fatalf("known host key should be accepted: %v", err)
+	}
+	if err := callback("gpu-1:22", remote, unknown); err == nil {
+		t.Fatalf("changed host key should be rejected")
+	}
+	if err := callback("gpu-2:22", remote, known); err == nil {
+		t.Fatalf("unknown host should be rejected")
+	}
+
+	if _, err := KnownHosts(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("missing known hosts file should be an error")
+	}
+}
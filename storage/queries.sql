@@ -111,15 +111,33 @@ create table if not exists llm_cache (
     PRIMARY KEY (`id`),
     KEY `prompt_length` (`prompt_length`,`prompt`(900)));
 
+-- name: migration-llm-cache-prompt-tokens
+alter table llm_cache add column `prompt_tokens` int unsigned NOT NULL DEFAULT 0;
+
+-- name: migration-llm-cache-completion-tokens
+alter table llm_cache add column `completion_tokens` int unsigned NOT NULL DEFAULT 0;
+
+-- name: migration-llm-cache-logprobs
+alter table llm_cache add column `logprobs` mediumblob DEFAULT NULL;
+
+-- name: migration-llm-cache-temperature
+alter table llm_cache add column `temperature` float DEFAULT NULL;
+
+-- name: migration-llm-cache-stop-tokens
+alter table llm_cache add column `stop_tokens` varchar(1024) DEFAULT NULL;
+
+-- name: migration-llm-cache-requested-model
+alter table llm_cache add column `requested_model` varchar(1024) DEFAULT NULL;
+
 -- name: insert-llm-cache-record
-insert into llm_cache (model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result)
-    values (?,?,?,?,?,?,?);
+insert into llm_cache (model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result, prompt_tokens, completion_tokens, logprobs, temperature, stop_tokens, requested_model)
+    values (?,?,?,?,?,?,?,?,?,?,?,?,?);
 
 -- name: query-llm-cache-by-id
-select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result from llm_cache where id = ?;
+select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result, prompt_tokens, completion_tokens, logprobs, temperature, stop_tokens, requested_model from llm_cache where id = ?;
 
 -- name: query-llm-cache-by-ids-multi
-select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result from llm_cache where id > ? order by id limit ?;
+select id, model, prompt, prompt_length, created_at, generation_settings, cache_hits, generation_result, prompt_tokens, completion_tokens, logprobs, temperature, stop_tokens, requested_model from llm_cache where id > ? order by id limit ?;
 
 -- name: query-llm-cache
 select id,
@@ -129,10 +147,20 @@ select id,
        created_at,
        generation_settings,
        cache_hits,
-       generation_result
+       generation_result,
+       prompt_tokens,
+       completion_tokens,
+       logprobs,
+       temperature,
+       stop_tokens,
+       requested_model
 from llm_cache where
     prompt_length = ? and
-    prompt = ?;
+    prompt = ? and
+    coalesce(generation_settings, '') = ?;
+
+-- name: delete-llm-cache-record
+delete from llm_cache where id = ?;
 
 -- name: make-llm-cache-hit
 update llm_cache set cache_hits = cache_hits + 1 where id = ?;
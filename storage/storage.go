@@ -4,9 +4,12 @@ import (
 	"crypto/sha512"
 	"embed"
 	"encoding/hex"
+	"errors"
 	"github.com/d0rc/agent-os/unidb"
+	"github.com/go-sql-driver/mysql"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
+	"sort"
 	"strings"
 	"time"
 )
@@ -40,6 +43,7 @@ func NewStorage(lg zerolog.Logger) (*Storage, error) {
 	}
 	// execute DDLs
 	storage.execDDLs()
+	storage.execMigrations()
 
 	return storage, nil
 }
@@ -53,6 +57,32 @@ func (s *Storage) execDDLs() {
 	}
 }
 
+// execMigrations alters tables created by older versions, migrations are run
+// in the order of their names, errors caused by already applied ones are ignored
+func (s *Storage) execMigrations() {
+	names := make([]string, 0)
+	for qName := range s.Db.GetQueries() {
+		if strings.HasPrefix(qName, "migration-") {
+			names = append(names, qName)
+		}
+	}
+	sort.Strings(names)
+
+	for _, qName := range names {
+		_, err := s.Db.Exec(qName)
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateColumn {
+			continue
+		}
+		if err != nil {
+			s.lg.Fatal().Err(err).Str("name", qName).Msg("error running migration")
+		}
+		s.lg.Info().Str("name", qName).Msg("migration applied")
+	}
+}
+
+const mysqlErrDuplicateColumn = 1060
+
 func GetHash(s string) string {
 	// generate SHA-512 hash for string
 	h := sha512.New()
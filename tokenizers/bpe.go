@@ -0,0 +1,108 @@
+package tokenizers
+
+import (
+	"container/heap"
+)
+
+// mergeSymbols repeatedly merges the pair of adjacent symbols with the highest score,
+// until no pair can be merged, pairScore tells if a pair can be merged and its score,
+// on equal scores the leftmost pair is merged first
+func mergeSymbols(symbols []string, pairScore func(left, right string) (float64, bool)) []string {
+	if len(symbols) < 2 {
+		return symbols
+	}
+
+	prev := make([]int, len(symbols))
+	next := make([]int, len(symbols))
+	for idx := range symbols {
+		prev[idx] = idx - 1
+		next[idx] = idx + 1
+	}
+	next[len(symbols)-1] = -1
+
+	queue := &mergeQueue{}
+	tryPair := func(left int) {
+		if left < 0 || next[left] < 0 {
+			return
+		}
+		right := next[left]
+		if score, ok := pairScore(symbols[left], symbols[right]); ok {
+			heap.Push(queue, &mergeCandidate{
+				left:  left,
+				right: right,
+				size:  len(symbols[left]) + len(symbols[right]),
+				score: score,
+			})
+		}
+	}
+	for idx := 0; idx < len(symbols)-1; idx++ {
+		tryPair(idx)
+	}
+
+	for queue.Len() > 0 {
+		candidate := heap.Pop(queue).(*mergeCandidate)
+		// skip candidates, which are outdated by earlier merges
+		if symbols[candidate.left] == "" || symbols[candidate.right] == "" ||
+			next[candidate.left] != candidate.right ||
+			len(symbols[candidate.left])+len(symbols[candidate.right]) != candidate.size {
+			continue
+		}
+
+		symbols[candidate.left] += symbols[candidate.right]
+		symbols[candidate.right] = ""
+		next[candidate.left] = next[candidate.right]
+		if next[candidate.left] >= 0 {
+			prev[next[candidate.left]] = candidate.left
+		}
+
+		tryPair(prev[candidate.left])
+		tryPair(candidate.left)
+	}
+
+	result := make([]string, 0, len(symbols))
+	for idx := 0; idx >= 0; idx = next[idx] {
+		result = append(result, symbols[idx])
+	}
+
+	return result
+}
+
+type mergeCandidate struct {
+	left, right int
+	size        int
+	score       float64
+}
+
+type mergeQueue []*mergeCandidate
+
+func (q mergeQueue) Len() int { return len(q) }
+
+func (q mergeQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+
+	return q[i].left < q[j].left
+}
+
+func (q mergeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *mergeQueue) Push(x any) { *q = append(*q, x.(*mergeCandidate)) }
+
+func (q *mergeQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return item
+}
+
+// splitChars splits text into UTF-8 characters
+func splitChars(text string) []string {
+	chars := make([]string, 0, len(text))
+	for _, char := range text {
+		chars = append(chars, string(char))
+	}
+
+	return chars
+}
@@ -0,0 +1,376 @@
+package tokenizers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// metaspace replaces spaces in sentencepiece-style vocabularies
+const metaspace = "▁"
+
+// huggingFaceTokenizer implements BPE models of HuggingFace `tokenizer.json`, both
+// byte-level ones (GPT-2, Llama-3 style) and sentencepiece-like ones with byte fallback
+// (Llama-2, Mistral style), normalizers and pre-tokenizers are only recognized, not interpreted
+type huggingFaceTokenizer struct {
+	name         string
+	vocab        map[string]int
+	reverseVocab map[int]string
+	mergeRanks   map[string]int // "left right" -> rank
+	addedTokens  []string       // longest first
+	byteLevel    bool
+	prependSpace bool
+	prependFirst bool // space is prepended to the first part of the text only
+	byteFallback bool
+	unkToken     string
+}
+
+type huggingFaceConfig struct {
+	AddedTokens []struct {
+		Id      int    `json:"id"`
+		Content string `json:"content"`
+	} `json:"added_tokens"`
+	Normalizer   json.RawMessage `json:"normalizer"`
+	PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+	Model        struct {
+		Type         string          `json:"type"`
+		Vocab        map[string]int  `json:"vocab"`
+		Merges       json.RawMessage `json:"merges"`
+		ByteFallback bool            `json:"byte_fallback"`
+		UnkToken     *string         `json:"unk_token"`
+	} `json:"model"`
+}
+
+// huggingFaceComponent is a normalizer or a pre-tokenizer, possibly a sequence of those
+type huggingFaceComponent struct {
+	Type           string                 `json:"type"`
+	Normalizers    []huggingFaceComponent `json:"normalizers"`
+	Pretokenizers  []huggingFaceComponent `json:"pretokenizers"`
+	Prepend        string                 `json:"prepend"`
+	Content        string                 `json:"content"`
+	AddPrefixSpace *bool                  `json:"add_prefix_space"`
+	PrependScheme  string                 `json:"prepend_scheme"`
+	Pattern        struct {
+		String string `json:"String"`
+	} `json:"pattern"`
+}
+
+func (c *huggingFaceComponent) walk(f func(component *huggingFaceComponent)) {
+	f(c)
+	for idx := range c.Normalizers {
+		c.Normalizers[idx].walk(f)
+	}
+	for idx := range c.Pretokenizers {
+		c.Pretokenizers[idx].walk(f)
+	}
+}
+
+func parseHuggingFaceComponent(data json.RawMessage) (*huggingFaceComponent, error) {
+	component := &huggingFaceComponent{}
+	if len(data) == 0 || string(data) == "null" {
+		return component, nil
+	}
+
+	return component, json.Unmarshal(data, component)
+}
+
+// LoadHuggingFace reads HuggingFace `tokenizer.json`, only BPE models are supported
+func LoadHuggingFace(path string) (Tokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &huggingFaceConfig{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	if config.Model.Type != "" && config.Model.Type != "BPE" {
+		return nil, fmt.Errorf("%s model of %s is not supported, only BPE is", config.Model.Type, path)
+	}
+
+	tokenizer := &huggingFaceTokenizer{
+		name:         fmt.Sprintf("huggingface:%s", filepath.Base(filepath.Dir(path))),
+		vocab:        config.Model.Vocab,
+		reverseVocab: make(map[int]string, len(config.Model.Vocab)),
+		mergeRanks:   make(map[string]int),
+		byteFallback: config.Model.ByteFallback,
+	}
+	if config.Model.UnkToken != nil {
+		tokenizer.unkToken = *config.Model.UnkToken
+	}
+
+	for token, id := range tokenizer.vocab {
+		tokenizer.reverseVocab[id] = token
+	}
+	for _, added := range config.AddedTokens {
+		if added.Content == "" {
+			continue
+		}
+		tokenizer.vocab[added.Content] = added.Id
+		tokenizer.reverseVocab[added.Id] = added.Content
+		tokenizer.addedTokens = append(tokenizer.addedTokens, added.Content)
+	}
+	sort.Slice(tokenizer.addedTokens, func(i, j int) bool {
+		return len(tokenizer.addedTokens[i]) > len(tokenizer.addedTokens[j])
+	})
+
+	if err = tokenizer.parseMerges(config.Model.Merges); err != nil {
+		return nil, fmt.Errorf("error parsing merges of %s: %w", path, err)
+	}
+
+	normalizer, err := parseHuggingFaceComponent(config.Normalizer)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing normalizer of %s: %w", path, err)
+	}
+	preTokenizer, err := parseHuggingFaceComponent(config.PreTokenizer)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing pre_tokenizer of %s: %w", path, err)
+	}
+
+	normalizer.walk(func(component *huggingFaceComponent) {
+		if component.Type == "Prepend" && component.Prepend == metaspace {
+			tokenizer.prependSpace = true
+		}
+	})
+	preTokenizer.walk(func(component *huggingFaceComponent) {
+		switch component.Type {
+		case "ByteLevel":
+			tokenizer.byteLevel = true
+		case "Metaspace":
+			tokenizer.prependSpace = component.PrependScheme != "never" &&
+				(component.AddPrefixSpace == nil || *component.AddPrefixSpace)
+			tokenizer.prependFirst = component.PrependScheme == "first"
+		}
+	})
+
+	return tokenizer, nil
+}
+
+func (t *huggingFaceTokenizer) parseMerges(data json.RawMessage) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	// merges are either "left right" strings, or [left, right] pairs in newer files
+	var merges []string
+	if err := json.Unmarshal(data, &merges); err != nil {
+		var pairs [][2]string
+		if err = json.Unmarshal(data, &pairs); err != nil {
+			return err
+		}
+		merges = make([]string, len(pairs))
+		for idx, pair := range pairs {
+			merges[idx] = pair[0] + " " + pair[1]
+		}
+	}
+
+	for rank, merge := range merges {
+		if _, exists := t.mergeRanks[merge]; !exists {
+			t.mergeRanks[merge] = rank
+		}
+	}
+
+	return nil
+}
+
+func (t *huggingFaceTokenizer) Name() string {
+	return t.name
+}
+
+func (t *huggingFaceTokenizer) Encode(text string) ([]int, error) {
+	tokens := make([]int, 0, len(text)/3)
+	first := true
+	for len(text) > 0 {
+		// added tokens, i.e. <s> or <|im_start|>, are never split
+		chunk, added := t.nextChunk(text)
+		text = text[len(chunk):]
+		if added {
+			tokens = append(tokens, t.vocab[chunk])
+			continue
+		}
+
+		if t.byteLevel {
+			for _, word := range splitByteLevelWords(chunk) {
+				tokens = t.appendSymbols(tokens, mergeSymbols(splitChars(toByteLevel(word)), t.pairScore))
+			}
+			continue
+		}
+
+		chunk = strings.ReplaceAll(chunk, " ", metaspace)
+		if t.prependSpace && (first || !t.prependFirst) {
+			chunk = metaspace + chunk
+		}
+		first = false
+		tokens = t.appendSymbols(tokens, mergeSymbols(splitChars(chunk), t.pairScore))
+	}
+
+	return tokens, nil
+}
+
+// nextChunk returns either an added token at the beginning of the text,
+// or the text till the next added token
+func (t *huggingFaceTokenizer) nextChunk(text string) (string, bool) {
+	end := len(text)
+	for _, added := range t.addedTokens {
+		idx := strings.Index(text, added)
+		if idx == 0 {
+			return added, true
+		}
+		if idx > 0 && idx < end {
+			end = idx
+		}
+	}
+
+	return text[:end], false
+}
+
+func (t *huggingFaceTokenizer) pairScore(left, right string) (float64, bool) {
+	rank, exists := t.mergeRanks[left+" "+right]
+	return -float64(rank), exists
+}
+
+func (t *huggingFaceTokenizer) appendSymbols(tokens []int, symbols []string) []int {
+	for _, symbol := range symbols {
+		if id, exists := t.vocab[symbol]; exists {
+			tokens = append(tokens, id)
+			continue
+		}
+
+		if t.byteFallback {
+			for _, b := range []byte(symbol) {
+				if id, exists := t.vocab[fmt.Sprintf("<0x%02X>", b)]; exists {
+					tokens = append(tokens, id)
+				}
+			}
+			continue
+		}
+
+		if id, exists := t.vocab[t.unkToken]; exists {
+			tokens = append(tokens, id)
+		}
+	}
+
+	return tokens
+}
+
+func (t *huggingFaceTokenizer) Decode(tokens []int) (string, error) {
+	pieces := make([]string, len(tokens))
+	for idx, token := range tokens {
+		piece, exists := t.reverseVocab[token]
+		if !exists {
+			return "", fmt.Errorf("token %d is not in the vocabulary", token)
+		}
+		pieces[idx] = piece
+	}
+
+	if t.byteLevel {
+		return fromByteLevel(strings.Join(pieces, "")), nil
+	}
+
+	text := strings.ReplaceAll(string(decodeBytePieces(pieces)), metaspace, " ")
+	if t.prependSpace {
+		text = strings.TrimPrefix(text, " ")
+	}
+
+	return text, nil
+}
+
+// decodeBytePieces joins pieces, replacing byte fallback ones, i.e. <0x0A>, with their bytes
+func decodeBytePieces(pieces []string) []byte {
+	result := make([]byte, 0, len(pieces)*4)
+	for _, piece := range pieces {
+		var b byte
+		if len(piece) == 6 && strings.HasPrefix(piece, "<0x") && strings.HasSuffix(piece, ">") {
+			if _, err := fmt.Sscanf(piece, "<0x%02X>", &b); err == nil {
+				result = append(result, b)
+				continue
+			}
+		}
+		result = append(result, piece...)
+	}
+
+	return result
+}
+
+// byte-level BPE maps every byte to a printable character, as GPT-2 does
+var byteToRune, runeToByte = makeByteLevelTables()
+
+func makeByteLevelTables() ([256]rune, map[rune]byte) {
+	var bytesToRunes [256]rune
+	runesToBytes := make(map[rune]byte, 256)
+	shift := rune(0)
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		if !((b >= '!' && b <= '~') || (b >= 0xa1 && b <= 0xac) || (b >= 0xae && b <= 0xff)) {
+			r = 256 + shift
+			shift++
+		}
+		bytesToRunes[b] = r
+		runesToBytes[r] = byte(b)
+	}
+
+	return bytesToRunes, runesToBytes
+}
+
+func toByteLevel(text string) string {
+	result := strings.Builder{}
+	for _, b := range []byte(text) {
+		result.WriteRune(byteToRune[b])
+	}
+
+	return result.String()
+}
+
+func fromByteLevel(text string) string {
+	result := make([]byte, 0, len(text))
+	for _, r := range text {
+		if b, exists := runeToByte[r]; exists {
+			result = append(result, b)
+		}
+	}
+
+	return string(result)
+}
+
+// GPT-2 splitting pattern, except `\s+(?!\S)`, which RE2 can't do, it's emulated below
+var byteLevelWordsRe = regexp.MustCompile(`'s|'t|'re|'ve|'m|'ll|'d| ?\pL+| ?\pN+| ?[^\s\pL\pN]+|\s+`)
+
+func splitByteLevelWords(text string) []string {
+	words := byteLevelWordsRe.FindAllString(text, -1)
+	result := make([]string, 0, len(words))
+	for idx := 0; idx < len(words); idx++ {
+		word := words[idx]
+		runes := []rune(word)
+		if idx+1 < len(words) && len(runes) > 1 && isSpaces(runes) {
+			// the last whitespace belongs to the next word
+			last := runes[len(runes)-1]
+			result = append(result, string(runes[:len(runes)-1]))
+			if last == ' ' && !unicode.IsSpace([]rune(words[idx+1])[0]) {
+				words[idx+1] = " " + words[idx+1]
+			} else {
+				result = append(result, string(last))
+			}
+			continue
+		}
+		result = append(result, word)
+	}
+
+	return result
+}
+
+func isSpaces(runes []rune) bool {
+	for _, r := range runes {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,280 @@
+package tokenizers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sentencepiece piece types, as defined in sentencepiece_model.proto
+const (
+	pieceNormal      = 1
+	pieceUnknown     = 2
+	pieceControl     = 3
+	pieceUserDefined = 4
+	pieceUnused      = 5
+	pieceByte        = 6
+)
+
+// sentencepiece model types
+const (
+	modelUnigram = 1
+	modelBPE     = 2
+)
+
+type sentencePiece struct {
+	piece     string
+	score     float32
+	pieceType int
+}
+
+// sentencePieceTokenizer implements BPE and unigram sentencepiece models, text normalization
+// rules are not applied, except for the dummy prefix and whitespace escaping
+type sentencePieceTokenizer struct {
+	name           string
+	pieces         []sentencePiece
+	ids            map[string]int
+	maxPieceLength int
+	modelType      int
+	addDummyPrefix bool
+	unkId          int
+}
+
+// LoadSentencePiece reads sentencepiece `.model` file, i.e. Llama's tokenizer.model
+func LoadSentencePiece(path string) (Tokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenizer := &sentencePieceTokenizer{
+		name:           fmt.Sprintf("sentencepiece:%s", filepath.Base(filepath.Dir(path))),
+		ids:            make(map[string]int),
+		modelType:      modelUnigram,
+		addDummyPrefix: true,
+	}
+	err = readProtoFields(data, func(field int, value []byte, varint uint64) error {
+		switch field {
+		case 1: // pieces
+			piece, err := parseSentencePiece(value)
+			if err != nil {
+				return err
+			}
+			tokenizer.ids[piece.piece] = len(tokenizer.pieces)
+			tokenizer.pieces = append(tokenizer.pieces, piece)
+			if piece.pieceType == pieceUnknown {
+				tokenizer.unkId = len(tokenizer.pieces) - 1
+			}
+			tokenizer.maxPieceLength = max(tokenizer.maxPieceLength, len([]rune(piece.piece)))
+		case 2: // trainer_spec
+			return readProtoFields(value, func(field int, _ []byte, varint uint64) error {
+				if field == 3 {
+					tokenizer.modelType = int(varint)
+				}
+				return nil
+			})
+		case 3: // normalizer_spec
+			return readProtoFields(value, func(field int, _ []byte, varint uint64) error {
+				if field == 3 {
+					tokenizer.addDummyPrefix = varint != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	if len(tokenizer.pieces) == 0 {
+		return nil, fmt.Errorf("no pieces found in %s", path)
+	}
+
+	return tokenizer, nil
+}
+
+func parseSentencePiece(data []byte) (sentencePiece, error) {
+	piece := sentencePiece{pieceType: pieceNormal}
+	err := readProtoFields(data, func(field int, value []byte, varint uint64) error {
+		switch field {
+		case 1:
+			piece.piece = string(value)
+		case 2:
+			piece.score = math.Float32frombits(uint32(varint))
+		case 3:
+			piece.pieceType = int(varint)
+		}
+		return nil
+	})
+
+	return piece, err
+}
+
+var errBadProto = errors.New("malformed protobuf message")
+
+// readProtoFields calls f for every field of protobuf message, length-delimited
+// fields are passed as value, the rest as varint, fixed32 and fixed64 included
+func readProtoFields(data []byte, f func(field int, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errBadProto
+		}
+		data = data[n:]
+
+		var value []byte
+		var varint uint64
+		switch key & 7 {
+		case 0:
+			varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errBadProto
+			}
+			data = data[n:]
+		case 1:
+			if len(data) < 8 {
+				return errBadProto
+			}
+			varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return errBadProto
+			}
+			value = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case 5:
+			if len(data) < 4 {
+				return errBadProto
+			}
+			varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		default:
+			return errBadProto
+		}
+
+		if err := f(int(key>>3), value, varint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *sentencePieceTokenizer) Name() string {
+	return t.name
+}
+
+func (t *sentencePieceTokenizer) Encode(text string) ([]int, error) {
+	if text == "" {
+		return []int{}, nil
+	}
+
+	text = strings.ReplaceAll(text, " ", metaspace)
+	if t.addDummyPrefix {
+		text = metaspace + text
+	}
+
+	var symbols []string
+	if t.modelType == modelBPE {
+		symbols = mergeSymbols(splitChars(text), t.pairScore)
+	} else {
+		symbols = t.segmentUnigram(splitChars(text))
+	}
+
+	tokens := make([]int, 0, len(symbols))
+	for _, symbol := range symbols {
+		if id, exists := t.ids[symbol]; exists && t.pieces[id].pieceType != pieceUnused {
+			tokens = append(tokens, id)
+			continue
+		}
+
+		// byte fallback, if model has byte pieces
+		for _, b := range []byte(symbol) {
+			id, exists := t.ids[fmt.Sprintf("<0x%02X>", b)]
+			if !exists {
+				tokens = append(tokens, t.unkId)
+				break
+			}
+			tokens = append(tokens, id)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (t *sentencePieceTokenizer) pairScore(left, right string) (float64, bool) {
+	id, exists := t.ids[left+right]
+	if !exists || !t.isWordPiece(id) {
+		return 0, false
+	}
+
+	return float64(t.pieces[id].score), true
+}
+
+func (t *sentencePieceTokenizer) isWordPiece(id int) bool {
+	pieceType := t.pieces[id].pieceType
+	return pieceType == pieceNormal || pieceType == pieceUserDefined
+}
+
+// segmentUnigram finds segmentation of the text with the highest total score,
+// characters, which are not in the vocabulary, get the unknown piece penalty
+func (t *sentencePieceTokenizer) segmentUnigram(chars []string) []string {
+	const unknownPenalty = -100.0
+
+	bestScore := make([]float64, len(chars)+1)
+	bestStart := make([]int, len(chars)+1)
+	for end := 1; end <= len(chars); end++ {
+		bestScore[end] = math.Inf(-1)
+		for start := max(0, end-t.maxPieceLength); start < end; start++ {
+			piece := strings.Join(chars[start:end], "")
+			score := unknownPenalty
+			if id, exists := t.ids[piece]; exists && t.isWordPiece(id) {
+				score = float64(t.pieces[id].score)
+			} else if end-start > 1 {
+				continue
+			}
+			if bestScore[start]+score > bestScore[end] {
+				bestScore[end] = bestScore[start] + score
+				bestStart[end] = start
+			}
+		}
+	}
+
+	symbols := make([]string, 0)
+	for end := len(chars); end > 0; end = bestStart[end] {
+		symbols = append(symbols, strings.Join(chars[bestStart[end]:end], ""))
+	}
+	for i, j := 0, len(symbols)-1; i < j; i, j = i+1, j-1 {
+		symbols[i], symbols[j] = symbols[j], symbols[i]
+	}
+
+	return symbols
+}
+
+func (t *sentencePieceTokenizer) Decode(tokens []int) (string, error) {
+	pieces := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token < 0 || token >= len(t.pieces) {
+			return "", fmt.Errorf("token %d is not in the vocabulary", token)
+		}
+		// control pieces, i.e. <s> and </s>, don't produce any text
+		if t.pieces[token].pieceType == pieceControl {
+			continue
+		}
+		pieces = append(pieces, t.pieces[token].piece)
+	}
+
+	text := strings.ReplaceAll(string(decodeBytePieces(pieces)), metaspace, " ")
+	if t.addDummyPrefix {
+		text = strings.TrimPrefix(text, " ")
+	}
+
+	return text, nil
+}
@@ -0,0 +1,103 @@
+package tokenizers
+
+import (
+	"fmt"
+	"github.com/wbrown/gpt_bpe"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Tokenizer turns text into model's token ids and back
+type Tokenizer interface {
+	// Name tells which tokenizer is used, i.e. gpt2 or huggingface:Mistral-7B-v0.1
+	Name() string
+	Encode(text string) ([]int, error)
+	Decode(tokens []int) (string, error)
+}
+
+// Load reads tokenizer from disk, `tokenizer.json` files and directories
+// having one are loaded as HuggingFace tokenizers, `.model` files as sentencepiece ones
+func Load(path string) (Tokenizer, error) {
+	switch {
+	case strings.HasSuffix(path, ".model"):
+		return LoadSentencePiece(path)
+	case strings.HasSuffix(path, ".json"):
+		return LoadHuggingFace(path)
+	}
+
+	candidates := []string{
+		filepath.Join(path, "tokenizer.json"),
+		filepath.Join(path, "tokenizer.model"),
+	}
+	for _, candidate := range candidates {
+		if tokenizer, err := Load(candidate); err == nil {
+			return tokenizer, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no tokenizer.json or tokenizer.model found in %s", path)
+}
+
+// Count returns number of tokens in text
+func Count(tokenizer Tokenizer, text string) (int, error) {
+	tokens, err := tokenizer.Encode(text)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(tokens), nil
+}
+
+type gpt2Tokenizer struct {
+	encoder *gpt_bpe.GPTEncoder
+	lock    sync.Mutex
+}
+
+var gpt2 = &gpt2Tokenizer{}
+
+// GPT2 returns GPT-2 tokenizer, which is used when nothing is known about the model
+func GPT2() Tokenizer {
+	return gpt2
+}
+
+func (t *gpt2Tokenizer) Name() string {
+	return "gpt2"
+}
+
+func (t *gpt2Tokenizer) getEncoder() *gpt_bpe.GPTEncoder {
+	if t.encoder == nil {
+		encoder := gpt_bpe.NewGPT2Encoder()
+		t.encoder = &encoder
+	}
+
+	return t.encoder
+}
+
+func (t *gpt2Tokenizer) Encode(text string) ([]int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	encoded := t.getEncoder().Encode(&text)
+	tokens := make([]int, len(*encoded))
+	for idx, token := range *encoded {
+		tokens[idx] = int(token)
+	}
+
+	return tokens, nil
+}
+
+func (t *gpt2Tokenizer) Decode(tokens []int) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	encoded := make(gpt_bpe.Tokens, len(tokens))
+	for idx, token := range tokens {
+		if token < 0 || token > 0xffff {
+			return "", fmt.Errorf("token %d is out of GPT-2 vocabulary", token)
+		}
+		encoded[idx] = gpt_bpe.Token(token)
+	}
+
+	return t.getEncoder().Decode(&encoded), nil
+}
@@ -0,0 +1,120 @@
+package tokenizers
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func checkRoundTrip(t *testing.T, tokenizer Tokenizer, text string, expected []int) {
+	tokens, err := tokenizer.Encode(text)
+	if err != nil {
+		t.Fatalf("%s failed to encode: %v", tokenizer.Name(), err)
+	}
+	if expected != nil && !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("%s encoded %q as %v, expected %v", tokenizer.Name(), text, tokens, expected)
+	}
+
+	decoded, err := tokenizer.Decode(tokens)
+	if err != nil {
+		t.Fatalf("%s failed to decode: %v", tokenizer.Name(), err)
+	}
+	if decoded != text {
+		t.Fatalf("%s decoded %v as %q, expected %q", tokenizer.Name(), tokens, decoded, text)
+	}
+}
+
+func TestGPT2(t *testing.T) {
+	checkRoundTrip(t, GPT2(), "Hello world", []int{15496, 995})
+}
+
+func TestHuggingFaceMetaspace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	err := os.WriteFile(path, []byte(`{
+		"added_tokens": [{"id": 0, "content": "<s>"}],
+		"normalizer": {"type": "Sequence", "normalizers": [
+			{"type": "Prepend", "prepend": "▁"},
+			{"type": "Replace", "pattern": {"String": " "}, "content": "▁"}
+		]},
+		"pre_tokenizer": null,
+		"model": {
+			"type": "BPE", "byte_fallback": true, "unk_token": "<unk>",
+			"vocab": {"<s>": 0, "<0x21>": 1, "▁": 2, "h": 3, "i": 4, "▁h": 5, "▁hi": 6},
+			"merges": ["▁ h", "▁h i"]
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenizer, err := Load(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	checkRoundTrip(t, tokenizer, "hi hi!", []int{6, 6, 1})
+
+	tokens, err := tokenizer.Encode("<s>hi")
+	if err != nil || !reflect.DeepEqual(tokens, []int{0, 6}) {
+		t.Fatalf("added tokens should not be split: %v, %v", tokens, err)
+	}
+}
+
+func TestHuggingFaceByteLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokenizer.json")
+	err := os.WriteFile(path, []byte(`{
+		"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false},
+		"model": {
+			"type": "BPE",
+			"vocab": {"h": 0, "i": 1, "Ġ": 2, "hi": 3, "Ġhi": 4, "Ċ": 5},
+			"merges": [["h", "i"], ["Ġ", "hi"]]
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenizer, err := LoadHuggingFace(path)
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	checkRoundTrip(t, tokenizer, "hi  hi\n", []int{3, 2, 4, 5})
+}
+
+func TestSentencePiece(t *testing.T) {
+	protoString := func(field int, value []byte) []byte {
+		data := binary.AppendUvarint(nil, uint64(field<<3|2))
+		data = binary.AppendUvarint(data, uint64(len(value)))
+		return append(data, value...)
+	}
+	protoVarint := func(field int, value uint64) []byte {
+		return binary.AppendUvarint(binary.AppendUvarint(nil, uint64(field<<3)), value)
+	}
+	piece := func(text string, score float32, pieceType uint64) []byte {
+		data := protoString(1, []byte(text))
+		data = append(data, binary.AppendUvarint(nil, 2<<3|5)...)
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(score))
+		return protoString(1, append(data, protoVarint(3, pieceType)...))
+	}
+
+	model := append([]byte{}, piece("<unk>", 0, pieceUnknown)...)
+	model = append(model, piece("<s>", 0, pieceControl)...)
+	model = append(model, piece("<0x21>", 0, pieceByte)...)
+	for _, p := range []string{"▁", "h", "i", "▁h", "▁hi"} {
+		model = append(model, piece(p, float32(len(p)), pieceNormal)...)
+	}
+	model = append(model, protoString(2, protoVarint(3, modelBPE))...)
+
+	path := filepath.Join(t.TempDir(), "tokenizer.model")
+	if err := os.WriteFile(path, model, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenizer, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed to load tokenizer: %v", err)
+	}
+	checkRoundTrip(t, tokenizer, "hi hi!", []int{7, 7, 2})
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/d0rc/agent-os/cmds"
 	os_client "github.com/d0rc/agent-os/os-client"
 	"github.com/d0rc/agent-os/server"
-	"github.com/d0rc/agent-os/utils"
 	"github.com/logrusorgru/aurora"
 	zlog "github.com/rs/zerolog/log"
 	"math/rand"
@@ -47,7 +46,7 @@ func DocumentReduce(document, question, assistantPrefix string, ctx *os_client.A
 	ts := time.Now()
 	systemPrompt := fmt.Sprintf(`You are an AI that seeks to answer the following question:\n%s`, question)
 
-	snippets, err := tokenizeDocument(document, ctx)
+	snippets, err := tokenizeDocument(document, model, ctx)
 	if err != nil {
 		return fmt.Sprintf("%v", err)
 	}
@@ -192,14 +191,14 @@ func dropEmptyLines(snippet string) string {
 	return snippet
 }
 
-func tokenizeDocument(document string, ctx *os_client.AgentOSClient) ([]string, error) {
-	// idea is to split document into batch of tokens of max length
-	// max_snippet_size = (info.LLM.GetContextLength() * 2 / 3)
-	// use info.LLM.Tokenize(document) to tokenize it
+func tokenizeDocument(document, model string, ctx *os_client.AgentOSClient) ([]string, error) {
+	// idea is to split document into snippets of snippetLength tokens,
+	// as seen by the tokenizer of the model, which is going to read them
 	tokenizerTs := time.Now()
+	cacheKey := fmt.Sprintf("%s\n%s", model, document)
 
-	result, err := ctx.GetTaskCachedResult("gpt2-tokenizer", document)
-	if err != nil && result != nil {
+	result, err := ctx.GetTaskCachedResult("document-snippets", cacheKey)
+	if err == nil && result != nil {
 		// ok, it's a hit...!
 		parsedResult := make([]string, 0)
 		err = json.Unmarshal(result, &parsedResult)
@@ -209,30 +208,29 @@ func tokenizeDocument(document string, ctx *os_client.AgentOSClient) ([]string,
 	}
 	// if we're here - no valid cache records found..!
 
-	// tokenization llm!
-	tokenized, err := utils.TokenizeGPT2(document)
-	if err != nil {
-		return nil, fmt.Errorf("failed to tokenize document: %v", err)
-	}
-
+	// server tokenizes the document once and decodes the snippets itself
 	snippetLength := 700
-	snippets := make([]string, 0)
-	for i := 0; i < len(tokenized); i += snippetLength {
-		end := i + snippetLength
-		if end > len(tokenized) {
-			end = len(tokenized)
-		}
-
-		snippets = append(snippets, utils.TokensToStringGPT2(tokenized[i:end]))
+	tokenized, err := os_client.ProcessTokenize([]cmds.TokenizeRequest{
+		{
+			Model:       model,
+			Operation:   cmds.TokenizeOperationSplit,
+			Text:        document,
+			ChunkTokens: snippetLength,
+		},
+	}, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split document into snippets: %v", err)
 	}
+	snippets := tokenized.TokenizeResponse[0].Chunks
 
 	snippetsJSON, err := json.Marshal(snippets)
 	if err == nil {
-		_ = ctx.SetTaskCachedResult("gpt2-tokenizer", document, snippetsJSON)
+		_ = ctx.SetTaskCachedResult("document-snippets", cacheKey, snippetsJSON)
 	}
 
 	zlog.Info().
 		// Str("question", question).
+		Str("tokenizer_name", tokenized.TokenizeResponse[0].Tokenizer).
 		Dur("tokenizer", time.Since(tokenizerTs)).
 		Int("snippet_count", len(snippets)).
 		Msg("summarizing")
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// ValidateJSONSchema checks decoded JSON value against the schema, the subset supported is:
+// type, enum, properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, minimum and maximum
+func ValidateJSONSchema(value interface{}, schema map[string]interface{}) error {
+	return validateJSONSchema(value, schema, "$")
+}
+
+func validateJSONSchema(value interface{}, schema map[string]interface{}, path string) error {
+	if schemaType, exists := schema["type"]; exists {
+		if err := checkJSONType(value, schemaType, path); err != nil {
+			return err
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			found = found || reflect.DeepEqual(option, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return validateJSONObject(typed, schema, path)
+	case []interface{}:
+		if minItems, ok := schemaNumber(schema, "minItems"); ok && float64(len(typed)) < minItems {
+			return fmt.Errorf("%s: %d items, at least %v expected", path, len(typed), minItems)
+		}
+		if maxItems, ok := schemaNumber(schema, "maxItems"); ok && float64(len(typed)) > maxItems {
+			return fmt.Errorf("%s: %d items, at most %v expected", path, len(typed), maxItems)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for idx, item := range typed {
+				if err := validateJSONSchema(item, items, fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(len([]rune(typed)))
+		if minLength, ok := schemaNumber(schema, "minLength"); ok && length < minLength {
+			return fmt.Errorf("%s: string is shorter than %v", path, minLength)
+		}
+		if maxLength, ok := schemaNumber(schema, "maxLength"); ok && length > maxLength {
+			return fmt.Errorf("%s: string is longer than %v", path, maxLength)
+		}
+	case float64:
+		if minimum, ok := schemaNumber(schema, "minimum"); ok && typed < minimum {
+			return fmt.Errorf("%s: %v is less than %v", path, typed, minimum)
+		}
+		if maximum, ok := schemaNumber(schema, "maximum"); ok && typed > maximum {
+			return fmt.Errorf("%s: %v is greater than %v", path, typed, maximum)
+		}
+	}
+
+	return nil
+}
+
+func validateJSONObject(object map[string]interface{}, schema map[string]interface{}, path string) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, exists := object[key]; !exists {
+					return fmt.Errorf("%s: required property %s is missing", path, key)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		propertySchema, isKnown := properties[key].(map[string]interface{})
+		if !isKnown {
+			if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+				return fmt.Errorf("%s: unexpected property %s", path, key)
+			}
+			continue
+		}
+		if err := validateJSONSchema(object[key], propertySchema, path+"."+key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkJSONType(value interface{}, schemaType interface{}, path string) error {
+	types := make([]string, 0, 1)
+	switch typed := schemaType.(type) {
+	case string:
+		types = append(types, typed)
+	case []interface{}:
+		for _, t := range typed {
+			if name, ok := t.(string); ok {
+				types = append(types, name)
+			}
+		}
+	}
+
+	for _, expected := range types {
+		if jsonTypeMatches(value, expected) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: %s expected", path, schemaTypeString(types))
+}
+
+func jsonTypeMatches(value interface{}, expected string) bool {
+	switch expected {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+
+	return false
+}
+
+func schemaTypeString(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	data, _ := json.Marshal(types)
+
+	return string(data)
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	number, ok := schema[key].(float64)
+	return number, ok
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["action", "args"],
+		"additionalProperties": false,
+		"properties": {
+			"action": {"type": "string", "enum": ["search", "answer"]},
+			"args": {"type": "array", "minItems": 1, "items": {"type": "string", "minLength": 1}},
+			"score": {"type": "integer", "minimum": 0, "maximum": 10}
+		}
+	}`), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for text, valid := range map[string]bool{
+		`{"action": "search", "args": ["go"], "score": 7}`:   true,
+		`{"action": "search", "args": ["go"], "score": 7.5}`: false,
+		`{"action": "search", "args": []}`:                   false,
+		`{"action": "jump", "args": ["go"]}`:                 false,
+		`{"action": "answer"}`:                               false,
+		`{"action": "answer", "args": ["x"], "extra": 1}`:    false,
+		`["search"]`: false,
+	} {
+		err = ParseJSON("Sure! Here it is: "+text, func(s string) error {
+			var value interface{}
+			if err := json.Unmarshal([]byte(s), &value); err != nil {
+				return err
+			}
+			return ValidateJSONSchema(value, schema)
+		})
+		if (err == nil) != valid {
+			t.Errorf("%s: expected valid=%v, got %v", text, valid, err)
+		}
+	}
+}
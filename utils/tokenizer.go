@@ -1,6 +1,9 @@
 package utils
 
-import "github.com/wbrown/gpt_bpe"
+import (
+	"github.com/d0rc/agent-os/tokenizers"
+	"github.com/wbrown/gpt_bpe"
+)
 
 func TokenizeGPT2(s string) ([]interface{}, error) {
 	tokenizer := gpt_bpe.NewGPT2Encoder()
@@ -24,3 +27,9 @@ func TokensToStringGPT2(iTokens []interface{}) string {
 
 	return recoveredString
 }
+
+// CountTokensGPT2 gives a rough estimate of tokens for engines which don't report usage
+func CountTokensGPT2(s string) int {
+	count, _ := tokenizers.Count(tokenizers.GPT2(), s)
+	return count
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/henomis/qdrant-go/response"
 )
 
+const qdrantDefaultSearchLimit = 10
+
 type QdrantClient struct {
 	client *qdrantgo.Client
 }
@@ -70,17 +72,40 @@ func (q *QdrantClient) InsertVectors(collection string, vectors []*Vector) error
 func (q *QdrantClient) FindNeighborhoods(collection string, vector *Vector, params *SearchSettings) ([]*Vector, error) {
 	resp := &response.PointSearch{}
 
+	if params == nil {
+		params = &SearchSettings{}
+	}
+
+	limit := params.Limit
+	if limit == 0 {
+		limit = qdrantDefaultSearchLimit
+	}
+
+	var scoreThreshold *float64
+	if params.MinScore != 0 {
+		scoreThreshold = &params.MinScore
+	}
+
+	filter := request.Filter{}
+	for key, value := range params.Payload {
+		filter.Must = append(filter.Must, request.M{
+			"key":   key,
+			"match": request.M{"value": value},
+		})
+	}
+
+	withPayload := true
 	err := q.client.PointSearch(context.Background(), &request.PointSearch{
 		CollectionName: collection,
 		Consistency:    nil,
 		Vector:         vector.VecF64,
-		Filter:         request.Filter{},
+		Filter:         filter,
 		Params:         nil,
-		Limit:          0,
+		Limit:          limit,
 		Offset:         0,
-		WithPayload:    nil,
+		WithPayload:    &withPayload,
 		WithVector:     nil,
-		ScoreThreshold: nil,
+		ScoreThreshold: scoreThreshold,
 	}, resp)
 	if err != nil {
 		return nil, err
@@ -89,8 +114,10 @@ func (q *QdrantClient) FindNeighborhoods(collection string, vector *Vector, para
 	result := make([]*Vector, len(resp.Result))
 	for idx, point := range resp.Result {
 		result[idx] = &Vector{
-			Id:     point.ID,
-			VecF64: point.Vector,
+			Id:      point.ID,
+			VecF64:  point.Vector,
+			Payload: point.Payload,
+			Score:   point.Score,
 		}
 	}
 
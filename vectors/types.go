@@ -5,10 +5,14 @@ type Vector struct {
 	VecF64  []float64              `json:"vecF64"`
 	Model   *string                `json:"model"`
 	Payload map[string]interface{} `json:"payload"`
+	Score   float64                `json:"score,omitempty"` // similarity to the query, set by search
 }
 
 type SearchSettings struct {
-	Radius float32
+	Radius   float32
+	Limit    int                    // max number of neighbours, 0 - default of the storage
+	MinScore float64                // min similarity of neighbours, 0 - any
+	Payload  map[string]interface{} // neighbours should have these payload values
 }
 
 type DistanceMeasureType string